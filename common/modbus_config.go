@@ -6,6 +6,7 @@ type ModBusConfig struct {
 	Frequency int64        `json:"frequency" validate:"required" title:"采集频率" info:""`
 	Config    interface{}  `json:"config" validate:"required" title:"工作模式" info:""`
 	Registers []RegisterRW `json:"registers" validate:"required" title:"寄存器配置" info:""`
+	Points    PointTable   `json:"points" title:"点位表" info:"配置以后输出解析好的工程值"`
 }
 
 const (
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+*
+* 点位表: 把采集到的原始字节解析成带名字的工程值,
+* Modbus 和西门子 PLC 共用
+*
+ */
+const (
+	POINT_INT16   = "int16"
+	POINT_UINT16  = "uint16"
+	POINT_INT32   = "int32"
+	POINT_FLOAT32 = "float32"
+	POINT_FLOAT64 = "float64"
+	POINT_BOOL    = "bool"
+	POINT_STRING  = "string"
+)
+
+// 字节序和字序
+const (
+	BIG_ENDIAN    = "big"
+	LITTLE_ENDIAN = "little"
+)
+
+//
+// 一个点位, Tag 是采集区(寄存器或者DB块)的 tag, Address 是在采集区里面的字节偏移,
+// 工程值 = 原始值 * Scale + Offset
+//
+type DataPoint struct {
+	Name      string  `json:"name" validate:"required" title:"点位名称" info:""`
+	Tag       string  `json:"tag" validate:"required" title:"采集区" info:"寄存器或者DB块的tag"`
+	Address   int     `json:"address" title:"字节偏移" info:"在采集区里面的字节偏移"`
+	Bit       uint8   `json:"bit" title:"位" info:"bool 类型取字节上的第几位, 0-7"`
+	Length    int     `json:"length" title:"长度" info:"string 类型的字节长度"`
+	DataType  string  `json:"dataType" validate:"required" title:"数据类型" info:"int16/uint16/int32/float32/float64/bool/string"`
+	ByteOrder string  `json:"byteOrder" title:"字节序" info:"big/little, 默认 big"`
+	WordOrder string  `json:"wordOrder" title:"字序" info:"big/little, 默认 big"`
+	Scale     float64 `json:"scale" title:"倍率" info:"默认 1"`
+	Offset    float64 `json:"offset" title:"偏移量" info:""`
+	Unit      string  `json:"unit" title:"单位" info:""`
+}
+
+//
+// 解析出来的工程值
+//
+type PointValue struct {
+	Value interface{} `json:"value"`
+	Unit  string      `json:"unit"`
+}
+
+type PointTable []DataPoint
+
+/*
+*
+* 检查点位表, areas 是已经配置的采集区 tag 和采集到的字节数
+*
+ */
+func (t PointTable) Validate(areas map[string]int) error {
+	names := map[string]bool{}
+	for _, p := range t {
+		if p.Name == "" {
+			return errors.New("point name can not be empty")
+		}
+		if names[p.Name] {
+			return errors.New("duplicate point name:" + p.Name)
+		}
+		names[p.Name] = true
+		areaSize, ok := areas[p.Tag]
+		if !ok {
+			return fmt.Errorf("point %s: tag not exists:%s", p.Name, p.Tag)
+		}
+		if p.Address < 0 {
+			return fmt.Errorf("point %s: invalid address:%d", p.Name, p.Address)
+		}
+		size, err := p.size()
+		if err != nil {
+			return err
+		}
+		if p.Address+size > areaSize {
+			return fmt.Errorf("point %s: address out of range, need %d bytes at %d, tag %s only has %d",
+				p.Name, size, p.Address, p.Tag, areaSize)
+		}
+		if p.DataType == POINT_BOOL && p.Bit > 7 {
+			return fmt.Errorf("point %s: bit must be 0-7", p.Name)
+		}
+		if !validOrder(p.ByteOrder) || !validOrder(p.WordOrder) {
+			return fmt.Errorf("point %s: order must be one of 'big' or 'little'", p.Name)
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 解析点位, blocks 是采集区 tag 和原始字节; 采集区不在 blocks 里面的点位跳过;
+* 解析失败的点位也跳过, 返回的错误只是说明哪些点位没解析, 其他点位的值照样可以用
+*
+ */
+func (t PointTable) Decode(blocks map[string][]byte) (map[string]PointValue, error) {
+	values := map[string]PointValue{}
+	errs := []string{}
+	for _, p := range t {
+		raw, ok := blocks[p.Tag]
+		if !ok {
+			continue
+		}
+		value, err := p.Decode(raw)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		values[p.Name] = PointValue{Value: value, Unit: p.Unit}
+	}
+	if len(errs) > 0 {
+		return values, errors.New(strings.Join(errs, "; "))
+	}
+	return values, nil
+}
+
+//
+// Modbus 读回来的字节数: 线圈和离散输入一位一个, 寄存器两个字节一个
+//
+func ModbusAreaSize(function int, quantity uint16) int {
+	if function == READ_COIL || function == READ_DISCRETE_INPUT {
+		return (int(quantity) + 7) / 8
+	}
+	return int(quantity) * 2
+}
+
+/*
+*
+* 从采集区的原始字节里面解析出工程值
+*
+ */
+func (p DataPoint) Decode(raw []byte) (interface{}, error) {
+	size, err := p.size()
+	if err != nil {
+		return nil, err
+	}
+	if p.Address < 0 || p.Address+size > len(raw) {
+		return nil, fmt.Errorf("point %s: address out of range, need %d bytes at %d, got %d",
+			p.Name, size, p.Address, len(raw))
+	}
+	b := make([]byte, size)
+	copy(b, raw[p.Address:p.Address+size])
+	switch p.DataType {
+	case POINT_BOOL:
+		return BitToBool(b[0], p.Bit), nil
+	case POINT_STRING:
+		return string(bytes.TrimRight(b, "\x00")), nil
+	}
+	b = p.normalize(b)
+	var v float64
+	switch p.DataType {
+	case POINT_INT16:
+		v = float64(int16(binary.BigEndian.Uint16(b)))
+	case POINT_UINT16:
+		v = float64(binary.BigEndian.Uint16(b))
+	case POINT_INT32:
+		v = float64(int32(binary.BigEndian.Uint32(b)))
+	case POINT_FLOAT32:
+		v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case POINT_FLOAT64:
+		v = math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v*scale + p.Offset, nil
+}
+
+//
+// 按照字序和字节序调整成大端: 一个字是两个字节, 对应一个 Modbus 寄存器
+//
+func (p DataPoint) normalize(b []byte) []byte {
+	if p.WordOrder == LITTLE_ENDIAN {
+		for i, j := 0, len(b)-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	if p.ByteOrder == LITTLE_ENDIAN {
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+	return b
+}
+
+func (p DataPoint) size() (int, error) {
+	switch p.DataType {
+	case POINT_BOOL:
+		return 1, nil
+	case POINT_INT16, POINT_UINT16:
+		return 2, nil
+	case POINT_INT32, POINT_FLOAT32:
+		return 4, nil
+	case POINT_FLOAT64:
+		return 8, nil
+	case POINT_STRING:
+		if p.Length <= 0 {
+			return 0, fmt.Errorf("point %s: string length must be greater than 0", p.Name)
+		}
+		return p.Length, nil
+	}
+	return 0, fmt.Errorf("point %s: unsupported data type:%s", p.Name, p.DataType)
+}
+
+func validOrder(order string) bool {
+	return order == "" || order == BIG_ENDIAN || order == LITTLE_ENDIAN
+}
@@ -15,6 +15,7 @@ type S1200Config struct {
 	IdleTimeout   *int         `json:"idleTimeout" validate:"required" title:"心跳超时时间" info:""` // 5s
 	ReadFrequency *int         `json:"readFrequency" validate:"required" title:"采集频率" info:""` // 5s
 	Blocks        []S1200Block `json:"blocks" validate:"required" title:"采集配置" info:""`        // Db
+	Points        PointTable   `json:"points" title:"点位表" info:"配置以后输出解析好的工程值"`
 }
 type S1200Block struct {
 	Tag     string `json:"tag"`     // 数据tag
@@ -7,7 +7,6 @@ import (
 
 	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/typex"
-	"github.com/sirupsen/logrus"
 
 	"gopkg.in/ini.v1"
 )
@@ -36,19 +35,9 @@ func InitGlobalConfig(path string) typex.RulexConfig {
 }
 
 func SetLogLevel() {
-	switch GlobalConfig.LogLevel {
-	case "fatal":
-		glogger.GLogger.SetLevel(logrus.FatalLevel)
-	case "error":
-		glogger.GLogger.SetLevel(logrus.ErrorLevel)
-	case "warn":
-		glogger.GLogger.SetLevel(logrus.WarnLevel)
-	case "debug":
-		glogger.GLogger.SetLevel(logrus.DebugLevel)
-	case "info":
-		glogger.GLogger.SetLevel(logrus.InfoLevel)
+	if err := glogger.SetLevel(GlobalConfig.LogLevel); err != nil {
+		glogger.GLogger.Warn(err)
 	}
-
 }
 
 /*
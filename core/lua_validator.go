@@ -27,11 +27,20 @@ func ExecuteFailed(vm *lua.LState, arg lua.LValue) (interface{}, error) {
 }
 
 //
-// 执行 Actions 里面的回调函数
+// 执行 Actions 里面的回调函数, vm 来自规则的虚拟机池
+// extra: 额外参数, 比如消息元数据, 老的脚本可以忽略
 //
-func ExecuteActions(rule *typex.Rule, arg lua.LValue) (lua.LValue, error) {
+func ExecuteActions(rule *typex.Rule, vm *lua.LState, arg lua.LValue, extra ...lua.LValue) (lua.LValue, error) {
+	return ExecuteActionsTrace(rule, vm, arg, nil, extra...)
+}
+
+//
+// 执行 Actions, 每一步的结果交给 trace, 调试规则用
+//
+func ExecuteActionsTrace(rule *typex.Rule, vm *lua.LState, arg lua.LValue,
+	trace typex.PiplineTrace, extra ...lua.LValue) (lua.LValue, error) {
 	// 原始 lua 数据结构
-	luaOriginTable := rule.VM.GetGlobal(ACTIONS_KEY)
+	luaOriginTable := vm.GetGlobal(ACTIONS_KEY)
 	if luaOriginTable != nil && luaOriginTable.Type() == lua.LTTable {
 		// 断言成包含回调的 table
 		funcsTable := luaOriginTable.(*lua.LTable)
@@ -48,8 +57,8 @@ func ExecuteActions(rule *typex.Rule, arg lua.LValue) (lua.LValue, error) {
 		if err != nil {
 			return nil, err
 		}
-		if rule.Status != typex.RULE_STOP {
-			return typex.RunPipline(rule.VM, funcs, arg)
+		if rule.GetStatus() != typex.RULE_STOP {
+			return typex.RunPiplineTrace(vm, funcs, arg, trace, extra...)
 		}
 		// if stopped, log warning information
 		glogger.GLogger.Warn("Rule has stopped:" + rule.UUID)
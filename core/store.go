@@ -1,6 +1,9 @@
 package core
 
 import (
+	"io"
+	"time"
+
 	"github.com/i4de/rulex/store"
 	"github.com/i4de/rulex/typex"
 )
@@ -11,3 +14,34 @@ func StartStore(maxSize int) {
 	GlobalStore = store.NewRulexStore(maxSize)
 
 }
+
+//
+// 按配置文件启动缓存器: memory 或者 file
+//
+func StartConfiguredStore() error {
+	if GlobalConfig.StoreType != "file" {
+		StartStore(GlobalConfig.MaxStoreSize)
+		return nil
+	}
+	s, err := store.NewPersistentStore(GlobalConfig.MaxStoreSize, store.PersistentConfig{
+		Path:             GlobalConfig.StorePath,
+		SyncMode:         GlobalConfig.StoreSyncMode,
+		SnapshotInterval: time.Duration(GlobalConfig.StoreSnapshotInterval) * time.Millisecond,
+		MaxDiskSize:      GlobalConfig.StoreMaxDiskSize,
+	})
+	if err != nil {
+		return err
+	}
+	GlobalStore = s
+	return nil
+}
+
+//
+// 停止缓存器, 持久化的缓存会最后写一次磁盘
+//
+func StopStore() error {
+	if closer, ok := GlobalStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	golog "log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -55,6 +56,13 @@ func (mdev *generic_modbus_device) Init(devId string, configMap map[string]inter
 	if !((mdev.mainConfig.Mode == "RTU") || (mdev.mainConfig.Mode == "TCP")) {
 		return errors.New("unsupported mode, only can be one of 'TCP' or 'RTU'")
 	}
+	areas := map[string]int{}
+	for _, r := range mdev.mainConfig.Registers {
+		areas[r.Tag] = common.ModbusAreaSize(r.Function, r.Quantity)
+	}
+	if err := mdev.mainConfig.Points.Validate(areas); err != nil {
+		return err
+	}
 	if mdev.mainConfig.Mode == "TCP" {
 		if errs := mapstructure.Decode(mdev.mainConfig.Config, &mdev.tcpConfig); errs != nil {
 			glogger.GLogger.Error(errs)
@@ -92,7 +100,7 @@ func (mdev *generic_modbus_device) Start(cctx typex.CCTX) error {
 		}
 		client := modbus.NewClient(mdev.rtuHandler)
 		mdev.driver = driver.NewModBusRtuDriver(mdev.Details(),
-			mdev.RuleEngine, mdev.mainConfig.Registers, mdev.rtuHandler, client)
+			mdev.RuleEngine, mdev.mainConfig.Registers, mdev.mainConfig.Points, mdev.rtuHandler, client)
 	}
 	if mdev.mainConfig.Mode == "TCP" {
 		mdev.tcpHandler = modbus.NewTCPClientHandler(
@@ -107,12 +115,19 @@ func (mdev *generic_modbus_device) Start(cctx typex.CCTX) error {
 		}
 		client := modbus.NewClient(mdev.tcpHandler)
 		mdev.driver = driver.NewModBusTCPDriver(mdev.Details(),
-			mdev.RuleEngine, mdev.mainConfig.Registers, mdev.tcpHandler, client)
+			mdev.RuleEngine, mdev.mainConfig.Registers, mdev.mainConfig.Points, mdev.tcpHandler, client)
 	}
 	//---------------------------------------------------------------------------------
 	// Start
 	//---------------------------------------------------------------------------------
 	mdev.status = typex.DEV_RUNNING
+	// 信封里带上寄存器和从机信息, 规则里面可以知道数据是哪来的
+	tags := []string{}
+	slaverIds := []string{}
+	for _, r := range mdev.mainConfig.Registers {
+		tags = append(tags, r.Tag)
+		slaverIds = append(slaverIds, fmt.Sprintf("%v", r.SlaverId))
+	}
 
 	go func(ctx context.Context, Driver typex.XExternalDriver) {
 		ticker := time.NewTicker(time.Duration(5) * time.Second)
@@ -136,7 +151,11 @@ func (mdev *generic_modbus_device) Start(cctx typex.CCTX) error {
 			if err != nil {
 				glogger.GLogger.Error(err)
 			} else {
-				mdev.RuleEngine.WorkDevice(mdev.Details(), string(buffer[:n]))
+				envelope := typex.NewEnvelope(append([]byte{}, buffer[:n]...))
+				envelope.ContentType = "application/json"
+				envelope.SetHeader("tags", strings.Join(tags, ","))
+				envelope.SetHeader("slaverIds", strings.Join(slaverIds, ","))
+				mdev.RuleEngine.WorkDeviceEnvelope(mdev.Details(), envelope)
 			}
 		}
 
@@ -3,6 +3,7 @@ package device
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	golog "log"
 	"os"
 	"sync"
@@ -130,7 +131,10 @@ func (ther *rtu485_ther) Start(cctx typex.CCTX) error {
 						"slaveId": handler.SlaveId,
 						"data":    sdata,
 					})
-					ther.RuleEngine.WorkDevice(Device, string(bytes))
+					envelope := typex.NewEnvelope(bytes)
+					envelope.ContentType = "application/json"
+					envelope.SetHeader("slaverId", fmt.Sprintf("%v", slaverId))
+					ther.RuleEngine.WorkDeviceEnvelope(Device, envelope)
 				}
 			}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -45,6 +46,14 @@ func (s1200 *s1200plc) Init(devId string, configMap map[string]interface{}) erro
 		glogger.GLogger.Error(err)
 		return err
 	}
+	areas := map[string]int{}
+	for _, b := range s1200.mainConfig.Blocks {
+		areas[b.Tag] = b.Size
+	}
+	if err := s1200.mainConfig.Points.Validate(areas); err != nil {
+		return err
+	}
+	s1200.block = s1200.mainConfig.Blocks
 	return nil
 }
 
@@ -64,9 +73,14 @@ func (s1200 *s1200plc) Start(cctx typex.CCTX) error {
 	handler.Timeout = time.Duration(*s1200.mainConfig.Timeout) * time.Second
 	handler.IdleTimeout = time.Duration(*s1200.mainConfig.IdleTimeout) * time.Second
 	s1200.client = gos7.NewClient(handler)
-	s1200.driver = driver.NewS1200Driver(s1200.Details(), s1200.RuleEngine, s1200.client, s1200.block)
+	s1200.driver = driver.NewS1200Driver(s1200.Details(), s1200.RuleEngine, s1200.client,
+		s1200.block, s1200.mainConfig.Points)
 	ticker := time.NewTicker(time.Duration(*s1200.mainConfig.ReadFrequency) * time.Second)
 
+	tags := []string{}
+	for _, b := range s1200.block {
+		tags = append(tags, b.Tag)
+	}
 	go func(ctx context.Context) {
 		// 数据缓冲区,最大4KB
 		dataBuffer := make([]byte, common.T_4KB)
@@ -92,9 +106,12 @@ func (s1200 *s1200plc) Start(cctx typex.CCTX) error {
 				glogger.GLogger.Error(err)
 				return
 			}
-			ok, err := s1200.RuleEngine.WorkDevice(
+			envelope := typex.NewEnvelope(append([]byte{}, dataBuffer[:n]...))
+			envelope.ContentType = "application/json"
+			envelope.SetHeader("tags", strings.Join(tags, ","))
+			ok, err := s1200.RuleEngine.WorkDeviceEnvelope(
 				s1200.RuleEngine.GetDevice(s1200.PointId),
-				string(dataBuffer[:n]),
+				envelope,
 			)
 			if !ok {
 				glogger.GLogger.Error(err)
@@ -2,6 +2,7 @@ package device
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -99,7 +100,10 @@ func (tss *tss200_v_0_2_sensor) Start(cctx typex.CCTX) error {
 				if err != nil {
 					glogger.GLogger.Error(err)
 				} else {
-					tss.RuleEngine.WorkDevice(tss.RuleEngine.GetDevice(tss.PointId), string(buffer[:n]))
+					envelope := typex.NewEnvelope(append([]byte{}, buffer[:n]...))
+					envelope.ContentType = "application/json"
+					envelope.SetHeader("slaverId", fmt.Sprintf("%v", slaverId))
+					tss.RuleEngine.WorkDeviceEnvelope(tss.RuleEngine.GetDevice(tss.PointId), envelope)
 				}
 			}
 
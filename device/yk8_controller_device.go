@@ -2,6 +2,7 @@ package device
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -99,7 +100,10 @@ func (yk8 *YK8Controller) Start(cctx typex.CCTX) error {
 					glogger.GLogger.Error(err)
 				} else {
 					td := yk8.RuleEngine.GetDevice(yk8.PointId)
-					yk8.RuleEngine.WorkDevice(td, string(buffer[:n]))
+					envelope := typex.NewEnvelope(append([]byte{}, buffer[:n]...))
+					envelope.ContentType = "application/json"
+					envelope.SetHeader("slaverId", fmt.Sprintf("%v", slaverId))
+					yk8.RuleEngine.WorkDeviceEnvelope(td, envelope)
 				}
 			}
 
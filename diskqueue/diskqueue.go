@@ -0,0 +1,455 @@
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+)
+
+//
+// 本地磁盘队列: 每条消息一个文件, 文件名是递增的序号, 先写临时文件再改名, 断电也不会产生半条消息;
+// 设置了同步间隔以后临时文件攒一批再 fsync 和改名, 断电最多丢一个间隔内的消息
+//
+// 文件格式: [8字节时间戳(UnixNano, 大端)][消息体]
+//
+const _MSG_SUFFIX string = ".msg"
+const _TMP_SUFFIX string = ".tmp"
+const _HEADER_SIZE int = 8
+
+var ErrEmpty = errors.New("disk queue is empty")
+
+/*
+*
+* 磁盘里的一条消息
+*
+ */
+type Message struct {
+	Seq  uint64
+	Ts   time.Time
+	Data []byte
+}
+
+/*
+*
+* DiskQueue: 有界的磁盘队列, 超过大小或者超过时间的旧消息会被丢弃
+*
+ */
+type DiskQueue struct {
+	lock     sync.Mutex
+	dir      string
+	maxBytes int64         // 0 表示不限制
+	maxAge   time.Duration // 0 表示不限制
+	seqs     []uint64
+	sizes    map[uint64]int64
+	bytes    int64
+	nextSeq  uint64
+	dropped  uint64
+	// 批量同步
+	syncInterval time.Duration   // 0 表示每条消息都 fsync
+	unsynced     map[uint64]bool // 还是临时文件的消息
+	syncTimer    *time.Timer
+}
+
+/*
+*
+* 打开(或者新建)一个磁盘队列, 已有的消息会被重新加载
+*
+ */
+func Open(dir string, maxBytes int64, maxAge time.Duration) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		seqs:     []uint64{},
+		sizes:    map[uint64]int64{},
+		nextSeq:  1,
+		unsynced: map[uint64]bool{},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		// 没写完的消息直接删掉
+		if strings.HasSuffix(name, _TMP_SUFFIX) {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, _MSG_SUFFIX) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, _MSG_SUFFIX), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+		q.sizes[seq] = f.Size()
+		q.bytes += f.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	return q, nil
+}
+
+func (q *DiskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, _MSG_SUFFIX))
+}
+
+//
+// 消息当前所在的文件, 还没同步的消息在临时文件里面
+//
+func (q *DiskQueue) file(seq uint64) string {
+	if q.unsynced[seq] {
+		return q.path(seq) + _TMP_SUFFIX
+	}
+	return q.path(seq)
+}
+
+/*
+*
+* 设置批量同步的间隔, 0 表示每条消息都同步
+*
+ */
+func (q *DiskQueue) SetSyncInterval(d time.Duration) {
+	q.lock.Lock()
+	q.syncInterval = d
+	q.lock.Unlock()
+	if d <= 0 {
+		q.Sync()
+	}
+}
+
+/*
+*
+* 把还没同步的消息 fsync 以后改成正式文件, 最后同步一次目录
+*
+ */
+func (q *DiskQueue) Sync() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.syncTimer != nil {
+		q.syncTimer.Stop()
+		q.syncTimer = nil
+	}
+	if len(q.unsynced) == 0 {
+		return nil
+	}
+	var lastErr error
+	for seq := range q.unsynced {
+		tmp := q.path(seq) + _TMP_SUFFIX
+		if err := syncFile(tmp); err != nil {
+			lastErr = err
+			continue
+		}
+		if err := os.Rename(tmp, q.path(seq)); err != nil {
+			lastErr = err
+			continue
+		}
+		delete(q.unsynced, seq)
+	}
+	// 改名以后目录也要同步, 有的系统不支持同步目录, 忽略错误
+	syncFile(q.dir)
+	return lastErr
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+/*
+*
+* 写入一条消息, 空间不够的时候先丢弃最旧的消息
+*
+ */
+func (q *DiskQueue) Push(data []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	size := int64(_HEADER_SIZE + len(data))
+	if q.maxBytes > 0 && size > q.maxBytes {
+		q.dropped++
+		return fmt.Errorf("message size %v exceed disk queue max size %v", size, q.maxBytes)
+	}
+	q.expire()
+	for q.maxBytes > 0 && q.bytes+size > q.maxBytes && len(q.seqs) > 0 {
+		q.removeHead()
+		q.dropped++
+	}
+	seq := q.nextSeq
+	buffer := make([]byte, size)
+	binary.BigEndian.PutUint64(buffer, uint64(time.Now().UnixNano()))
+	copy(buffer[_HEADER_SIZE:], data)
+	tmp := q.path(seq) + _TMP_SUFFIX
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buffer); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// 批量同步: 先留在临时文件里面, 定时器到了一起同步
+	if q.syncInterval > 0 {
+		if err := f.Close(); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		q.unsynced[seq] = true
+		if q.syncTimer == nil {
+			q.syncTimer = time.AfterFunc(q.syncInterval, func() {
+				if err := q.Sync(); err != nil {
+					glogger.GLogger.Error("Disk queue sync error:", err)
+				}
+			})
+		}
+		q.nextSeq++
+		q.seqs = append(q.seqs, seq)
+		q.sizes[seq] = size
+		q.bytes += size
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.nextSeq++
+	q.seqs = append(q.seqs, seq)
+	q.sizes[seq] = size
+	q.bytes += size
+	return nil
+}
+
+/*
+*
+* 读取最旧的一条消息, 但是不删除
+*
+ */
+func (q *DiskQueue) Peek() (Message, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.expire()
+	for len(q.seqs) > 0 {
+		seq := q.seqs[0]
+		msg, err := q.read(seq)
+		if err == nil {
+			return msg, nil
+		}
+		// 坏掉的文件丢掉, 不能卡住整个队列
+		q.removeHead()
+		q.dropped++
+	}
+	return Message{}, ErrEmpty
+}
+
+/*
+*
+* 按顺序列出最多 limit 条消息, limit <= 0 表示全部
+*
+ */
+func (q *DiskQueue) List(limit int) []Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.expire()
+	msgs := []Message{}
+	for _, seq := range q.seqs {
+		if limit > 0 && len(msgs) >= limit {
+			break
+		}
+		if msg, err := q.read(seq); err == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+/*
+*
+* 读取指定序号的消息
+*
+ */
+func (q *DiskQueue) Get(seq uint64) (Message, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.sizes[seq]; !ok {
+		return Message{}, fmt.Errorf("message not exists: %v", seq)
+	}
+	return q.read(seq)
+}
+
+/*
+*
+* 删除指定序号的消息
+*
+ */
+func (q *DiskQueue) Remove(seq uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	size, ok := q.sizes[seq]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(q.file(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(q.unsynced, seq)
+	delete(q.sizes, seq)
+	q.bytes -= size
+	for i, s := range q.seqs {
+		if s == seq {
+			q.seqs = append(q.seqs[:i], q.seqs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 清空队列并且删除目录
+*
+ */
+func (q *DiskQueue) Purge() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.seqs = []uint64{}
+	q.sizes = map[uint64]int64{}
+	q.unsynced = map[uint64]bool{}
+	q.bytes = 0
+	if q.syncTimer != nil {
+		q.syncTimer.Stop()
+		q.syncTimer = nil
+	}
+	return os.RemoveAll(q.dir)
+}
+
+// 消息数量
+func (q *DiskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.seqs)
+}
+
+// 占用磁盘字节数
+func (q *DiskQueue) Bytes() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.bytes
+}
+
+// 因为空间或者过期被丢弃的消息数
+func (q *DiskQueue) Dropped() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped
+}
+
+// 最大容量
+func (q *DiskQueue) MaxBytes() int64 {
+	return q.maxBytes
+}
+
+/*
+*
+* 最旧消息的时间, 队列为空返回零值
+*
+ */
+func (q *DiskQueue) OldestTime() time.Time {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.seqs) == 0 {
+		return time.Time{}
+	}
+	ts, err := q.readTs(q.seqs[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
+//
+// 删除过期消息, 调用者持有锁
+//
+func (q *DiskQueue) expire() {
+	if q.maxAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-q.maxAge)
+	for len(q.seqs) > 0 {
+		ts, err := q.readTs(q.seqs[0])
+		if err == nil && ts.After(deadline) {
+			return
+		}
+		q.removeHead()
+		q.dropped++
+	}
+}
+
+//
+// 删除队头, 调用者持有锁
+//
+func (q *DiskQueue) removeHead() {
+	seq := q.seqs[0]
+	os.Remove(q.file(seq))
+	delete(q.unsynced, seq)
+	q.bytes -= q.sizes[seq]
+	delete(q.sizes, seq)
+	q.seqs = q.seqs[1:]
+}
+
+func (q *DiskQueue) readTs(seq uint64) (time.Time, error) {
+	f, err := os.Open(q.file(seq))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	header := make([]byte, _HEADER_SIZE)
+	if _, err := f.Read(header); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(header))), nil
+}
+
+func (q *DiskQueue) read(seq uint64) (Message, error) {
+	b, err := ioutil.ReadFile(q.file(seq))
+	if err != nil {
+		return Message{}, err
+	}
+	if len(b) < _HEADER_SIZE {
+		return Message{}, fmt.Errorf("broken message: %v", seq)
+	}
+	return Message{
+		Seq:  seq,
+		Ts:   time.Unix(0, int64(binary.BigEndian.Uint64(b[:_HEADER_SIZE]))),
+		Data: b[_HEADER_SIZE:],
+	}, nil
+}
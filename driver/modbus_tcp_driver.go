@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	"github.com/i4de/rulex/common"
+	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/typex"
 
 	"github.com/goburrow/modbus"
@@ -21,6 +22,7 @@ type modBusTCPDriver struct {
 	client     modbus.Client
 	RuleEngine typex.RuleX
 	Registers  []common.RegisterRW
+	Points     common.PointTable
 	device     *typex.Device
 }
 
@@ -28,6 +30,7 @@ func NewModBusTCPDriver(
 	d *typex.Device,
 	e typex.RuleX,
 	Registers []common.RegisterRW,
+	Points common.PointTable,
 	handler *modbus.TCPClientHandler,
 	client modbus.Client) typex.XExternalDriver {
 	return &modBusTCPDriver{
@@ -37,6 +40,7 @@ func NewModBusTCPDriver(
 		client:     client,
 		handler:    handler,
 		Registers:  Registers,
+		Points:     Points,
 	}
 
 }
@@ -58,6 +62,7 @@ func (d *modBusTCPDriver) State() typex.DriverState {
 
 func (d *modBusTCPDriver) Read(data []byte) (int, error) {
 	datas := map[string]common.RegisterRW{}
+	raws := map[string][]byte{}
 	for _, r := range d.Registers {
 		d.handler.SlaveId = r.SlaverId
 		if r.Function == common.READ_COIL {
@@ -74,6 +79,7 @@ func (d *modBusTCPDriver) Read(data []byte) (int, error) {
 				Value:    string(results),
 			}
 			datas[r.Tag] = value
+			raws[r.Tag] = results
 		}
 		if r.Function == common.READ_DISCRETE_INPUT {
 			results, err := d.client.ReadDiscreteInputs(r.Address, r.Quantity)
@@ -89,6 +95,7 @@ func (d *modBusTCPDriver) Read(data []byte) (int, error) {
 				Value:    string(results),
 			}
 			datas[r.Tag] = value
+			raws[r.Tag] = results
 
 		}
 		if r.Function == common.READ_HOLDING_REGISTERS {
@@ -105,6 +112,7 @@ func (d *modBusTCPDriver) Read(data []byte) (int, error) {
 				Value:    string(results),
 			}
 			datas[r.Tag] = value
+			raws[r.Tag] = results
 		}
 		if r.Function == common.READ_INPUT_REGISTERS {
 			results, err := d.client.ReadInputRegisters(r.Address, r.Quantity)
@@ -120,9 +128,21 @@ func (d *modBusTCPDriver) Read(data []byte) (int, error) {
 				Value:    string(results),
 			}
 			datas[r.Tag] = value
+			raws[r.Tag] = results
 		}
 
 	}
+	// 配置了点位表就输出解析以后的工程值
+	if len(d.Points) > 0 {
+		// 一个点位解析失败不影响整个设备, 跳过就行
+		values, err := d.Points.Decode(raws)
+		if err != nil {
+			glogger.GLogger.Error("Point decode error:", err)
+		}
+		bytes, _ := json.Marshal(values)
+		copy(data, bytes)
+		return len(bytes), nil
+	}
 	bytes, _ := json.Marshal(datas)
 	copy(data, bytes)
 	return len(bytes), nil
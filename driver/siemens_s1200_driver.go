@@ -21,18 +21,21 @@ type siemens_s1200_driver struct {
 	device     *typex.Device
 	RuleEngine typex.RuleX
 	dbs        []common.S1200Block // PLC 的DB块
+	points     common.PointTable
 }
 
 func NewS1200Driver(d *typex.Device,
 	e typex.RuleX,
 	s7client gos7.Client,
-	dbs []common.S1200Block) typex.XExternalDriver {
+	dbs []common.S1200Block,
+	points common.PointTable) typex.XExternalDriver {
 	return &siemens_s1200_driver{
 		state:      typex.DRIVER_STOP,
 		device:     d,
 		RuleEngine: e,
 		s7client:   s7client,
 		dbs:        dbs,
+		points:     points,
 	}
 }
 
@@ -69,8 +72,9 @@ func (s1200 *siemens_s1200_driver) State() typex.DriverState {
 //
 func (s1200 *siemens_s1200_driver) Read(data []byte) (int, error) {
 	values := []common.S1200BlockValue{}
+	raws := map[string][]byte{}
 	for _, db := range s1200.dbs {
-		rData := []byte{}
+		rData := make([]byte, db.Size)
 		if err := s1200.s7client.AGReadDB(db.Address, db.Start, db.Size, rData); err != nil {
 			return 0, err
 		}
@@ -81,8 +85,20 @@ func (s1200 *siemens_s1200_driver) Read(data []byte) (int, error) {
 			Size:    db.Size,
 			Value:   rData,
 		})
+		raws[db.Tag] = rData
 
 	}
+	// 配置了点位表就输出解析以后的工程值
+	if len(s1200.points) > 0 {
+		// 一个点位解析失败不影响整个设备, 跳过就行
+		points, err := s1200.points.Decode(raws)
+		if err != nil {
+			glogger.GLogger.Error("Point decode error:", err)
+		}
+		bytes, _ := json.Marshal(points)
+		copy(data, bytes)
+		return len(bytes), nil
+	}
 	bytes, _ := json.Marshal(values)
 	copy(data, bytes)
 	return len(bytes), nil
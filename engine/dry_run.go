@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/rulexlib"
+	"github.com/i4de/rulex/store"
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+//
+// 试运行默认超时时间, 防止死循环卡住接口, 单位毫秒
+//
+const DRY_RUN_DEFAULT_TIMEOUT int = 3000
+
+/*
+*
+* 试运行规则: 在独立的虚拟机里面执行, 会产生副作用的库函数都换成记录用的桩函数,
+* 不会真的发数据, 也不会改动全局缓存
+*
+ */
+func (e *RuleEngine) DryRunRule(success string, actions string, failed string,
+	limits typex.RuleLimits, payloads []string) ([]typex.DryRunResult, error) {
+	rule := typex.NewRule(nil, "dryRun", "dryRun", "dryRun",
+		[]string{}, []string{}, success, actions, failed)
+	if limits.Timeout <= 0 {
+		limits.Timeout = DRY_RUN_DEFAULT_TIMEOUT
+	}
+	rule.SetLimits(limits)
+	LoadBuildInLuaLib(e, rule)
+	var current *typex.DryRunResult
+	record := func(lib string, target string, data string) {
+		current.Outputs = append(current.Outputs, typex.DryRunOutput{Lib: lib, Target: target, Data: data})
+	}
+	output := func(lib string) func(l *lua.LState) int {
+		return func(l *lua.LState) int {
+			record(lib, l.ToString(2), l.ToString(3))
+			return 0
+		}
+	}
+	for _, lib := range []string{"DataToHttp", "DataToMqtt", "DataToOutEnd", "DataToTdEngine", "DataToMongo"} {
+		rule.AddLib(e, lib, output(lib))
+	}
+	rule.AddLib(e, "WriteDevice", func(l *lua.LState) int {
+		data := l.ToString(3)
+		record("WriteDevice", l.ToString(2), data)
+		l.Push(lua.LNumber(len(data)))
+		l.Push(lua.LNil)
+		return 2
+	})
+	rule.AddLib(e, "ReadDevice", func(l *lua.LState) int {
+		l.Push(lua.LNil)
+		l.Push(lua.LString("device read is not available in dry run:" + l.ToString(2)))
+		return 2
+	})
+	// 编解码会调用真实的 GRPC 目标, 只记录
+	for _, lib := range []string{"RPCENC", "RPCDEC"} {
+		lib := lib
+		rule.AddLib(e, lib, func(l *lua.LState) int {
+			record(lib, l.ToString(2), l.ToString(3))
+			l.Push(lua.LNil)
+			l.Push(lua.LString("codec is not available in dry run:" + l.ToString(2)))
+			return 2
+		})
+	}
+	rule.AddLib(e, "Publish", func(l *lua.LState) int {
+		record("Publish", l.ToString(2), l.ToString(3))
+		l.Push(lua.LNil)
+		return 1
+	})
+	// 影子只记录, 不更新
+	rule.AddLib(e, "SetModelValue", func(l *lua.LState) int {
+		record("SetModelValue", l.ToString(2), l.ToString(3)+"="+l.ToStringMeta(l.Get(4)).String())
+		l.Push(lua.LNil)
+		return 1
+	})
+	rule.AddLib(e, "ReportShadow", func(l *lua.LState) int {
+		data, _ := rulexlib.EncodeValue(l.Get(3))
+		record("ReportShadow", l.ToString(2), data)
+		l.Push(lua.LNil)
+		return 1
+	})
+	rule.AddLib(e, "WriteHistory", func(l *lua.LState) int {
+		value, _ := rulexlib.EncodeValue(l.Get(3))
+		tags, _ := rulexlib.EncodeValue(l.Get(4))
+		record("WriteHistory", l.ToString(2), fmt.Sprintf(`{"value":%s,"tags":%s}`, value, tags))
+		l.Push(lua.LNil)
+		return 1
+	})
+	rule.AddLib(e, "log", func(l *lua.LState) int {
+		current.Logs = append(current.Logs, l.ToString(2))
+		return 0
+	})
+	// 缓存只在本次试运行里面有效
+	vstore := store.NewRulexStore(0)
+	rule.AddLib(e, "VSet", func(l *lua.LState) int {
+		vstore.Set(l.ToString(2), l.ToString(3))
+		return 0
+	})
+	rule.AddLib(e, "VGet", func(l *lua.LState) int {
+		if v := vstore.Get(l.ToString(2)); v != "" {
+			l.Push(lua.LString(v))
+		} else {
+			l.Push(lua.LNil)
+		}
+		return 1
+	})
+	rule.AddLib(e, "VDel", func(l *lua.LState) int {
+		vstore.Delete(l.ToString(2))
+		return 0
+	})
+	rule.AddLib(e, "VSetTTL", func(l *lua.LState) int {
+		ttl := float64(l.ToNumber(4)) * float64(time.Second)
+		vstore.SetWithTTL(l.ToString(2), l.ToString(3), time.Duration(ttl))
+		return 0
+	})
+	rule.AddLib(e, "VKeys", func(l *lua.LState) int {
+		table := l.NewTable()
+		for _, k := range vstore.FuzzyGet(l.ToString(2)) {
+			table.Append(lua.LString(k))
+		}
+		l.Push(table)
+		return 1
+	})
+	rule.AddLib(e, "VIncr", func(l *lua.LState) int {
+		v, err := vstore.Incr(l.ToString(2), l.OptInt64(3, 1))
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(lua.LNumber(v))
+		l.Push(lua.LNil)
+		return 2
+	})
+	rule.VM.SetGlobal("print", rule.VM.NewFunction(func(l *lua.LState) int {
+		args := []string{}
+		for i := 1; i <= l.GetTop(); i++ {
+			args = append(args, l.ToStringMeta(l.Get(i)).String())
+		}
+		current.Logs = append(current.Logs, strings.Join(args, "\t"))
+		return 0
+	}))
+	if err := core.VerifyCallback(rule); err != nil {
+		return nil, err
+	}
+	vm := rule.VM
+	defer typex.UnbindEnvelope(vm)
+	results := []typex.DryRunResult{}
+	for _, payload := range payloads {
+		current = &typex.DryRunResult{
+			Payload: payload,
+			Steps:   []typex.DryRunStep{},
+			Logs:    []string{},
+			Outputs: []typex.DryRunOutput{},
+		}
+		envelope := typex.NewEnvelope([]byte(payload))
+		trace := func(step int, values []lua.LValue, err error) {
+			current.Steps = append(current.Steps, typex.DryRunStep(traceStep(step, values, err)))
+		}
+		err := withRuleLimits(context.Background(), rule, vm, envelope, func() error {
+			_, err := core.ExecuteActionsTrace(rule, vm, lua.LString(payload), trace, envelope.LuaTable(vm))
+			return err
+		})
+		if err != nil {
+			current.Error = err.Error()
+			current.Callback = core.FAILED_KEY
+			if err := withRuleLimits(context.Background(), rule, vm, envelope, func() error {
+				_, err1 := core.ExecuteFailed(vm, lua.LString(current.Error))
+				return err1
+			}); err != nil {
+				current.Error = fmt.Sprintf("%v; Failed callback error: %v", current.Error, err)
+			}
+		} else {
+			current.Callback = core.SUCCESS_KEY
+			if err := withRuleLimits(context.Background(), rule, vm, envelope, func() error {
+				_, err1 := core.ExecuteSuccess(vm)
+				return err1
+			}); err != nil {
+				current.Error = "Success callback error: " + err.Error()
+			}
+		}
+		results = append(results, *current)
+	}
+	return results, nil
+}
+
+//
+// table 转成 JSON, 其他类型直接转字符串
+//
+func luaValueString(v lua.LValue) string {
+	if v == nil {
+		return ""
+	}
+	if v.Type() == lua.LTTable {
+		if s, err := rulexlib.EncodeValue(v); err == nil {
+			return s
+		}
+	}
+	return v.String()
+}
@@ -1,11 +1,14 @@
 package engine
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/glogger"
@@ -17,6 +20,7 @@ import (
 	"github.com/i4de/rulex/utils"
 
 	"github.com/shirou/gopsutil/disk"
+	"github.com/sirupsen/logrus"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -33,6 +37,8 @@ type RuleEngine struct {
 	Drivers *sync.Map          `json:"drivers"`
 	Devices *sync.Map          `json:"devices"`
 	Config  *typex.RulexConfig `json:"config"`
+	// 定时规则的取消函数
+	Schedules *sync.Map `json:"-"`
 }
 
 //
@@ -49,6 +55,8 @@ func NewRuleEngine(config typex.RulexConfig) typex.RuleX {
 		Drivers: &sync.Map{},
 		Devices: &sync.Map{},
 		Config:  &config,
+		// 定时规则
+		Schedules: &sync.Map{},
 	}
 }
 
@@ -56,12 +64,48 @@ func NewRuleEngine(config typex.RulexConfig) typex.RuleX {
 //
 //
 func (e *RuleEngine) Start() *typex.RulexConfig {
-	typex.StartQueue(core.GlobalConfig.MaxQueueSize)
+	if err := typex.StartQueue(e, typex.QueueConfig{
+		MaxQueueSize:   core.GlobalConfig.MaxQueueSize,
+		Workers:        workerPoolSize(),
+		OverflowPolicy: core.GlobalConfig.QueueOverflowPolicy,
+		BlockTimeout:   time.Duration(core.GlobalConfig.QueueBlockTimeout) * time.Millisecond,
+		SpillPath:      core.GlobalConfig.BufferPath,
+		SpillMaxSize:   core.GlobalConfig.QueueSpillMaxSize,
+	}); err != nil {
+		glogger.GLogger.Fatal("Queue start error:", err)
+	}
+	if err := typex.StartDeadLetterStore(core.GlobalConfig.BufferPath,
+		core.GlobalConfig.DeadLetterMaxSize); err != nil {
+		glogger.GLogger.Error("Dead letter store start error:", err)
+	}
+	if err := typex.StartShadowStore(core.GlobalConfig.BufferPath); err != nil {
+		glogger.GLogger.Error("Shadow store start error:", err)
+	}
+	if err := typex.StartHistoryStore(typex.HistoryConfig{
+		Path:                filepath.Join(core.GlobalConfig.BufferPath, "history"),
+		RawRetention:        time.Duration(core.GlobalConfig.HistoryRawRetention) * time.Second,
+		DownsampleInterval:  time.Duration(core.GlobalConfig.HistoryDownsample) * time.Second,
+		DownsampleRetention: time.Duration(core.GlobalConfig.HistoryRetention) * time.Second,
+		MaxSeries:           core.GlobalConfig.HistoryMaxSeries,
+		MaxPoints:           core.GlobalConfig.HistoryMaxPoints,
+	}); err != nil {
+		glogger.GLogger.Error("History store start error:", err)
+	}
 	source.LoadSt()
 	target.LoadTt()
 	return e.Config
 }
 
+//
+// 队列工作协程数量, 没配置的时候默认和CPU数量一样
+//
+func workerPoolSize() int {
+	if core.GlobalConfig.WorkerPoolSize > 0 {
+		return core.GlobalConfig.WorkerPoolSize
+	}
+	return runtime.NumCPU()
+}
+
 //
 //
 //
@@ -76,11 +120,27 @@ func (e *RuleEngine) PushQueue(qd typex.QueueData) error {
 	return err
 }
 func (e *RuleEngine) PushInQueue(in *typex.InEnd, data string) error {
+	return e.pushInEnvelope(in, typex.NewEnvelope([]byte(data)))
+}
+
+//
+// 带元数据的输入数据进队列
+//
+func (e *RuleEngine) pushInEnvelope(in *typex.InEnd, envelope *typex.Envelope) error {
+	// 暂停的时候直接丢弃
+	if in.Paused() {
+		return nil
+	}
+	if envelope.Origin == "" {
+		envelope.Origin = in.UUID
+		envelope.OriginType = typex.ORIGIN_INEND
+	}
 	qd := typex.QueueData{
-		E:    e,
-		I:    in,
-		O:    nil,
-		Data: data,
+		E:        e,
+		I:        in,
+		O:        nil,
+		Data:     envelope.String(),
+		Envelope: envelope,
 	}
 	err := typex.DefaultDataCacheQueue.Push(qd)
 	if err != nil {
@@ -89,6 +149,7 @@ func (e *RuleEngine) PushInQueue(in *typex.InEnd, data string) error {
 	} else {
 		statistics.IncIn()
 	}
+	statistics.Record(statistics.INEND, in.UUID, len(envelope.Payload), err)
 	return err
 }
 
@@ -98,12 +159,27 @@ func (e *RuleEngine) PushInQueue(in *typex.InEnd, data string) error {
 *
  */
 func (e *RuleEngine) PushDeviceQueue(Device *typex.Device, data string) error {
+	return e.pushDeviceEnvelope(Device, typex.NewEnvelope([]byte(data)))
+}
+
+//
+// 带元数据的设备数据进队列
+//
+func (e *RuleEngine) pushDeviceEnvelope(Device *typex.Device, envelope *typex.Envelope) error {
+	if Device.Paused() {
+		return nil
+	}
+	if envelope.Origin == "" {
+		envelope.Origin = Device.UUID
+		envelope.OriginType = typex.ORIGIN_DEVICE
+	}
 	qd := typex.QueueData{
-		D:    Device,
-		E:    e,
-		I:    nil,
-		O:    nil,
-		Data: data,
+		D:        Device,
+		E:        e,
+		I:        nil,
+		O:        nil,
+		Data:     envelope.String(),
+		Envelope: envelope,
 	}
 	err := typex.DefaultDataCacheQueue.Push(qd)
 	if err != nil {
@@ -112,9 +188,14 @@ func (e *RuleEngine) PushDeviceQueue(Device *typex.Device, data string) error {
 	} else {
 		statistics.IncIn()
 	}
+	statistics.Record(statistics.DEVICE, Device.UUID, len(envelope.Payload), err)
 	return err
 }
 func (e *RuleEngine) PushOutQueue(out *typex.OutEnd, data string) error {
+	// 暂停并且没有断网缓存的目标直接拒绝, 让规则知道数据没有发出去
+	if out.Paused() && out.GetBuffer() == nil {
+		return fmt.Errorf("outend paused: %v", out.UUID)
+	}
 	qd := typex.QueueData{
 		E:    e,
 		D:    nil,
@@ -132,6 +213,23 @@ func (e *RuleEngine) PushOutQueue(out *typex.OutEnd, data string) error {
 	return err
 }
 
+//
+// 内部主题的数据进队列
+//
+func (e *RuleEngine) PushTopicQueue(topic string, envelope *typex.Envelope) error {
+	qd := typex.QueueData{
+		E:        e,
+		T:        topic,
+		Data:     envelope.String(),
+		Envelope: envelope,
+	}
+	err := typex.DefaultDataCacheQueue.Push(qd)
+	if err != nil {
+		glogger.GLogger.Error("PushTopicQueue error:", err)
+	}
+	return err
+}
+
 //
 //
 //
@@ -162,17 +260,32 @@ func (e *RuleEngine) LoadRule(r *typex.Rule) error {
 	if err := core.VerifyCallback(r); err != nil {
 		return err
 	}
+	if r.Schedule != nil {
+		if err := r.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
 	e.SaveRule(r)
 	//--------------------------------------------------------------
 	// Load LoadBuildInLuaLib
 	//--------------------------------------------------------------
 	LoadBuildInLuaLib(e, r)
+	// 每个 worker 最多同时占用一个虚拟机
+	r.SetVMPoolSize(workerPoolSize())
 	glogger.GLogger.Infof("Rule [%v, %v] load successfully", r.Name, r.UUID)
+	// 订阅内部主题
+	for _, topic := range r.FromTopic {
+		typex.DefaultTopicBus.Subscribe(topic, r)
+	}
+	// 定时执行
+	if r.Schedule != nil {
+		e.startSchedule(r)
+	}
 	// 绑定输入资源
 	for _, inUUId := range r.FromSource {
 		// 查找输入定义的资源是否存在
 		if in := e.GetInEnd(inUUId); in != nil {
-			(in.BindRules)[r.UUID] = *r
+			in.BindRule(r)
 			return nil
 		} else {
 			return errors.New("'InEnd':" + inUUId + " is not exists when bind resource")
@@ -183,7 +296,7 @@ func (e *RuleEngine) LoadRule(r *typex.Rule) error {
 		// 查找输入定义的资源是否存在
 		if Device := e.GetDevice(devUUId); Device != nil {
 			// 绑定资源和规则，建立关联关系
-			(Device.BindRules)[r.UUID] = *r
+			Device.BindRule(r)
 		} else {
 			return errors.New("'Device':" + devUUId + " is not exists when bind resource")
 		}
@@ -215,35 +328,71 @@ func (e *RuleEngine) SaveRule(r *typex.Rule) {
 // RemoveRule and inend--rule bindings
 //
 func (e *RuleEngine) RemoveRule(ruleId string) {
+	if e.GetRule(ruleId) != nil {
+		e.UnloadRule(ruleId)
+		statistics.RemoveMetrics(statistics.RULE, ruleId)
+		// 追踪会话和记录也一起删掉
+		typex.DefaultRuleTracer.Stop(ruleId)
+		typex.DefaultRuleTracer.Clear(ruleId)
+		glogger.GLogger.Infof("Rule [%v] has been deleted", ruleId)
+	}
+}
+
+//
+// 卸载规则, 统计数据保留
+//
+func (e *RuleEngine) UnloadRule(ruleId string) {
 	if rule := e.GetRule(ruleId); rule != nil {
+		// 取消内部主题订阅
+		typex.DefaultTopicBus.Unsubscribe(ruleId)
+		// 停止定时执行
+		e.stopSchedule(ruleId)
 		// 清空 InEnd 的 bind 资源
 		inEnds := e.AllInEnd()
 		inEnds.Range(func(key, value interface{}) bool {
 			inEnd := value.(*typex.InEnd)
-			for _, r := range inEnd.BindRules {
-				if rule.UUID == r.UUID {
-					delete(inEnd.BindRules, ruleId)
-				}
-			}
+			inEnd.UnbindRule(ruleId)
 			return true
 		})
 		// 清空Device的绑定
 		Devices := e.AllDevices()
 		Devices.Range(func(key, value interface{}) bool {
 			Device := value.(*typex.Device)
-			for _, r := range Device.BindRules {
-				if rule.UUID == r.UUID {
-					delete(Device.BindRules, ruleId)
-				}
-			}
+			Device.UnbindRule(ruleId)
 			return true
 		})
 		e.Rules.Delete(ruleId)
 		rule = nil
-		glogger.GLogger.Infof("Rule [%v] has been deleted", ruleId)
 	}
 }
 
+//
+// 启用规则
+//
+func (e *RuleEngine) StartRule(ruleId string) error {
+	return e.setRuleStatus(ruleId, typex.RULE_RUNNING)
+}
+
+//
+// 停用规则, 规则和绑定关系都保留, 只是不再执行
+//
+func (e *RuleEngine) StopRule(ruleId string) error {
+	return e.setRuleStatus(ruleId, typex.RULE_STOP)
+}
+
+//
+// InEnd 和设备上绑定的是同一个规则, 只改状态, 不动绑定关系
+//
+func (e *RuleEngine) setRuleStatus(ruleId string, status typex.RuleStatus) error {
+	rule := e.GetRule(ruleId)
+	if rule == nil {
+		return fmt.Errorf("rule not exists: %v", ruleId)
+	}
+	rule.SetStatus(status)
+	glogger.GLogger.WithField("rule", rule.UUID).Infof("Rule [%v, %v] status changed to %v", rule.Name, rule.UUID, status)
+	return nil
+}
+
 //
 //
 //
@@ -256,6 +405,11 @@ func (e *RuleEngine) AllRule() *sync.Map {
 //
 func (e *RuleEngine) Stop() {
 	glogger.GLogger.Info("Ready to stop rulex")
+	// 停止所有定时规则
+	e.Schedules.Range(func(key, value interface{}) bool {
+		e.stopSchedule(key.(string))
+		return true
+	})
 	e.InEnds.Range(func(key, value interface{}) bool {
 		inEnd := value.(*typex.InEnd)
 		if inEnd.Source != nil {
@@ -268,9 +422,13 @@ func (e *RuleEngine) Stop() {
 		}
 		return true
 	})
+	// 输入停了以后把队列里剩下的数据处理完
+	typex.StopQueue()
 	// 停止所有外部资源
 	e.OutEnds.Range(func(key, value interface{}) bool {
 		outEnd := value.(*typex.OutEnd)
+		typex.StopOutEndBuffer(outEnd)
+		typex.StopOutEndRetry(outEnd)
 		if outEnd.Target != nil {
 			glogger.GLogger.Info("Stop Target:", outEnd.Name, outEnd.UUID)
 			outEnd.Target.Stop()
@@ -299,16 +457,36 @@ func (e *RuleEngine) Stop() {
 		return true
 	})
 
+	// 影子刷到磁盘
+	if typex.DefaultShadows != nil {
+		if err := typex.DefaultShadows.Close(); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+	// 历史数据刷到磁盘
+	if typex.DefaultHistory != nil {
+		if err := typex.DefaultHistory.Close(); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+	// 缓存器刷到磁盘
+	if err := core.StopStore(); err != nil {
+		glogger.GLogger.Error(err)
+	}
 	// 回收资源
 	runtime.Gosched()
 	runtime.GC()
 
 	glogger.GLogger.Info("Stop Rulex successfully")
-	if err := glogger.GLOBAL_LOGGER.Close(); err != nil {
-		glogger.GLogger.Error(err)
+	if glogger.GLOBAL_LOGGER != nil {
+		if err := glogger.GLOBAL_LOGGER.Close(); err != nil {
+			glogger.GLogger.Error(err)
+		}
 	}
-	if err := glogger.LUA_LOGGER.Close(); err != nil {
-		glogger.GLogger.Error(err)
+	if glogger.LUA_LOGGER != nil {
+		if err := glogger.LUA_LOGGER.Close(); err != nil {
+			glogger.GLogger.Error(err)
+		}
 	}
 }
 
@@ -332,27 +510,34 @@ func (e *RuleEngine) WorkDevice(Device *typex.Device, data string) (bool, error)
 	return true, nil
 }
 
+//
+// 带元数据的数据推流进队列
+//
+func (e *RuleEngine) WorkInEndEnvelope(in *typex.InEnd, envelope *typex.Envelope) (bool, error) {
+	if err := e.pushInEnvelope(in, envelope); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//
+// 带元数据的设备数据推流进队列
+//
+func (e *RuleEngine) WorkDeviceEnvelope(Device *typex.Device, envelope *typex.Envelope) (bool, error) {
+	if err := e.pushDeviceEnvelope(Device, envelope); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 //
 // 执行lua脚本
 //
-func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
+func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, envelope *typex.Envelope) {
 	// 执行来自资源的脚本
-	for _, rule := range in.BindRules {
-		if rule.Status == typex.RULE_RUNNING {
-			_, err := core.ExecuteActions(&rule, lua.LString(callbackArgs))
-			if err != nil {
-				glogger.GLogger.Error("RunLuaCallbacks error:", err)
-				_, err := core.ExecuteFailed(rule.VM, lua.LString(err.Error()))
-				if err != nil {
-					glogger.GLogger.Error(err)
-				}
-			} else {
-				_, err := core.ExecuteSuccess(rule.VM)
-				if err != nil {
-					glogger.GLogger.Error(err)
-					return
-				}
-			}
+	for _, rule := range in.BoundRules() {
+		if rule.GetStatus() == typex.RULE_RUNNING {
+			runRuleCallbacks(rule, envelope)
 		}
 	}
 }
@@ -360,26 +545,129 @@ func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
 //
 // 执行lua脚本
 //
-func (e *RuleEngine) RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
+func (e *RuleEngine) RunDeviceCallbacks(Device *typex.Device, envelope *typex.Envelope) {
 	// 执行来自资源的脚本
-	for _, rule := range Device.BindRules {
-		if rule.Status == typex.RULE_RUNNING {
-			_, err := core.ExecuteActions(&rule, lua.LString(callbackArgs))
-			if err != nil {
-				glogger.GLogger.Error("RunLuaCallbacks error:", err)
-				_, err := core.ExecuteFailed(rule.VM, lua.LString(err.Error()))
-				if err != nil {
-					glogger.GLogger.Error(err)
-				}
-			} else {
-				_, err := core.ExecuteSuccess(rule.VM)
-				if err != nil {
-					glogger.GLogger.Error(err)
-					return
-				}
+	for _, rule := range Device.BoundRules() {
+		if rule.GetStatus() == typex.RULE_RUNNING {
+			runRuleCallbacks(rule, envelope)
+		}
+	}
+}
+
+//
+// 执行订阅了内部主题的规则
+//
+func (e *RuleEngine) RunTopicCallbacks(topic string, envelope *typex.Envelope) {
+	for _, rule := range typex.DefaultTopicBus.Subscribers(topic) {
+		if rule.GetStatus() == typex.RULE_RUNNING {
+			runRuleCallbacks(rule, envelope)
+			typex.DefaultTopicBus.Delivered(topic)
+		}
+	}
+}
+
+//
+// 从规则的虚拟机池里面取一个虚拟机来执行回调, 多个 worker 可以同时执行同一个规则
+// Actions 的参数: (data, meta), meta 是消息的元数据
+//
+func runRuleCallbacks(rule *typex.Rule, envelope *typex.Envelope) (err error) {
+	start := time.Now()
+	defer func() {
+		statistics.Observe(statistics.RULE, rule.UUID, len(envelope.Payload), time.Since(start), err)
+	}()
+	logger := ruleLogger(rule, envelope)
+	vm, err := rule.AcquireVM()
+	if err != nil {
+		logger.Error("AcquireVM error:", err)
+		return err
+	}
+	defer rule.ReleaseVM(vm)
+	defer typex.UnbindEnvelope(vm)
+	// 规则打开了追踪的话记录执行过程
+	record := typex.DefaultRuleTracer.Begin(rule.UUID, envelope)
+	ctx := typex.WithTraceRecord(context.Background(), record)
+	var trace typex.PiplineTrace
+	if record != nil {
+		defer typex.DefaultRuleTracer.Finish(record)
+		trace = func(step int, values []lua.LValue, err error) {
+			record.AddStep(traceStep(step, values, err))
+		}
+	}
+	err = withRuleLimits(ctx, rule, vm, envelope, func() error {
+		_, err := core.ExecuteActionsTrace(rule, vm, lua.LString(envelope.String()), trace, envelope.LuaTable(vm))
+		return err
+	})
+	if err != nil {
+		logger.Error("RunLuaCallbacks error:", err)
+		if record != nil {
+			record.Callback = core.FAILED_KEY
+			record.Error = err.Error()
+		}
+		if err := withRuleLimits(ctx, rule, vm, envelope, func() error {
+			_, err1 := core.ExecuteFailed(vm, lua.LString(err.Error()))
+			return err1
+		}); err != nil {
+			logger.Error(err)
+		}
+		return err
+	} else {
+		if record != nil {
+			record.Callback = core.SUCCESS_KEY
+		}
+		err := withRuleLimits(ctx, rule, vm, envelope, func() error {
+			_, err1 := core.ExecuteSuccess(vm)
+			return err1
+		})
+		if err != nil {
+			logger.Error(err)
+			if record != nil {
+				record.Error = err.Error()
 			}
 		}
+		return err
+	}
+}
+
+//
+// 规则日志带上规则和数据来源的 UUID, 实时日志可以按规则和资源过滤
+//
+func ruleLogger(rule *typex.Rule, envelope *typex.Envelope) *logrus.Entry {
+	logger := glogger.GLogger.WithField("rule", rule.UUID)
+	if envelope.OriginType == typex.ORIGIN_INEND || envelope.OriginType == typex.ORIGIN_DEVICE {
+		logger = logger.WithField("resource", envelope.Origin)
 	}
+	return logger
+}
+
+//
+// 管道一步的返回值转成追踪记录
+//
+func traceStep(step int, values []lua.LValue, err error) typex.TraceStep {
+	s := typex.TraceStep{Step: step}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if len(values) > 0 {
+		s.Next = luaValueString(values[0])
+	}
+	if len(values) > 1 {
+		s.Output = luaValueString(values[1])
+	}
+	return s
+}
+
+//
+// 在规则的限制下执行回调: 超时通过虚拟机的 context 中断, 超过限制会计数
+// rulexlib 里面发布内部主题的时候需要知道当前消息, 所以顺便绑定信封
+//
+func withRuleLimits(ctx context.Context, rule *typex.Rule, vm *lua.LState, envelope *typex.Envelope, f func() error) error {
+	if rule.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(rule.Limits.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	typex.BindEnvelope(typex.WithRuleUUID(ctx, rule.UUID), vm, envelope)
+	return rule.CheckViolation(ctx, f())
 }
 
 //
@@ -471,8 +759,12 @@ func (e *RuleEngine) SaveInEnd(in *typex.InEnd) {
 //
 func (e *RuleEngine) RemoveInEnd(id string) {
 	if inEnd := e.GetInEnd(id); inEnd != nil {
+		if inEnd.Supervisor != nil {
+			inEnd.Supervisor.Stop()
+		}
 		inEnd.Source.Stop()
 		e.InEnds.Delete(id)
+		statistics.RemoveMetrics(statistics.INEND, id)
 		inEnd = nil
 		glogger.GLogger.Infof("InEnd [%v] has been deleted", id)
 	}
@@ -511,9 +803,15 @@ func (e *RuleEngine) SaveOutEnd(out *typex.OutEnd) {
 //
 func (e *RuleEngine) RemoveOutEnd(uuid string) {
 	if outEnd := e.GetOutEnd(uuid); outEnd != nil {
+		if outEnd.Supervisor != nil {
+			outEnd.Supervisor.Stop()
+		}
+		typex.StopOutEndBuffer(outEnd)
+		typex.StopOutEndRetry(outEnd)
 		if outEnd.Target != nil {
 			outEnd.Target.Stop()
 			e.OutEnds.Delete(uuid)
+			statistics.RemoveMetrics(statistics.OUTEND, uuid)
 			outEnd = nil
 		}
 		glogger.GLogger.Infof("InEnd [%v] has been deleted", uuid)
@@ -577,6 +875,8 @@ func (e *RuleEngine) SnapshotDump() string {
 		"outends":    outends,
 		"devices":    devices,
 		"statistics": statistics.AllStatistics(),
+		"metrics":    statistics.AllMetrics(),
+		"queue":      typex.DefaultDataCacheQueue.Statistics(),
 		"system":     system,
 		"config":     core.GlobalConfig,
 	}
@@ -636,12 +936,16 @@ func (e *RuleEngine) PickUpProcess(uuid string) *sidecar.GoodsProcess {
 }
 
 //
-// 重启源
+// 重启源, InEnd 本身不变, 所以 UUID 和规则绑定都保留
 //
 func (e *RuleEngine) RestartInEnd(uuid string) error {
 	if value, ok := e.InEnds.Load(uuid); ok {
 		o := (value.(*typex.InEnd))
-		if o.State == typex.SOURCE_UP {
+		// 重新加载的时候会新建守护协程
+		if o.Supervisor != nil {
+			o.Supervisor.Stop()
+		}
+		if o.GetState() == typex.SOURCE_UP {
 			o.Source.Stop()
 		}
 		if err := e.LoadInEnd(o); err != nil {
@@ -659,7 +963,11 @@ func (e *RuleEngine) RestartInEnd(uuid string) error {
 func (e *RuleEngine) RestartOutEnd(uuid string) error {
 	if value, ok := e.OutEnds.Load(uuid); ok {
 		o := (value.(*typex.OutEnd))
-		if o.State == typex.SOURCE_UP {
+		if o.Supervisor != nil {
+			o.Supervisor.Stop()
+		}
+		typex.StopOutEndBuffer(o)
+		if o.GetState() == typex.SOURCE_UP {
 			o.Target.Stop()
 		}
 		if err := e.LoadOutEnd(o); err != nil {
@@ -677,7 +985,7 @@ func (e *RuleEngine) RestartOutEnd(uuid string) error {
 func (e *RuleEngine) RestartDevice(uuid string) error {
 	if value, ok := e.Devices.Load(uuid); ok {
 		o := (value.(*typex.Device))
-		if o.State == typex.DEV_RUNNING {
+		if o.GetState() == typex.DEV_RUNNING {
 			o.Device.Stop()
 		}
 		if err := e.LoadDevice(o); err != nil {
@@ -1,15 +1,14 @@
 package engine
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"runtime"
 	"sync"
-	"time"
 
+	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/device"
 	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/statistics"
 	"github.com/i4de/rulex/typex"
 )
 
@@ -50,10 +49,14 @@ func (e *RuleEngine) AllDevices() *sync.Map {
 //
 func (e *RuleEngine) RemoveDevice(uuid string) {
 	if dev := e.GetDevice(uuid); dev != nil {
+		if dev.Supervisor != nil {
+			dev.Supervisor.Stop()
+		}
 		glogger.GLogger.Infof("Device [%v] ready to stop", uuid)
 		dev.Device.Stop()
 		glogger.GLogger.Infof("Device [%v] has been stopped", uuid)
 		e.Devices.Delete(uuid)
+		statistics.RemoveMetrics(statistics.DEVICE, uuid)
 		dev = nil
 		glogger.GLogger.Infof("Device [%v] has been deleted", uuid)
 	}
@@ -109,31 +112,42 @@ func startDevices(abstractDevice typex.XDevice, deviceInfo *typex.Device, e *Rul
 		e.RemoveDevice(deviceInfo.UUID)
 		return err
 	}
-	ticker := time.NewTicker(time.Duration(time.Second * 5))
-	go func(ctx context.Context) {
-		// 5 seconds
-	TICKER:
-		<-ticker.C
-		select {
-		case <-ctx.Done():
-			{
-				return
+	// 挂了以后按照策略重启
+	supervisor := typex.NewSupervisor(typex.NewRestartPolicy(core.GlobalConfig))
+	deviceInfo.Supervisor = supervisor
+	supervisor.Start(typex.SupervisorHooks{
+		Alive: func() bool {
+			return abstractDevice.Details() != nil
+		},
+		Healthy: func() bool {
+			if abstractDevice.Details().Paused() {
+				return true
 			}
-		default:
-			{
-				goto CHECK
+			checkDeviceDriverState(abstractDevice)
+			// 当内存里面的设备状态已经停止的时候，及时更新数据库里的
+			if abstractDevice.Status() == typex.DEV_RUNNING {
+				reconnected := abstractDevice.Details().GetState() != typex.DEV_RUNNING
+				abstractDevice.Details().UpdateState(typex.DEV_RUNNING)
+				// 设备重新上线, 补发之前没下发成功的影子差异
+				if reconnected && typex.DefaultShadows != nil {
+					typex.DefaultShadows.Redeliver(e, deviceInfo.UUID)
+				}
+				return true
 			}
-		}
-	CHECK:
-		{
-			if abstractDevice.Details() == nil {
-				return
+			if !supervisor.Failed() {
+				abstractDevice.Details().UpdateState(typex.DEV_STOP)
 			}
-			tryIfRestartDevice(abstractDevice, e, deviceInfo.UUID)
-			goto TICKER
-		}
-
-	}(typex.GCTX)
+			return false
+		},
+		Restart: func() error {
+			return restartDevice(abstractDevice, e)
+		},
+		Failed: func() {
+			glogger.GLogger.WithField("resource", deviceInfo.UUID).Errorf("Device %v %v restart failed too many times",
+				deviceInfo.UUID, deviceInfo.Name)
+			abstractDevice.Details().UpdateState(typex.DEV_FAILED)
+		},
+	})
 	glogger.GLogger.Infof("device [%v, %v] load successfully", deviceInfo.Name, deviceInfo.UUID)
 	return nil
 }
@@ -177,21 +191,14 @@ func startDevice(abstractDevice typex.XDevice, e *RuleEngine) error {
 	return nil
 }
 
-func tryIfRestartDevice(abstractDevice typex.XDevice, e *RuleEngine, devId string) {
-	checkDeviceDriverState(abstractDevice)
-	// 当内存里面的设备状态已经停止的时候，及时更新数据库里的
-	// 此处本质上是个同步过程
-	if abstractDevice.Status() == typex.DEV_STOP {
-		abstractDevice.Details().State = typex.DEV_STOP
-		glogger.GLogger.Warnf("Device %v %v down. try to restart it", abstractDevice.Details().UUID, abstractDevice.Details().Name)
-		abstractDevice.Stop()
-		runtime.Gosched()
-		runtime.GC()
-		startDevice(abstractDevice, e)
-	} else {
-		abstractDevice.Details().State = typex.DEV_RUNNING
-	}
-
+//
+// 设备挂了以后先停止, 然后重启
+//
+func restartDevice(abstractDevice typex.XDevice, e *RuleEngine) error {
+	glogger.GLogger.WithField("resource", abstractDevice.Details().UUID).Warnf("Device %v %v down. try to restart it",
+		abstractDevice.Details().UUID, abstractDevice.Details().Name)
+	abstractDevice.Stop()
+	return startDevice(abstractDevice, e)
 }
 
 /*
@@ -1,12 +1,10 @@
 package engine
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"runtime"
-	"time"
 
+	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/source"
 	"github.com/i4de/rulex/typex"
@@ -68,6 +66,9 @@ func (e *RuleEngine) LoadInEnd(in *typex.InEnd) error {
                       +-------------------Error ---------------------+
 */
 func startSources(source typex.XSource, in *typex.InEnd, e *RuleEngine) error {
+	if err := typex.ValidateOverflowPolicy(in.OverflowPolicy()); err != nil {
+		return err
+	}
 	//
 	// 先注册, 如果出问题了直接删除就行
 	//
@@ -94,38 +95,38 @@ func startSources(source typex.XSource, in *typex.InEnd, e *RuleEngine) error {
 		e.RemoveInEnd(in.UUID)
 		return err
 	}
-	ticker := time.NewTicker(time.Duration(time.Second * 5))
-	go func(ctx context.Context) {
-		// 5 seconds
-	TICKER:
-		<-ticker.C
-		select {
-		case <-ctx.Done():
-			{
-				return
+	// 挂了以后按照策略重启
+	supervisor := typex.NewSupervisor(typex.NewRestartPolicy(core.GlobalConfig))
+	in.Supervisor = supervisor
+	supervisor.Start(typex.SupervisorHooks{
+		// 通过HTTP删除资源的时候, 会把数据清了, 只要检测到资源没了, 这里也退出
+		Alive: func() bool {
+			return source.Details() != nil
+		},
+		Healthy: func() bool {
+			// 暂停的资源不重启
+			if source.Details().Paused() {
+				return true
 			}
-		default:
-			{
-				goto CHECK
+			// 驱动挂了资源也挂了, 因此检查驱动状态在先
+			checkSourceDriverState(source)
+			if source.Status() == typex.SOURCE_UP {
+				source.Details().UpdateState(typex.SOURCE_UP)
+				return true
 			}
-		}
-	CHECK:
-		{
-			//
-			// 通过HTTP删除资源的时候, 会把数据清了, 只要检测到资源没了, 这里也退出
-			//
-			if source.Details() == nil {
-				return
+			if !supervisor.Failed() {
+				source.Details().UpdateState(typex.SOURCE_DOWN)
 			}
-			//------------------------------------
-			// 驱动挂了资源也挂了, 因此检查驱动状态在先
-			//------------------------------------
-			tryIfRestartSource(source, e)
-			//------------------------------------
-			goto TICKER
-		}
-
-	}(typex.GCTX)
+			return false
+		},
+		Restart: func() error {
+			return restartSource(source, e)
+		},
+		Failed: func() {
+			glogger.GLogger.WithField("resource", in.UUID).Errorf("Source %v %v restart failed too many times", in.UUID, in.Name)
+			source.Details().UpdateState(typex.SOURCE_FAILED)
+		},
+	})
 	glogger.GLogger.Infof("InEnd [%v, %v] load successfully", in.Name, in.UUID)
 	return nil
 }
@@ -155,26 +156,13 @@ func checkSourceDriverState(source typex.XSource) {
 }
 
 //
-// test SourceState
+// 当资源挂了以后先给停止, 然后重启
 //
-func tryIfRestartSource(source typex.XSource, e *RuleEngine) {
-	checkSourceDriverState(source)
-	if source.Status() == typex.SOURCE_DOWN {
-		source.Details().SetState(typex.SOURCE_DOWN)
-		//----------------------------------
-		// 当资源挂了以后先给停止, 然后重启
-		//----------------------------------
-		glogger.GLogger.Warnf("Source %v %v down. try to restart it", source.Details().UUID, source.Details().Name)
-		source.Stop()
-		//----------------------------------
-		// 主动垃圾回收一波
-		//----------------------------------
-		runtime.Gosched()
-		runtime.GC() // GC 比较慢, 但是是良性卡顿, 问题不大
-		startSource(source, e)
-	} else {
-		source.Details().SetState(typex.SOURCE_UP)
-	}
+func restartSource(source typex.XSource, e *RuleEngine) error {
+	glogger.GLogger.WithField("resource", source.Details().UUID).Warnf("Source %v %v down. try to restart it",
+		source.Details().UUID, source.Details().Name)
+	source.Stop()
+	return startSource(source, e)
 }
 
 //
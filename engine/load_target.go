@@ -1,12 +1,10 @@
 package engine
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"runtime"
-	"time"
 
+	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/target"
 	"github.com/i4de/rulex/typex"
@@ -58,7 +56,7 @@ func startTarget(target typex.XTarget, out *typex.OutEnd, e typex.RuleX) error {
 	}
 	if err := target.Init(out.UUID, config); err != nil {
 		glogger.GLogger.Error(err)
-		e.RemoveInEnd(out.UUID)
+		e.RemoveOutEnd(out.UUID)
 		return err
 	}
 	// 然后启动资源
@@ -70,52 +68,49 @@ func startTarget(target typex.XTarget, out *typex.OutEnd, e typex.RuleX) error {
 	}
 	// Set sources to inend
 	out.Target = target
-	//
-	ticker := time.NewTicker(time.Duration(time.Second * 5))
-	tryIfRestartTarget(target, e, out.UUID)
-	go func(ctx context.Context) {
-
-		// 5 seconds
-		//
-	TICKER:
-		<-ticker.C
-		select {
-		case <-ctx.Done():
-			{
-				return
+	// 断网缓存
+	if err := typex.StartOutEndBuffer(out, core.GlobalConfig.BufferPath); err != nil {
+		glogger.GLogger.Error("OutEnd buffer start error:", err)
+	}
+	// 挂了以后按照策略重启
+	supervisor := typex.NewSupervisor(typex.NewRestartPolicy(core.GlobalConfig))
+	out.Supervisor = supervisor
+	supervisor.Start(typex.SupervisorHooks{
+		Alive: func() bool {
+			return target.Details() != nil
+		},
+		Healthy: func() bool {
+			if target.Details().Paused() {
+				return true
 			}
-		default:
-			{
-				goto CHECK
+			if target.Status() == typex.SOURCE_UP {
+				target.Details().UpdateState(typex.SOURCE_UP)
+				return true
 			}
-		}
-	CHECK:
-		{
-			if target.Details() == nil {
-				return
+			if !supervisor.Failed() {
+				target.Details().UpdateState(typex.SOURCE_DOWN)
 			}
-			tryIfRestartTarget(target, e, out.UUID)
-			goto TICKER
-		}
-
-	}(typex.GCTX)
+			return false
+		},
+		Restart: func() error {
+			return restartTarget(target)
+		},
+		Failed: func() {
+			glogger.GLogger.WithField("resource", out.UUID).Errorf("Target [%v, %v] restart failed too many times", out.Name, out.UUID)
+			target.Details().UpdateState(typex.SOURCE_FAILED)
+		},
+	})
 	glogger.GLogger.Infof("Target [%v, %v] load successfully", out.Name, out.UUID)
 	return nil
 }
 
 //
-// 监测状态, 如果挂了重启
+// 挂了以后先停止, 然后重启
 //
-func tryIfRestartTarget(target typex.XTarget, e typex.RuleX, id string) {
-	if target.Status() == typex.SOURCE_DOWN {
-		target.Details().State = typex.SOURCE_DOWN
-		glogger.GLogger.Warnf("Target [%v, %v] down. try to restart it", target.Details().Name, target.Details().UUID)
-		target.Stop()
-		runtime.Gosched()
-		runtime.GC()
-		ctx, cancelCTX := typex.NewCCTX()
-		target.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancelCTX})
-	} else {
-		target.Details().State = typex.SOURCE_UP
-	}
+func restartTarget(target typex.XTarget) error {
+	glogger.GLogger.WithField("resource", target.Details().UUID).Warnf("Target [%v, %v] down. try to restart it",
+		target.Details().Name, target.Details().UUID)
+	target.Stop()
+	ctx, cancelCTX := typex.NewCCTX()
+	return target.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancelCTX})
 }
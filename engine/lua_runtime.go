@@ -14,6 +14,7 @@ func LoadBuildInLuaLib(e typex.RuleX, r *typex.Rule) {
 	// 消息转发
 	r.AddLib(e, "DataToHttp", rulexlib.DataToHttp(e))
 	r.AddLib(e, "DataToMqtt", rulexlib.DataToMqtt(e))
+	r.AddLib(e, "DataToOutEnd", rulexlib.DataToOutEnd(e))
 	// JQ
 	r.AddLib(e, "JqSelect", rulexlib.JqSelect(e))
 	r.AddLib(e, "JQ", rulexlib.JqSelect(e))
@@ -44,6 +45,9 @@ func LoadBuildInLuaLib(e typex.RuleX, r *typex.Rule) {
 	r.AddLib(e, "VSet", rulexlib.StoreSet(e))
 	r.AddLib(e, "VGet", rulexlib.StoreGet(e))
 	r.AddLib(e, "VDel", rulexlib.StoreDelete(e))
+	r.AddLib(e, "VSetTTL", rulexlib.StoreSetWithTTL(e))
+	r.AddLib(e, "VKeys", rulexlib.StoreKeys(e))
+	r.AddLib(e, "VIncr", rulexlib.StoreIncr(e))
 	// JSON
 	r.AddLib(e, "T2J", rulexlib.JSONE(e)) // Lua Table -> JSON
 	r.AddLib(e, "J2T", rulexlib.JSOND(e)) // JSON -> Lua Table
@@ -55,5 +59,17 @@ func LoadBuildInLuaLib(e typex.RuleX, r *typex.Rule) {
 	// Device R/W
 	r.AddLib(e, "ReadDevice", rulexlib.ReadDevice(e))
 	r.AddLib(e, "WriteDevice", rulexlib.WriteDevice(e))
+	// 数据模型和影子
+	r.AddLib(e, "SetModelValue", rulexlib.SetModelValue(e))
+	r.AddLib(e, "ReportShadow", rulexlib.ReportShadow(e))
+	// 本地历史数据
+	r.AddLib(e, "WriteHistory", rulexlib.WriteHistory(e))
+	// 内部主题
+	r.AddLib(e, "Publish", rulexlib.Publish(e))
+	// 流式窗口, 窗口状态跟着规则走
+	windows := rulexlib.NewWindowStore()
+	r.AddLib(e, "TumblingWindow", rulexlib.TumblingWindow(e, windows))
+	r.AddLib(e, "SlidingWindow", rulexlib.SlidingWindow(e, windows))
+	r.AddLib(e, "CountWindow", rulexlib.CountWindow(e, windows))
 
 }
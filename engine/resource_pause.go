@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 暂停和恢复: 配置和规则绑定都保留; InEnd 和 OutEnd 暂停的时候连接保持, 只是引擎
+* 不再接收或者投递数据; 设备暂停的时候真正停下来(不再轮询, 串口释放), 恢复的时候重新启动;
+* 暂停期间守护协程不会去重启资源, 也不会改掉暂停状态
+*
+ */
+
+//
+// 暂停 InEnd: 改成暂停状态以后新来的数据直接丢弃, 资源本身不停
+//
+func (e *RuleEngine) PauseInEnd(uuid string) error {
+	in := e.GetInEnd(uuid)
+	if in == nil {
+		return fmt.Errorf("inend not exists: %v", uuid)
+	}
+	if in.Paused() {
+		return nil
+	}
+	in.SetState(typex.SOURCE_PAUSE)
+	glogger.GLogger.WithField("resource", in.UUID).Infof("InEnd [%v, %v] paused", in.Name, in.UUID)
+	return nil
+}
+
+//
+// 恢复 InEnd: 状态同步成资源当前的状态, 暂停期间断开了的交给守护协程重启
+//
+func (e *RuleEngine) ResumeInEnd(uuid string) error {
+	in := e.GetInEnd(uuid)
+	if in == nil {
+		return fmt.Errorf("inend not exists: %v", uuid)
+	}
+	if !in.Paused() {
+		return nil
+	}
+	if in.Source != nil {
+		in.SetState(in.Source.Status())
+	} else {
+		in.SetState(typex.SOURCE_DOWN)
+	}
+	if typex.DefaultShadows != nil {
+		typex.DefaultShadows.Redeliver(e, in.UUID)
+	}
+	glogger.GLogger.WithField("resource", in.UUID).Infof("InEnd [%v, %v] resumed", in.Name, in.UUID)
+	return nil
+}
+
+//
+// 暂停 OutEnd: 数据进断网缓存, 没有缓存就在重试队列里排队, 目标本身不停
+//
+func (e *RuleEngine) PauseOutEnd(uuid string) error {
+	out := e.GetOutEnd(uuid)
+	if out == nil {
+		return fmt.Errorf("outend not exists: %v", uuid)
+	}
+	if out.Paused() {
+		return nil
+	}
+	out.SetState(typex.SOURCE_PAUSE)
+	glogger.GLogger.WithField("resource", out.UUID).Infof("OutEnd [%v, %v] paused", out.Name, out.UUID)
+	return nil
+}
+
+//
+// 恢复 OutEnd, 缓存和重试队列里面的数据会按顺序补发
+//
+func (e *RuleEngine) ResumeOutEnd(uuid string) error {
+	out := e.GetOutEnd(uuid)
+	if out == nil {
+		return fmt.Errorf("outend not exists: %v", uuid)
+	}
+	if !out.Paused() {
+		return nil
+	}
+	if out.Target == nil {
+		out.SetState(typex.SOURCE_DOWN)
+		return nil
+	}
+	out.SetState(out.Target.Status())
+	glogger.GLogger.WithField("resource", out.UUID).Infof("OutEnd [%v, %v] resumed", out.Name, out.UUID)
+	return nil
+}
+
+//
+// 暂停设备: 不再接收设备数据, 也不能写设备
+//
+func (e *RuleEngine) PauseDevice(uuid string) error {
+	dev := e.GetDevice(uuid)
+	if dev == nil {
+		return fmt.Errorf("device not exists: %v", uuid)
+	}
+	if dev.Paused() {
+		return nil
+	}
+	dev.SetState(typex.DEV_PAUSE)
+	if dev.Device != nil {
+		dev.Device.Stop()
+		if dev.Device.Driver() != nil && dev.Device.Driver().State() == typex.DRIVER_RUNNING {
+			dev.Device.Driver().Stop()
+		}
+	}
+	glogger.GLogger.WithField("resource", dev.UUID).Infof("Device [%v, %v] paused", dev.Name, dev.UUID)
+	return nil
+}
+
+//
+// 恢复设备
+//
+func (e *RuleEngine) ResumeDevice(uuid string) error {
+	dev := e.GetDevice(uuid)
+	if dev == nil {
+		return fmt.Errorf("device not exists: %v", uuid)
+	}
+	if !dev.Paused() {
+		return nil
+	}
+	if dev.Device == nil {
+		dev.SetState(typex.DEV_STOP)
+		return nil
+	}
+	if err := startDevice(dev.Device, e); err != nil {
+		dev.SetState(typex.DEV_STOP)
+		return err
+	}
+	dev.SetState(dev.Device.Status())
+	if typex.DefaultShadows != nil {
+		typex.DefaultShadows.Redeliver(e, dev.UUID)
+	}
+	glogger.GLogger.WithField("resource", dev.UUID).Infof("Device [%v, %v] resumed", dev.Name, dev.UUID)
+	return nil
+}
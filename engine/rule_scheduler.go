@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 启动定时规则: 到点以后用配置的数据执行一次 Actions
+*
+ */
+func (e *RuleEngine) startSchedule(r *typex.Rule) {
+	e.stopSchedule(r.UUID)
+	if r.Schedule.Status == nil {
+		r.Schedule.Status = &typex.ScheduleStatus{}
+	}
+	ctx, cancel := context.WithCancel(typex.GCTX)
+	e.Schedules.Store(r.UUID, cancel)
+	go func(ctx context.Context, r *typex.Rule) {
+		for {
+			now := time.Now()
+			next := r.Schedule.Next(now)
+			if next.IsZero() {
+				glogger.GLogger.WithField("rule", r.UUID).Warnf("Rule [%v] schedule will never run again", r.UUID)
+				return
+			}
+			r.Schedule.Status.Planned(next)
+			timer := time.NewTimer(next.Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if r.GetStatus() != typex.RULE_RUNNING {
+				continue
+			}
+			envelope := typex.NewEnvelope([]byte(r.Schedule.Payload))
+			envelope.Origin = r.UUID
+			envelope.OriginType = typex.ORIGIN_SCHEDULE
+			err := runRuleCallbacks(r, envelope)
+			r.Schedule.Status.Ran(time.Now(), err)
+		}
+	}(ctx, r)
+	glogger.GLogger.Infof("Rule [%v] schedule started", r.UUID)
+}
+
+/*
+*
+* 停止定时规则
+*
+ */
+func (e *RuleEngine) stopSchedule(ruleUUID string) {
+	if v, ok := e.Schedules.LoadAndDelete(ruleUUID); ok {
+		v.(context.CancelFunc)()
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/glogger"
 	httpserver "github.com/i4de/rulex/plugin/http_server"
 	"github.com/i4de/rulex/sidecar"
+	"github.com/i4de/rulex/sqlrule"
 	"github.com/i4de/rulex/typex"
 )
 
@@ -18,9 +20,17 @@ import (
 //
 func RunRulex(iniPath string) {
 	mainConfig := core.InitGlobalConfig(iniPath)
+	glogger.DefaultRotateConfig = glogger.RotateConfig{
+		MaxSize:        core.GlobalConfig.LogMaxSize,
+		RotateInterval: time.Duration(core.GlobalConfig.LogRotateInterval) * time.Second,
+		MaxBackups:     core.GlobalConfig.LogMaxBackups,
+		Compress:       core.GlobalConfig.LogCompress,
+	}
 	glogger.StartGLogger(mainConfig.EnableConsole, core.GlobalConfig.LogPath)
 	glogger.StartLuaLogger(core.GlobalConfig.LuaLogPath)
-	core.StartStore(core.GlobalConfig.MaxQueueSize)
+	if err := core.StartConfiguredStore(); err != nil {
+		glogger.GLogger.Fatal("Store start error:", err)
+	}
 	core.SetLogLevel()
 	core.SetPerformance()
 	c := make(chan os.Signal, 1)
@@ -50,6 +60,8 @@ func RunRulex(iniPath string) {
 		in.DataModelsMap = dataModelsMap
 		if err := engine.LoadInEnd(in); err != nil {
 			glogger.GLogger.Error("InEnd load failed:", err)
+		} else if minEnd.Paused {
+			engine.PauseInEnd(in.UUID)
 		}
 	}
 
@@ -65,6 +77,8 @@ func RunRulex(iniPath string) {
 		newOutEnd.UUID = mOutEnd.UUID // Important !!!!!!!!
 		if err := engine.LoadOutEnd(newOutEnd); err != nil {
 			glogger.GLogger.Error("OutEnd load failed:", err)
+		} else if mOutEnd.Paused {
+			engine.PauseOutEnd(newOutEnd.UUID)
 		}
 	}
 	// 加载设备
@@ -77,6 +91,8 @@ func RunRulex(iniPath string) {
 		newDevice.UUID = mDevice.UUID // Important !!!!!!!!
 		if err := engine.LoadDevice(newDevice); err != nil {
 			glogger.GLogger.Error("Device load failed:", err)
+		} else if mDevice.Paused {
+			engine.PauseDevice(newDevice.UUID)
 		}
 	}
 	// 加载外挂
@@ -95,6 +111,15 @@ func RunRulex(iniPath string) {
 	// 规则最后加载
 	//
 	for _, mRule := range httpServer.AllMRules() {
+		// SQL 规则每次启动重新编译
+		if mRule.Sql != "" {
+			program, err := sqlrule.Compile(mRule.Sql, mRule.OutEnds)
+			if err != nil {
+				glogger.GLogger.Error("Rule sql error:", err)
+				continue
+			}
+			mRule.Actions = program.Actions
+		}
 		rule := typex.NewRule(engine,
 			mRule.UUID,
 			mRule.Name,
@@ -104,6 +129,26 @@ func RunRulex(iniPath string) {
 			mRule.Success,
 			mRule.Actions,
 			mRule.Failed)
+		rule.FromTopic = mRule.FromTopic
+		rule.Sql = mRule.Sql
+		rule.OutEnds = mRule.OutEnds
+		if mRule.Stopped {
+			rule.SetStatus(typex.RULE_STOP)
+		}
+		limits := typex.RuleLimits{}
+		if mRule.Limits != "" {
+			if err := json.Unmarshal([]byte(mRule.Limits), &limits); err != nil {
+				glogger.GLogger.Error("Rule limits error:", err)
+			}
+		}
+		rule.SetLimits(limits)
+		if mRule.Schedule != "" {
+			rule.Schedule = &typex.RuleSchedule{}
+			if err := json.Unmarshal([]byte(mRule.Schedule), rule.Schedule); err != nil {
+				glogger.GLogger.Error("Rule schedule error:", err)
+				rule.Schedule = nil
+			}
+		}
 		if err := engine.LoadRule(rule); err != nil {
 			glogger.GLogger.Error(err)
 		}
@@ -2,7 +2,6 @@ package glogger
 
 import (
 	"os"
-	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -19,8 +18,8 @@ var GLOBAL_LOGGER *LogWriter
 var GLogger *logrus.Logger = logrus.New()
 
 func StartGLogger(EnableConsole bool, path string) {
-	GLOBAL_LOGGER = NewLogWriter("./"+time.Now().Format("2006-01-02_15-04-05-")+path, 1000)
-	GLogger.Formatter = new(logrus.JSONFormatter)
+	GLOBAL_LOGGER = NewLogWriter("./"+path, DefaultRotateConfig)
+	GLogger.Formatter = &levelFilter{Formatter: new(logrus.JSONFormatter)}
 	GLogger.SetReportCaller(true)
 	// GLogger.Formatter.(*logrus.JSONFormatter).PrettyPrint = true
 	if EnableConsole {
@@ -36,5 +35,5 @@ func StartGLogger(EnableConsole bool, path string) {
 *
  */
 func StartLuaLogger(path string) {
-	LUA_LOGGER = NewLogWriter("./"+time.Now().Format("2006-01-02_15-04-05-")+path, 1000)
+	LUA_LOGGER = NewLogWriter("./"+path, DefaultRotateConfig)
 }
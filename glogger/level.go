@@ -0,0 +1,150 @@
+package glogger
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const modulePrefix = "github.com/i4de/rulex/"
+
+var levelLock sync.RWMutex
+var globalLevel = logrus.InfoLevel
+
+//
+// 模块单独的日志级别, 模块就是包路径, 比如 engine, plugin/http_server
+//
+var moduleLevels = map[string]logrus.Level{}
+
+//
+// 解析日志级别, all 表示 debug
+//
+func ParseLevel(level string) (logrus.Level, error) {
+	switch level {
+	case "all":
+		return logrus.DebugLevel, nil
+	case "fatal", "error", "warn", "warning", "info", "debug", "trace":
+		return logrus.ParseLevel(level)
+	}
+	return logrus.InfoLevel, errors.New("unsupported log level:" + level)
+}
+
+/*
+*
+* 设置全局日志级别, 运行时也可以改
+*
+ */
+func SetLevel(level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	globalLevel = l
+	applyLevel()
+	return nil
+}
+
+/*
+*
+* 设置某个模块的日志级别, level 为空表示跟随全局级别
+*
+ */
+func SetModuleLevel(module string, level string) error {
+	module = strings.Trim(module, "/")
+	if module == "" {
+		return errors.New("module can not be empty")
+	}
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	if level == "" {
+		delete(moduleLevels, module)
+		applyLevel()
+		return nil
+	}
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	moduleLevels[module] = l
+	applyLevel()
+	return nil
+}
+
+//
+// 当前的全局级别和模块级别
+//
+func Levels() (string, map[string]string) {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	modules := map[string]string{}
+	for module, l := range moduleLevels {
+		modules[module] = l.String()
+	}
+	return globalLevel.String(), modules
+}
+
+//
+// 记录器的级别取最详细的那个, 具体每条日志要不要输出由 levelFilter 决定
+//
+func applyLevel() {
+	l := globalLevel
+	for _, ml := range moduleLevels {
+		if ml > l {
+			l = ml
+		}
+	}
+	GLogger.SetLevel(l)
+}
+
+//
+// 某个模块的级别, 最长的前缀优先: plugin 对 plugin/http_server 也生效
+//
+func moduleLevel(module string) logrus.Level {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	level, matched := globalLevel, ""
+	for m, l := range moduleLevels {
+		if (module == m || strings.HasPrefix(module, m+"/")) && len(m) > len(matched) {
+			level, matched = l, m
+		}
+	}
+	return level
+}
+
+//
+// 日志所在的模块: 优先用 module 字段, 否则从调用的函数名里面取包路径
+//
+func entryModule(entry *logrus.Entry) string {
+	if module, ok := entry.Data["module"].(string); ok {
+		return module
+	}
+	if entry.Caller == nil {
+		return ""
+	}
+	fn := entry.Caller.Function
+	slash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[slash+1:], "."); dot >= 0 {
+		fn = fn[:slash+1+dot]
+	}
+	return strings.TrimPrefix(fn, modulePrefix)
+}
+
+/*
+*
+* 按模块过滤日志: 被过滤的日志格式化成空内容
+*
+ */
+type levelFilter struct {
+	logrus.Formatter
+}
+
+func (f *levelFilter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Level > moduleLevel(entryModule(entry)) {
+		return []byte{}, nil
+	}
+	return f.Formatter.Format(entry)
+}
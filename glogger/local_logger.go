@@ -1,49 +1,276 @@
 package glogger
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+//
+// 日志滚动配置, 为0的项不生效
+//
+type RotateConfig struct {
+	MaxSize        int64         // 单个文件最大字节数, 超过就滚动
+	RotateInterval time.Duration // 按时间滚动, 比如每天一个文件
+	MaxBackups     int           // 最多保留多少个滚动出去的文件
+	Compress       bool          // 滚动出去的文件用 gzip 压缩
+}
+
+//
+// 启动日志之前设置, 主日志和 Lua 日志共用
+//
+var DefaultRotateConfig RotateConfig
+
 /*
 *
-* 日志记录器，未来会移除这个slot
+* 日志记录器: 当前文件名固定, 滚动出去的文件名带上时间,
+* 压缩和清理在后台做, 不阻塞写日志
 *
  */
 type LogWriter struct {
-	file         *os.File
-	logSlot      []string
-	maxSlotCount int
+	lock       sync.Mutex
+	path       string
+	config     RotateConfig
+	file       *os.File
+	size       int64
+	nextRotate time.Time
+	retryAfter time.Time // 滚动失败以后等一会再试, 不要每条日志都去改名
+	wg         sync.WaitGroup
+	cleaning   sync.Mutex // 同时只有一个后台清理
 }
 
-func NewLogWriter(filepath string, maxSlotCount int) *LogWriter {
-	logFile, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE, 0777)
-	if err != nil {
+const _ROTATE_RETRY_INTERVAL time.Duration = time.Minute
+
+func NewLogWriter(file string, config RotateConfig) *LogWriter {
+	lw := &LogWriter{path: filepath.Clean(file), config: config}
+	if err := lw.open(); err != nil {
 		GLogger.Fatalf("Fail to read log file: %v", err)
 		os.Exit(1)
 	}
+	return lw
+}
 
-	return &LogWriter{file: logFile,
-		logSlot: make([]string, maxSlotCount),
+func (lw *LogWriter) open() error {
+	logFile, err := os.OpenFile(lw.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
 	}
+	info, err := logFile.Stat()
+	if err != nil {
+		logFile.Close()
+		return err
+	}
+	lw.file = logFile
+	lw.size = info.Size()
+	if lw.config.RotateInterval > 0 {
+		lw.nextRotate = nextRotateTime(time.Now(), lw.config.RotateInterval)
+	}
+	return nil
 }
+
+//
+// 按本地时间对齐: 86400 秒是每天本地零点滚动, 不是 UTC 零点
+//
+func nextRotateTime(now time.Time, interval time.Duration) time.Time {
+	_, offset := now.Zone()
+	zone := time.Duration(offset) * time.Second
+	return now.Add(zone).Truncate(interval).Add(interval).Add(-zone)
+}
+
 func (lw *LogWriter) Write(b []byte) (n int, err error) {
-	if len(lw.logSlot) > lw.maxSlotCount {
-		lw.logSlot = append(lw.logSlot[1:], string(b))
-	} else {
-		lw.logSlot = append(lw.logSlot, string(b))
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if lw.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if lw.shouldRotate(int64(len(b))) {
+		// 滚动失败的时候文件还开着就接着写, 日志不能断
+		if rotateErr = lw.rotate(); rotateErr != nil && lw.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err = lw.file.Write(b)
+	lw.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (lw *LogWriter) shouldRotate(n int64) bool {
+	if lw.size == 0 || time.Now().Before(lw.retryAfter) {
+		return false
+	}
+	if lw.config.MaxSize > 0 && lw.size+n > lw.config.MaxSize {
+		return true
+	}
+	return lw.config.RotateInterval > 0 && !time.Now().Before(lw.nextRotate)
+}
+
+//
+// 当前文件改名成带时间的备份文件, 再打开一个新文件
+//
+func (lw *LogWriter) rotate() error {
+	if err := lw.file.Close(); err != nil {
+		return err
+	}
+	lw.file = nil
+	backup := lw.backupName(time.Now())
+	if err := os.Rename(lw.path, backup); err != nil {
+		// 改名失败, 重新打开原来的文件接着追加
+		if openErr := lw.open(); openErr != nil {
+			return openErr
+		}
+		lw.retryAfter = time.Now().Add(_ROTATE_RETRY_INTERVAL)
+		return err
+	}
+	if err := lw.open(); err != nil {
+		return err
+	}
+	lw.wg.Add(1)
+	go func() {
+		defer lw.wg.Done()
+		lw.cleaning.Lock()
+		defer lw.cleaning.Unlock()
+		if lw.config.Compress {
+			if err := compressFile(backup); err != nil {
+				GLogger.Error("Compress log file error:", err)
+			}
+		}
+		lw.removeOldBackups()
+	}()
+	return nil
+}
+
+// rulex-log.txt -> rulex-log-2006-01-02T15-04-05.000.txt
+func (lw *LogWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(lw.path)
+	return strings.TrimSuffix(lw.path, ext) + "-" + t.Format("2006-01-02T15-04-05.000") + ext
+}
+
+//
+// 滚动出去的文件, 按时间从旧到新
+//
+func (lw *LogWriter) Backups() []string {
+	ext := filepath.Ext(lw.path)
+	prefix := strings.TrimSuffix(lw.path, ext) + "-"
+	matches, _ := filepath.Glob(prefix + "*")
+	backups := []string{}
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz"), ext)
+		if _, err := time.Parse("2006-01-02T15-04-05.000", stamp); err == nil {
+			backups = append(backups, m)
+		}
 	}
+	sort.Strings(backups)
+	return backups
+}
 
-	return lw.file.Write(b)
+func (lw *LogWriter) removeOldBackups() {
+	if lw.config.MaxBackups <= 0 {
+		return
+	}
+	backups := lw.Backups()
+	for len(backups) > lw.config.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			GLogger.Error("Remove log file error:", err)
+		}
+		backups = backups[1:]
+	}
 }
 
-func (lw *LogWriter) Slot() []string {
-	return lw.logSlot
+//
+// 压缩成 .gz 以后删掉原文件
+//
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
 }
+
+/*
+*
+* 当前文件最后 n 行, n <= 0 表示全部: 单独打开文件从后往前读,
+* 不拿写锁, 所以不会卡住写日志; 读的时候正好滚动了也只是读到旧文件
+*
+ */
+func (lw *LogWriter) Tail(n int) []string {
+	lines := []string{}
+	f, err := os.Open(lw.path)
+	if err != nil {
+		return lines
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return lines
+	}
+	const chunkSize = 64 * 1024
+	offset := info.Size()
+	buf := []byte{}
+	newlines := 0
+	// 多读一个换行, 保证最前面那一行是完整的
+	for offset > 0 && (n <= 0 || newlines <= n) {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return lines
+		}
+		newlines += bytes.Count(chunk, []byte{'\n'})
+		buf = append(chunk, buf...)
+	}
+	text := strings.TrimRight(string(buf), "\n")
+	if text == "" {
+		return lines
+	}
+	lines = strings.Split(text, "\n")
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
 func (lw *LogWriter) Close() error {
+	lw.lock.Lock()
+	var err error
 	if lw.file != nil {
-		return lw.file.Close()
-	} else {
-		return nil
+		err = lw.file.Close()
+		lw.file = nil
 	}
-
+	lw.lock.Unlock()
+	// 等后台压缩完
+	lw.wg.Wait()
+	return err
 }
@@ -0,0 +1,196 @@
+package glogger
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const DEFAULT_LOG_BUFFER = 256 // 每个订阅者默认缓冲多少条
+const MAX_LOG_BUFFER = 4096
+
+//
+// 推送给前端的一条日志, 主日志和 Lua 日志都是这个格式
+//
+type LogEntry struct {
+	Source   string `json:"source"` // rulex 或者 lua
+	Level    string `json:"level"`
+	Time     int64  `json:"time"` // 毫秒
+	Module   string `json:"module,omitempty"`
+	Resource string `json:"resource,omitempty"` // 资源(设备, 输入, 输出) UUID
+	Rule     string `json:"rule,omitempty"`     // 规则 UUID
+	Message  string `json:"msg"`
+}
+
+const LOG_SOURCE_RULEX = "rulex"
+const LOG_SOURCE_LUA = "lua"
+
+/*
+*
+* 订阅过滤条件, 为空的项不过滤; Level 是最低级别, 比如 warn 包含 error
+*
+ */
+type LogFilter struct {
+	Level    string `json:"level"`
+	Resource string `json:"resource"`
+	Rule     string `json:"rule"`
+	Text     string `json:"text"`
+}
+
+func (f LogFilter) level() (logrus.Level, error) {
+	if f.Level == "" {
+		return logrus.TraceLevel, nil
+	}
+	return ParseLevel(f.Level)
+}
+
+//
+// 资源和规则优先比较字段, 没有字段的日志就看内容里面有没有这个 UUID
+//
+func (f LogFilter) Match(e LogEntry) bool {
+	if f.Resource != "" && e.Resource != f.Resource && !strings.Contains(e.Message, f.Resource) {
+		return false
+	}
+	if f.Rule != "" && e.Rule != f.Rule && !strings.Contains(e.Message, f.Rule) {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(e.Message), strings.ToLower(f.Text)) {
+		return false
+	}
+	return true
+}
+
+/*
+*
+* 日志订阅者: 缓冲满了直接丢弃并计数, 慢的客户端不会拖慢引擎
+*
+ */
+type LogSubscriber struct {
+	filter  LogFilter
+	level   logrus.Level
+	ch      chan LogEntry
+	dropped uint64
+}
+
+func (s *LogSubscriber) Entries() <-chan LogEntry {
+	return s.ch
+}
+
+//
+// 上次调用以后丢弃了多少条
+//
+func (s *LogSubscriber) TakeDropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+func (s *LogSubscriber) offer(e LogEntry) {
+	l, err := ParseLevel(e.Level)
+	if err != nil {
+		l = logrus.InfoLevel
+	}
+	if l > s.level || !s.filter.Match(e) {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+var streamLock sync.RWMutex
+var subscribers = map[*LogSubscriber]struct{}{}
+
+/*
+*
+* 订阅实时日志, 用完以后必须 UnsubscribeLog
+*
+ */
+func SubscribeLog(filter LogFilter, bufferSize int) (*LogSubscriber, error) {
+	level, err := filter.level()
+	if err != nil {
+		return nil, err
+	}
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_LOG_BUFFER
+	}
+	if bufferSize > MAX_LOG_BUFFER {
+		bufferSize = MAX_LOG_BUFFER
+	}
+	s := &LogSubscriber{filter: filter, level: level, ch: make(chan LogEntry, bufferSize)}
+	streamLock.Lock()
+	subscribers[s] = struct{}{}
+	streamLock.Unlock()
+	return s, nil
+}
+
+//
+// 取消订阅以后 Entries 会被关闭
+//
+func UnsubscribeLog(s *LogSubscriber) {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if _, ok := subscribers[s]; ok {
+		delete(subscribers, s)
+		close(s.ch)
+	}
+}
+
+//
+// 推送一条日志给所有订阅者, 不会阻塞
+//
+func PublishLog(e LogEntry) {
+	if e.Time == 0 {
+		e.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	streamLock.RLock()
+	defer streamLock.RUnlock()
+	for s := range subscribers {
+		s.offer(e)
+	}
+}
+
+func hasSubscribers() bool {
+	streamLock.RLock()
+	defer streamLock.RUnlock()
+	return len(subscribers) > 0
+}
+
+/*
+*
+* 把 GLogger 的日志推给订阅者, 模块级别过滤掉的日志不推
+*
+ */
+type streamHook struct{}
+
+func (streamHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (streamHook) Fire(entry *logrus.Entry) error {
+	if !hasSubscribers() {
+		return nil
+	}
+	module := entryModule(entry)
+	if entry.Level > moduleLevel(module) {
+		return nil
+	}
+	e := LogEntry{
+		Source:  LOG_SOURCE_RULEX,
+		Level:   entry.Level.String(),
+		Time:    entry.Time.UnixNano() / int64(time.Millisecond),
+		Module:  module,
+		Message: entry.Message,
+	}
+	e.Resource, _ = entry.Data["resource"].(string)
+	e.Rule, _ = entry.Data["rule"].(string)
+	PublishLog(e)
+	return nil
+}
+
+func init() {
+	GLogger.AddHook(streamHook{})
+}
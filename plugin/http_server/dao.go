@@ -79,6 +79,18 @@ func (s *HttpApiServer) DeleteMRule(uuid string) error {
 	return s.sqliteDb.Table("m_rules").Where("uuid=?", uuid).Delete(&MRule{}).Error
 }
 
+//
+// 更新规则: 删除旧的再插入新的放在一个事务里面, 失败的时候旧规则还在
+//
+func (s *HttpApiServer) ReplaceMRule(uuid string, r *MRule) error {
+	return s.sqliteDb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("m_rules").Where("uuid=?", uuid).Delete(&MRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Table("m_rules").Create(r).Error
+	})
+}
+
 func (s *HttpApiServer) UpdateMRule(uuid string, r *MRule) error {
 	m := MRule{}
 	if err := s.sqliteDb.Where("uuid=?", uuid).First(&m).Error; err != nil {
@@ -89,6 +101,13 @@ func (s *HttpApiServer) UpdateMRule(uuid string, r *MRule) error {
 	}
 }
 
+//
+// 保存规则的停用状态
+//
+func (s *HttpApiServer) SetMRuleStopped(uuid string, stopped bool) error {
+	return s.sqliteDb.Model(&MRule{}).Where("uuid=?", uuid).Update("stopped", stopped).Error
+}
+
 //-----------------------------------------------------------------------------------
 func (s *HttpApiServer) GetMInEnd(uuid string) (*MInEnd, error) {
 	m := new(MInEnd)
@@ -125,6 +144,20 @@ func (s *HttpApiServer) UpdateMInEnd(uuid string, i *MInEnd) error {
 	}
 }
 
+//
+// 整条记录覆盖保存, 空字段也会写进去
+//
+func (s *HttpApiServer) SaveMInEnd(i *MInEnd) error {
+	return s.sqliteDb.Save(i).Error
+}
+
+//
+// 保存暂停状态, Updates 会忽略零值, 所以单独更新这一列
+//
+func (s *HttpApiServer) SetMInEndPaused(uuid string, paused bool) error {
+	return s.sqliteDb.Model(&MInEnd{}).Where("uuid=?", uuid).Update("paused", paused).Error
+}
+
 //-----------------------------------------------------------------------------------
 func (s *HttpApiServer) GetMOutEnd(id string) (*MOutEnd, error) {
 	m := new(MOutEnd)
@@ -161,6 +194,17 @@ func (s *HttpApiServer) UpdateMOutEnd(uuid string, o *MOutEnd) error {
 	}
 }
 
+func (s *HttpApiServer) SaveMOutEnd(o *MOutEnd) error {
+	return s.sqliteDb.Save(o).Error
+}
+
+//
+// 保存暂停状态
+//
+func (s *HttpApiServer) SetMOutEndPaused(uuid string, paused bool) error {
+	return s.sqliteDb.Model(&MOutEnd{}).Where("uuid=?", uuid).Update("paused", paused).Error
+}
+
 //-----------------------------------------------------------------------------------
 // USER
 //-----------------------------------------------------------------------------------
@@ -260,6 +304,13 @@ func (s *HttpApiServer) UpdateDevice(uuid string, o *MDevice) error {
 	}
 }
 
+//
+// 保存设备暂停状态
+//
+func (s *HttpApiServer) SetDevicePaused(uuid string, paused bool) error {
+	return s.sqliteDb.Model(&MDevice{}).Where("uuid=?", uuid).Update("paused", paused).Error
+}
+
 //-------------------------------------------------------------------------------------
 // Goods
 //-------------------------------------------------------------------------------------
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errDeadLetterNotStarted = errors.New("dead letter store not started")
+
+//
+// 死信列表, 可以按 OutEnd 过滤
+//
+func DeadLetters(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultDeadLetters == nil {
+		c.JSON(200, Error400(errDeadLetterNotStarted))
+		return
+	}
+	uuid, _ := c.GetQuery("uuid")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	c.JSON(200, OkWithData(typex.DefaultDeadLetters.List(uuid, limit)))
+}
+
+//
+// 死信详情
+//
+func DeadLetterDetail(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultDeadLetters == nil {
+		c.JSON(200, Error400(errDeadLetterNotStarted))
+		return
+	}
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	dl, err := typex.DefaultDeadLetters.Get(id)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(dl))
+}
+
+//
+// 重新投递死信, 可以投递到另外一个 OutEnd
+//
+func ReplayDeadLetters(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		Ids    []uint64 `json:"ids" binding:"required"`
+		OutEnd string   `json:"outEnd"` // 空串表示原来的 OutEnd
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if typex.DefaultDeadLetters == nil {
+		c.JSON(200, Error400(errDeadLetterNotStarted))
+		return
+	}
+	for _, id := range form.Ids {
+		if err := typex.DefaultDeadLetters.Replay(e, id, form.OutEnd); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 删除死信: 指定 id 删除一条, 否则清空(可以按 OutEnd 过滤)
+//
+func DeleteDeadLetters(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultDeadLetters == nil {
+		c.JSON(200, Error400(errDeadLetterNotStarted))
+		return
+	}
+	if idStr, ok := c.GetQuery("id"); ok {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+		if err := typex.DefaultDeadLetters.Remove(id); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+		c.JSON(200, Ok())
+		return
+	}
+	uuid, _ := c.GetQuery("uuid")
+	count, err := typex.DefaultDeadLetters.Purge(uuid)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(count))
+}
@@ -41,6 +41,9 @@ func DeleteDevice(c *gin.Context, hs *HttpApiServer, e typex.RuleX) {
 		c.JSON(200, Error400(err))
 	} else {
 		e.RemoveDevice(uuid)
+		if typex.DefaultShadows != nil {
+			typex.DefaultShadows.Remove(uuid)
+		}
 		c.JSON(200, Ok())
 	}
 
@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHistoryNotStarted = errors.New("history store not started")
+
+/*
+*
+* 查询历史数据:
+* /history?metric=temp&start=1666000000000&end=1666086400000&interval=5m&aggregation=avg&tags[device]=d1
+* start 和 end 是毫秒时间戳, interval 不传返回原始数据
+*
+ */
+func History(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultHistory == nil {
+		c.JSON(200, Error400(errHistoryNotStarted))
+		return
+	}
+	q := typex.HistoryQuery{
+		Metric:      c.Query("metric"),
+		Tags:        c.QueryMap("tags"),
+		Aggregation: c.Query("aggregation"),
+	}
+	var err error
+	if start := c.Query("start"); start != "" {
+		if q.Start, err = strconv.ParseInt(start, 10, 64); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	if end := c.Query("end"); end != "" {
+		if q.End, err = strconv.ParseInt(end, 10, 64); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	if interval := c.Query("interval"); interval != "" {
+		if q.Interval, err = time.ParseDuration(interval); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	series, err := typex.DefaultHistory.Query(q)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(series))
+}
+
+//
+// 历史数据里面的时间序列, 可以按 metric 过滤
+//
+func HistorySeries(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultHistory == nil {
+		c.JSON(200, Error400(errHistoryNotStarted))
+		return
+	}
+	c.JSON(200, OkWithData(typex.DefaultHistory.Series(c.Query("metric"))))
+}
@@ -111,6 +111,8 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	//
 	//
 	hh.ginEngine.GET(url("logs"), hh.addRoute(Logs))
+	hh.ginEngine.GET(url("logs/level"), hh.addRoute(LogLevel))
+	hh.ginEngine.PUT(url("logs/level"), hh.addRoute(UpdateLogLevel))
 	//
 	//
 	//
@@ -136,6 +138,14 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	//
 	hh.ginEngine.GET(url("statistics"), hh.addRoute(Statistics))
 	//
+	// 按资源统计的指标
+	//
+	hh.ginEngine.GET(url("metrics"), hh.addRoute(Metrics))
+	//
+	// Prometheus 抓取
+	//
+	hh.ginEngine.GET("/metrics", hh.addRoute(PrometheusMetrics))
+	//
 	// Auth
 	//
 	hh.ginEngine.POST(url("users"), hh.addRoute(CreateUser))
@@ -152,6 +162,10 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	//
 	hh.ginEngine.POST(url("inends"), hh.addRoute(CreateInend))
 	//
+	// Update InEnd
+	//
+	hh.ginEngine.PUT(url("inends"), hh.addRoute(UpdateInEnd))
+	//
 	// 配置表
 	//
 	hh.ginEngine.GET(url("inends/config"), hh.addRoute(GetInEndConfig))
@@ -164,6 +178,32 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	//
 	hh.ginEngine.POST(url("outends"), hh.addRoute(CreateOutEnd))
 	//
+	// Update OutEnd
+	//
+	hh.ginEngine.PUT(url("outends"), hh.addRoute(UpdateOutEnd))
+	//
+	// OutEnd 断网缓存状态
+	//
+	hh.ginEngine.GET(url("outends/buffer"), hh.addRoute(OutEndBuffers))
+	//
+	// 暂停和恢复
+	//
+	hh.ginEngine.PUT(url("inends/pause"), hh.addRoute(PauseInEnd))
+	hh.ginEngine.PUT(url("inends/resume"), hh.addRoute(ResumeInEnd))
+	hh.ginEngine.PUT(url("outends/pause"), hh.addRoute(PauseOutEnd))
+	hh.ginEngine.PUT(url("outends/resume"), hh.addRoute(ResumeOutEnd))
+	//
+	// 死信管理
+	//
+	hh.ginEngine.GET(url("deadletters"), hh.addRoute(DeadLetters))
+	hh.ginEngine.GET(url("deadletters/detail"), hh.addRoute(DeadLetterDetail))
+	hh.ginEngine.POST(url("deadletters/replay"), hh.addRoute(ReplayDeadLetters))
+	hh.ginEngine.DELETE(url("deadletters"), hh.addRoute(DeleteDeadLetters))
+	//
+	// 内部主题
+	//
+	hh.ginEngine.GET(url("topics"), hh.addRoute(Topics))
+	//
 	// Create rule
 	//
 	hh.ginEngine.POST(url("rules"), hh.addRoute(CreateRule))
@@ -180,10 +220,26 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	//
 	hh.ginEngine.DELETE(url("rules"), hh.addRoute(DeleteRule))
 	//
+	// 启用和停用规则
+	//
+	hh.ginEngine.PUT(url("rules/start"), hh.addRoute(StartRule))
+	hh.ginEngine.PUT(url("rules/stop"), hh.addRoute(StopRule))
+	//
 	// 验证 lua 语法
 	//
 	hh.ginEngine.POST(url("validateRule"), hh.addRoute(ValidateLuaSyntax))
 	//
+	// 试运行规则
+	//
+	hh.ginEngine.POST(url("rules/test"), hh.addRoute(DryRunRule))
+	//
+	// 规则追踪
+	//
+	hh.ginEngine.GET(url("rules/trace"), hh.addRoute(RuleTraces))
+	hh.ginEngine.POST(url("rules/trace"), hh.addRoute(StartRuleTrace))
+	hh.ginEngine.DELETE(url("rules/trace"), hh.addRoute(StopRuleTrace))
+	hh.ginEngine.DELETE(url("rules/trace/records"), hh.addRoute(ClearRuleTraces))
+	//
 	// 获取配置表
 	//
 	hh.ginEngine.GET(url("rType"), hh.addRoute(RType))
@@ -203,6 +259,14 @@ func (hh *HttpApiServer) Start(r typex.RuleX) error {
 	hh.ginEngine.POST(url("devices"), hh.addRoute(CreateDevice))
 	hh.ginEngine.PUT(url("devices"), hh.addRoute(UpdateDevice))
 	hh.ginEngine.DELETE(url("devices"), hh.addRoute(DeleteDevice))
+	hh.ginEngine.PUT(url("devices/pause"), hh.addRoute(PauseDevice))
+	hh.ginEngine.PUT(url("devices/resume"), hh.addRoute(ResumeDevice))
+	// 设备影子
+	hh.ginEngine.GET(url("shadows"), hh.addRoute(Shadows))
+	hh.ginEngine.PUT(url("shadows/desired"), hh.addRoute(UpdateShadowDesired))
+	// 本地历史数据
+	hh.ginEngine.GET(url("history"), hh.addRoute(History))
+	hh.ginEngine.GET(url("history/series"), hh.addRoute(HistorySeries))
 	// 外挂管理
 	hh.ginEngine.GET(url("goods"), hh.addRoute(Goods))
 	hh.ginEngine.POST(url("goods"), hh.addRoute(CreateGoods))
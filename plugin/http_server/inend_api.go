@@ -1,6 +1,8 @@
 package httpserver
 
 import (
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/source"
 	"github.com/i4de/rulex/typex"
 	"github.com/i4de/rulex/utils"
 
@@ -109,6 +111,89 @@ func CreateInend(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 
 }
 
+//
+// 更新 InEnd 配置: UUID 和规则绑定都不变, 重启失败就回滚到原来的配置
+//
+func UpdateInEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		UUID        string                 `json:"uuid" binding:"required"`
+		Name        string                 `json:"name" binding:"required"`
+		Description string                 `json:"description"`
+		Config      map[string]interface{} `json:"config" binding:"required"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	mInEnd, err := hh.GetMInEndWithUUID(form.UUID)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	inEnd := e.GetInEnd(form.UUID)
+	if inEnd == nil {
+		c.JSON(200, Error("InEnd not exists:"+form.UUID))
+		return
+	}
+	if err := source.ValidateConfig(inEnd.Type, form.Config); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	configJson, err := json.Marshal(form.Config)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	oldMInEnd := *mInEnd
+	oldName, oldDescription, oldConfig := inEnd.Name, inEnd.Description, inEnd.Config
+	mInEnd.Name = form.Name
+	mInEnd.Description = form.Description
+	mInEnd.Config = string(configJson)
+	if err := hh.SaveMInEnd(mInEnd); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	inEnd.Name, inEnd.Description, inEnd.Config = form.Name, form.Description, form.Config
+	// 暂停状态下重启不会改状态, 先清掉, 重启以后按照保存的状态重新暂停
+	if inEnd.Paused() {
+		inEnd.SetState(typex.SOURCE_DOWN)
+	}
+	if err := e.RestartInEnd(inEnd.UUID); err != nil {
+		glogger.GLogger.Error("InEnd update failed, rollback:", err)
+		if err1 := hh.SaveMInEnd(&oldMInEnd); err1 != nil {
+			glogger.GLogger.Error(err1)
+		}
+		inEnd.Name, inEnd.Description, inEnd.Config = oldName, oldDescription, oldConfig
+		if err1 := rollbackInEnd(e, inEnd, oldMInEnd.Paused); err1 != nil {
+			glogger.GLogger.Error("InEnd rollback failed:", err1)
+		}
+		c.JSON(200, Error400(err))
+		return
+	}
+	if mInEnd.Paused {
+		if err := e.PauseInEnd(inEnd.UUID); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 回滚: 先移除新配置的 InEnd, 再用原来的配置加载, 规则绑定在 InEnd 上面, 不会丢
+//
+func rollbackInEnd(e typex.RuleX, inEnd *typex.InEnd, paused bool) error {
+	e.RemoveInEnd(inEnd.UUID)
+	if err := e.LoadInEnd(inEnd); err != nil {
+		return err
+	}
+	if paused {
+		return e.PauseInEnd(inEnd.UUID)
+	}
+	return nil
+}
+
 //
 // Delete inend by UUID
 //
@@ -123,6 +208,9 @@ func DeleteInEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		c.JSON(200, Error400(err))
 	} else {
 		e.RemoveInEnd(uuid)
+		if typex.DefaultShadows != nil {
+			typex.DefaultShadows.Remove(uuid)
+		}
 		c.JSON(200, Ok())
 	}
 
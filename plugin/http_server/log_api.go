@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+type logLevelView struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+func currentLogLevels() logLevelView {
+	level, modules := glogger.Levels()
+	return logLevelView{Level: level, Modules: modules}
+}
+
+//
+// 当前日志级别
+//
+func LogLevel(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	c.JSON(200, OkWithData(currentLogLevels()))
+}
+
+/*
+*
+* 运行时修改日志级别, 不用重启:
+* {"level": "info", "modules": {"engine": "debug", "plugin/http_server": ""}}
+* 模块级别为空表示跟随全局级别
+*
+ */
+func UpdateLogLevel(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		Level   string            `json:"level"`
+		Modules map[string]string `json:"modules"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	// 先全部检查一遍, 不要改一半
+	if form.Level != "" {
+		if _, err := glogger.ParseLevel(form.Level); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	for module, level := range form.Modules {
+		if module == "" {
+			c.JSON(200, Error("module can not be empty"))
+			return
+		}
+		if level == "" {
+			continue
+		}
+		if _, err := glogger.ParseLevel(level); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	if form.Level != "" {
+		if err := glogger.SetLevel(form.Level); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	for module, level := range form.Modules {
+		if err := glogger.SetModuleLevel(module, level); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	c.JSON(200, OkWithData(currentLogLevels()))
+}
@@ -19,6 +19,10 @@ func (f stringList) Value() (driver.Value, error) {
 }
 
 func (f *stringList) Scan(data interface{}) error {
+	// 老版本数据库新加的列可能是 NULL
+	if data == nil {
+		return nil
+	}
 	return json.Unmarshal([]byte(data.(string)), f)
 }
 
@@ -29,9 +33,15 @@ type MRule struct {
 	Description string
 	FromSource  stringList `gorm:"not null type:string[]"`
 	FromDevice  stringList `gorm:"not null type:string[]"`
+	FromTopic   stringList `gorm:"type:string[];default:'[]'"`
 	Actions     string     `gorm:"not null"`
 	Success     string     `gorm:"not null"`
 	Failed      string     `gorm:"not null"`
+	Limits      string     // 资源限制, JSON 格式
+	Schedule    string     // 定时配置, JSON 格式, 空表示不定时
+	Sql         string     // SQL 规则, 空表示 Lua 规则
+	OutEnds     stringList `gorm:"type:string[];default:'[]'"` // SQL 规则的输出目标
+	Stopped     bool       // 停用状态, 重启以后保持
 }
 
 type MInEnd struct {
@@ -43,6 +53,7 @@ type MInEnd struct {
 	Description string
 	Config      string
 	XDataModels string
+	Paused      bool // 暂停状态, 重启以后保持
 }
 
 type MOutEnd struct {
@@ -53,6 +64,7 @@ type MOutEnd struct {
 	Name        string `gorm:"not null"`
 	Description string
 	Config      string
+	Paused      bool // 暂停状态, 重启以后保持
 }
 
 type MUser struct {
@@ -72,6 +84,7 @@ type MDevice struct {
 	ActionScript string
 	Config       string
 	Description  string
+	Paused       bool // 暂停状态, 重启以后保持
 }
 
 //
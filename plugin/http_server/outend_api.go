@@ -1,6 +1,8 @@
 package httpserver
 
 import (
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/target"
 	"github.com/i4de/rulex/typex"
 	"github.com/i4de/rulex/utils"
 
@@ -40,6 +42,14 @@ func DeleteOutEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 	if err := hh.DeleteMOutEnd(uuid); err != nil {
 		c.JSON(200, Error400(err))
 	} else {
+		// 删除 OutEnd 的时候断网缓存也一起清掉
+		if outEnd := e.GetOutEnd(uuid); outEnd != nil {
+			if buffer := outEnd.GetBuffer(); buffer != nil {
+				if err := buffer.Purge(); err != nil {
+					glogger.GLogger.Error(err)
+				}
+			}
+		}
 		e.RemoveOutEnd(uuid)
 		c.JSON(200, Ok())
 	}
@@ -113,3 +123,110 @@ func CreateOutEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 	}
 
 }
+
+//
+// 更新 OutEnd 配置: UUID 和规则绑定都不变, 重启失败就回滚到原来的配置
+//
+func UpdateOutEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		UUID        string                 `json:"uuid" binding:"required"`
+		Name        string                 `json:"name" binding:"required"`
+		Description string                 `json:"description"`
+		Config      map[string]interface{} `json:"config" binding:"required"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	mOutEnd, err := hh.GetMOutEndWithUUID(form.UUID)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	outEnd := e.GetOutEnd(form.UUID)
+	if outEnd == nil {
+		c.JSON(200, Error("OutEnd not exists:"+form.UUID))
+		return
+	}
+	if err := target.ValidateConfig(outEnd.Type, form.Config); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	configJson, err := json.Marshal(form.Config)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	oldMOutEnd := *mOutEnd
+	oldName, oldDescription, oldConfig := outEnd.Name, outEnd.Description, outEnd.Config
+	mOutEnd.Name = form.Name
+	mOutEnd.Description = form.Description
+	mOutEnd.Config = string(configJson)
+	if err := hh.SaveMOutEnd(mOutEnd); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	outEnd.Name, outEnd.Description, outEnd.Config = form.Name, form.Description, form.Config
+	// 暂停状态下重启不会改状态, 先清掉, 重启以后按照保存的状态重新暂停
+	if outEnd.Paused() {
+		outEnd.SetState(typex.SOURCE_DOWN)
+	}
+	if err := e.RestartOutEnd(outEnd.UUID); err != nil {
+		glogger.GLogger.Error("OutEnd update failed, rollback:", err)
+		if err1 := hh.SaveMOutEnd(&oldMOutEnd); err1 != nil {
+			glogger.GLogger.Error(err1)
+		}
+		outEnd.Name, outEnd.Description, outEnd.Config = oldName, oldDescription, oldConfig
+		if err1 := rollbackOutEnd(e, outEnd, oldMOutEnd.Paused); err1 != nil {
+			glogger.GLogger.Error("OutEnd rollback failed:", err1)
+		}
+		c.JSON(200, Error400(err))
+		return
+	}
+	if mOutEnd.Paused {
+		if err := e.PauseOutEnd(outEnd.UUID); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 回滚: 先移除新配置的 OutEnd, 再用原来的配置加载
+//
+func rollbackOutEnd(e typex.RuleX, outEnd *typex.OutEnd, paused bool) error {
+	e.RemoveOutEnd(outEnd.UUID)
+	if err := e.LoadOutEnd(outEnd); err != nil {
+		return err
+	}
+	if paused {
+		return e.PauseOutEnd(outEnd.UUID)
+	}
+	return nil
+}
+
+/*
+*
+* 断网缓存状态
+*
+ */
+func OutEndBuffers(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if uuid == "" {
+		data := []interface{}{}
+		e.AllOutEnd().Range(func(key, value interface{}) bool {
+			data = append(data, value.(*typex.OutEnd).BufferStatus())
+			return true
+		})
+		c.JSON(200, OkWithData(data))
+		return
+	}
+	outEnd := e.GetOutEnd(uuid)
+	if outEnd == nil {
+		c.JSON(200, Error("OutEnd not exists:"+uuid))
+		return
+	}
+	c.JSON(200, OkWithData(outEnd.BufferStatus()))
+}
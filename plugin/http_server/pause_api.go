@@ -0,0 +1,133 @@
+package httpserver
+
+import (
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+*
+* 暂停和恢复资源: 先保存到数据库, 重启以后保持暂停
+*
+ */
+
+//
+// 暂停 InEnd
+//
+func PauseInEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMInEndWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMInEndPaused(uuid, true); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.PauseInEnd(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 恢复 InEnd
+//
+func ResumeInEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMInEndWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMInEndPaused(uuid, false); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.ResumeInEnd(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 暂停 OutEnd
+//
+func PauseOutEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMOutEndWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMOutEndPaused(uuid, true); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.PauseOutEnd(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 恢复 OutEnd
+//
+func ResumeOutEnd(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMOutEndWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMOutEndPaused(uuid, false); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.ResumeOutEnd(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 暂停设备
+//
+func PauseDevice(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetDeviceWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetDevicePaused(uuid, true); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.PauseDevice(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 恢复设备
+//
+func ResumeDevice(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetDeviceWithUUID(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetDevicePaused(uuid, false); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := e.ResumeDevice(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
@@ -0,0 +1,259 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/i4de/rulex/sidecar"
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/disk"
+)
+
+/*
+*
+* Prometheus 文本格式的指标, 给 Prometheus 直接抓取
+*
+ */
+func PrometheusMetrics(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	buf := &bytes.Buffer{}
+	WritePrometheusMetrics(buf, e)
+	c.Data(200, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promWriter struct {
+	w io.Writer
+}
+
+// 每个指标先写 HELP 和 TYPE
+func (p *promWriter) header(name string, typ string, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name string, value float64, labels ...promLabel) {
+	io.WriteString(p.w, name)
+	if len(labels) > 0 {
+		parts := make([]string, 0, len(labels))
+		for _, l := range labels {
+			parts = append(parts, l.name+`="`+promEscape(l.value)+`"`)
+		}
+		io.WriteString(p.w, "{"+strings.Join(parts, ",")+"}")
+	}
+	io.WriteString(p.w, " "+strconv.FormatFloat(value, 'g', -1, 64)+"\n")
+}
+
+func (p *promWriter) metric(name string, typ string, help string, value float64, labels ...promLabel) {
+	p.header(name, typ, help)
+	p.sample(name, value, labels...)
+}
+
+// 标签值里面的反斜杠、双引号和换行要转义
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// 一个资源的基本标签
+type promResource struct {
+	uuid       string
+	name       string
+	typ        string
+	status     float64
+	supervisor *typex.Supervisor
+}
+
+func (r promResource) labels() []promLabel {
+	labels := []promLabel{{"uuid", r.uuid}, {"name", r.name}}
+	if r.typ != "" {
+		labels = append(labels, promLabel{"type", r.typ})
+	}
+	return labels
+}
+
+/*
+*
+* 写出所有指标
+*
+ */
+func WritePrometheusMetrics(w io.Writer, e typex.RuleX) {
+	p := &promWriter{w: w}
+	// 全局计数
+	s := statistics.AllStatistics()
+	p.metric("rulex_in_success_total", "counter", "Messages accepted into the engine.", float64(s.InSuccess))
+	p.metric("rulex_in_failed_total", "counter", "Messages rejected by the engine.", float64(s.InFailed))
+	p.metric("rulex_out_success_total", "counter", "Messages delivered to OutEnds.", float64(s.OutSuccess))
+	p.metric("rulex_out_failed_total", "counter", "Messages failed to deliver to OutEnds.", float64(s.OutFailed))
+	// 消息队列
+	q := typex.DefaultDataCacheQueue.Statistics()
+	p.metric("rulex_queue_size", "gauge", "Capacity of the data cache queue.", float64(q.Size))
+	p.metric("rulex_queue_depth", "gauge", "Messages waiting in the data cache queue.", float64(q.Depth))
+	p.metric("rulex_queue_spill_depth", "gauge", "Messages spilled to disk.", float64(q.SpillDepth))
+	p.metric("rulex_queue_workers", "gauge", "Queue worker goroutines.", float64(q.Workers))
+	p.metric("rulex_queue_busy_workers", "gauge", "Queue workers processing a message.", float64(q.BusyWorkers))
+	p.metric("rulex_queue_processed_total", "counter", "Messages processed by queue workers.", float64(q.Processed))
+	p.metric("rulex_queue_rejected_total", "counter", "Messages rejected because the queue was full.", float64(q.Rejected))
+	p.metric("rulex_queue_dropped_oldest_total", "counter", "Oldest messages dropped because the queue was full.", float64(q.DroppedOldest))
+	// 资源状态和按资源统计的指标
+	all := statistics.AllMetrics()
+	inends := []promResource{}
+	e.AllInEnd().Range(func(key, value interface{}) bool {
+		in := value.(*typex.InEnd)
+		status := in.GetState()
+		if in.Source != nil {
+			status = in.Source.Status()
+		}
+		if in.Supervisor != nil && in.Supervisor.Failed() {
+			status = typex.SOURCE_FAILED
+		}
+		if in.Paused() {
+			status = typex.SOURCE_PAUSE
+		}
+		inends = append(inends, promResource{in.UUID, in.Name, in.Type.String(), float64(status), in.Supervisor})
+		return true
+	})
+	outends := []promResource{}
+	e.AllOutEnd().Range(func(key, value interface{}) bool {
+		out := value.(*typex.OutEnd)
+		status := out.GetState()
+		if out.Target != nil {
+			status = out.Target.Status()
+		}
+		if out.Supervisor != nil && out.Supervisor.Failed() {
+			status = typex.SOURCE_FAILED
+		}
+		if out.Paused() {
+			status = typex.SOURCE_PAUSE
+		}
+		outends = append(outends, promResource{out.UUID, out.Name, out.Type.String(), float64(status), out.Supervisor})
+		return true
+	})
+	devices := []promResource{}
+	e.AllDevices().Range(func(key, value interface{}) bool {
+		dev := value.(*typex.Device)
+		status := dev.GetState()
+		if dev.Device != nil {
+			status = dev.Device.Status()
+		}
+		if dev.Supervisor != nil && dev.Supervisor.Failed() {
+			status = typex.DEV_FAILED
+		}
+		if dev.Paused() {
+			status = typex.DEV_PAUSE
+		}
+		devices = append(devices, promResource{dev.UUID, dev.Name, string(dev.Type), float64(status), dev.Supervisor})
+		return true
+	})
+	rules := []promResource{}
+	e.AllRule().Range(func(key, value interface{}) bool {
+		rule := value.(*typex.Rule)
+		rules = append(rules, promResource{rule.UUID, rule.Name, "", float64(rule.GetStatus()), nil})
+		return true
+	})
+	p.resources("inend", "InEnd", "0=DOWN, 1=UP, 2=PAUSE, 3=FAILED", inends, all[statistics.INEND])
+	p.resources("outend", "OutEnd", "0=DOWN, 1=UP, 2=PAUSE, 3=FAILED", outends, all[statistics.OUTEND])
+	p.resources("device", "Device", "0=STOP, 1=RUNNING, 2=FAILED, 3=PAUSE", devices, all[statistics.DEVICE])
+	p.resources("rule", "Rule", "0=STOP, 1=RUNNING", rules, all[statistics.RULE])
+	// 外挂进程
+	p.header("rulex_sidecar_running", "gauge", "Sidecar process status, 1=running.")
+	e.AllGoods().Range(func(key, value interface{}) bool {
+		goods := value.(*sidecar.GoodsProcess)
+		running := 0.0
+		if goods.Running() {
+			running = 1
+		}
+		p.sample("rulex_sidecar_running", running, promLabel{"uuid", goods.UUID()}, promLabel{"addr", goods.Addr()})
+		return true
+	})
+	// 运行时, 和 SnapshotDump 里面的一致
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	p.metric("rulex_memory_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(m.Sys))
+	p.metric("rulex_memory_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(m.Alloc))
+	p.metric("rulex_memory_total_alloc_bytes", "counter", "Cumulative bytes allocated for heap objects.", float64(m.TotalAlloc))
+	p.metric("rulex_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	if parts, err := disk.Partitions(true); err == nil && len(parts) > 0 {
+		if usage, err := disk.Usage(parts[0].Mountpoint); err == nil {
+			p.metric("rulex_disk_used_percent", "gauge", "Disk usage percent.", usage.UsedPercent)
+		}
+	}
+	p.metric("rulex_info", "gauge", "Rulex version and platform.", 1,
+		promLabel{"version", e.Version().Version}, promLabel{"os_arch", runtime.GOOS + "-" + runtime.GOARCH})
+}
+
+//
+// 一类资源的状态、消息数、错误数、字节数和延迟直方图
+//
+func (p *promWriter) resources(kind string, title string, states string,
+	resources []promResource, metrics map[string]*statistics.Metrics) {
+	sort.Slice(resources, func(i, j int) bool { return resources[i].uuid < resources[j].uuid })
+	prefix := "rulex_" + kind
+	p.header(prefix+"_status", "gauge", title+" status, "+states+".")
+	for _, r := range resources {
+		p.sample(prefix+"_status", r.status, r.labels()...)
+	}
+	// 规则没有重启
+	if kind != "rule" {
+		p.header(prefix+"_restarts_total", "counter", title+" restarts.")
+		for _, r := range resources {
+			v := 0.0
+			if r.supervisor != nil {
+				v = float64(r.supervisor.Restarts())
+			}
+			p.sample(prefix+"_restarts_total", v, r.labels()...)
+		}
+	}
+	counters := []struct {
+		name  string
+		help  string
+		value func(m *statistics.Metrics) uint64
+	}{
+		{"_messages_total", " messages.", func(m *statistics.Metrics) uint64 { return m.Messages }},
+		{"_errors_total", " errors.", func(m *statistics.Metrics) uint64 { return m.Errors }},
+		{"_bytes_total", " bytes.", func(m *statistics.Metrics) uint64 { return m.Bytes }},
+	}
+	for _, counter := range counters {
+		p.header(prefix+counter.name, "counter", title+counter.help)
+		for _, r := range resources {
+			v := 0.0
+			if m := metrics[r.uuid]; m != nil {
+				v = float64(counter.value(m))
+			}
+			p.sample(prefix+counter.name, v, r.labels()...)
+		}
+	}
+	// 只有规则和 OutEnd 有延迟
+	name := prefix + "_latency_milliseconds"
+	headed := false
+	for _, r := range resources {
+		m := metrics[r.uuid]
+		if m == nil || m.Latency == nil {
+			continue
+		}
+		if !headed {
+			p.header(name, "histogram", title+" processing latency in milliseconds.")
+			headed = true
+		}
+		var cumulative uint64
+		for i, count := range m.Latency.Counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(m.Latency.Buckets) {
+				le = strconv.FormatFloat(m.Latency.Buckets[i], 'g', -1, 64)
+			}
+			p.sample(name+"_bucket", float64(cumulative), append(r.labels(), promLabel{"le", le})...)
+		}
+		p.sample(name+"_sum", m.Latency.Sum, r.labels()...)
+		p.sample(name+"_count", float64(m.Latency.Count), r.labels()...)
+	}
+}
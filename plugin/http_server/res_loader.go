@@ -36,9 +36,12 @@ func (hh *HttpApiServer) LoadNewestInEnd(uuid string) error {
 	if err2 := hh.ruleEngine.LoadInEnd(in); err2 != nil {
 		glogger.GLogger.Error(err2)
 		return err2
-	} else {
-		return nil
 	}
+	// 更新以后保持暂停状态
+	if mInEnd.Paused {
+		return hh.ruleEngine.PauseInEnd(in.UUID)
+	}
+	return nil
 
 }
 
@@ -58,9 +61,11 @@ func (hh *HttpApiServer) LoadNewestOutEnd(uuid string) error {
 	out.UUID = mOutEnd.UUID
 	if err := hh.ruleEngine.LoadOutEnd(out); err != nil {
 		return err
-	} else {
-		return nil
 	}
+	if mOutEnd.Paused {
+		return hh.ruleEngine.PauseOutEnd(out.UUID)
+	}
+	return nil
 
 }
 
@@ -80,8 +85,10 @@ func (hh *HttpApiServer) LoadNewestDevice(uuid string) error {
 	dev.UUID = mDevice.UUID // 本质上是配置和内存的数据映射起来
 	if err := hh.ruleEngine.LoadDevice(dev); err != nil {
 		return err
-	} else {
-		return nil
 	}
+	if mDevice.Paused {
+		return hh.ruleEngine.PauseDevice(dev.UUID)
+	}
+	return nil
 
 }
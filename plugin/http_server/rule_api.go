@@ -1,9 +1,12 @@
 package httpserver
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/sqlrule"
 	"github.com/i4de/rulex/typex"
 	"github.com/i4de/rulex/utils"
 
@@ -45,11 +48,19 @@ func CreateRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		UUID        string   `json:"uuid"` // 如果空串就是新建，非空就是更新
 		FromSource  []string `json:"fromSource" binding:"required"`
 		FromDevice  []string `json:"fromDevice" binding:"required"`
+		FromTopic   []string `json:"fromTopic"`
 		Name        string   `json:"name" binding:"required"`
 		Description string   `json:"description"`
 		Actions     string   `json:"actions"`
 		Success     string   `json:"success"`
 		Failed      string   `json:"failed"`
+		// 资源限制
+		Limits typex.RuleLimits `json:"limits"`
+		// 定时执行
+		Schedule *typex.RuleSchedule `json:"schedule"`
+		// SQL 规则, 和 Actions 二选一
+		Sql     string   `json:"sql"`
+		OutEnds []string `json:"outEnds"`
 	}
 	form := Form{}
 
@@ -57,6 +68,27 @@ func CreateRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		c.JSON(200, Error400(err))
 		return
 	}
+	if form.Sql != "" {
+		if form.Actions != "" {
+			c.JSON(200, Error(`sql 和 actions 不能同时存在`))
+			return
+		}
+		program, err := compileSqlRule(e, form.Sql, form.OutEnds)
+		if err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+		form.Actions = program.Actions
+		form.FromSource, form.FromDevice = splitSqlSources(e, program.From, form.FromSource, form.FromDevice)
+		if form.Success == "" {
+			form.Success = "function Success() end"
+		}
+		if form.Failed == "" {
+			form.Failed = "function Failed(error) end"
+		}
+	} else {
+		form.OutEnds = []string{}
+	}
 	lenSources := len(form.FromSource)
 	lenDevices := len(form.FromDevice)
 	if lenSources > 0 {
@@ -78,10 +110,20 @@ func CreateRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 			}
 		}
 	}
-	if (lenDevices < 1) && (lenSources < 1) {
-		c.JSON(200, Error(`必须有一个数据输入项`))
+	if (lenDevices < 1) && (lenSources < 1) && (len(form.FromTopic) < 1) && (form.Schedule == nil) {
+		c.JSON(200, Error(`必须有一个数据输入项或者定时配置`))
 		return
 	}
+	schedule := ""
+	if form.Schedule != nil {
+		form.Schedule.Status = nil
+		if err := form.Schedule.Validate(); err != nil {
+			c.JSON(200, Error400(err))
+			return
+		}
+		b, _ := json.Marshal(form.Schedule)
+		schedule = string(b)
+	}
 
 	// tmpRule 是一个一次性的临时rule，用来验证规则，这么做主要是为了防止真实Lua Vm 被污染
 	tmpRule := typex.NewRule(nil,
@@ -93,34 +135,39 @@ func CreateRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		form.Success,
 		form.Actions,
 		form.Failed)
-
+	tmpRule.SetLimits(form.Limits)
 	if err := core.VerifyCallback(tmpRule); err != nil {
 		c.JSON(200, Error400(err))
 		return
 	}
-	// 如果是更新操作, 先删除规则
+	// 如果是更新操作, UUID 和启停状态保持不变
+	uuid := utils.MakeUUID("RULE")
+	stopped := false
 	if form.UUID != "" {
-		if err1 := hh.DeleteMRule(form.UUID); err1 != nil {
-			c.JSON(200, Error400(err1))
+		oldRule, err0 := hh.GetMRule(form.UUID)
+		if err0 != nil {
+			c.JSON(200, Error400(err0))
 			return
-		} else {
-			e.RemoveRule(form.UUID)
 		}
+		uuid = oldRule.UUID
+		stopped = oldRule.Stopped
 	}
-	//
+	limits, _ := json.Marshal(form.Limits)
 	mRule := &MRule{
-		UUID:        utils.MakeUUID("RULE"),
+		UUID:        uuid,
 		Name:        form.Name,
 		Description: form.Description,
 		FromSource:  form.FromSource,
 		FromDevice:  form.FromDevice,
+		FromTopic:   form.FromTopic,
 		Success:     form.Success,
 		Failed:      form.Failed,
 		Actions:     form.Actions,
-	}
-	if err := hh.InsertMRule(mRule); err != nil {
-		c.JSON(200, Error400(err))
-		return
+		Limits:      string(limits),
+		Schedule:    schedule,
+		Sql:         form.Sql,
+		OutEnds:     form.OutEnds,
+		Stopped:     stopped,
 	}
 	rule := typex.NewRule(hh.ruleEngine,
 		mRule.UUID,
@@ -131,12 +178,104 @@ func CreateRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		mRule.Success,
 		mRule.Actions,
 		mRule.Failed)
+	rule.FromTopic = mRule.FromTopic
+	rule.Sql = mRule.Sql
+	rule.OutEnds = mRule.OutEnds
+	rule.SetLimits(form.Limits)
+	rule.Schedule = form.Schedule
+	if mRule.Stopped {
+		rule.SetStatus(typex.RULE_STOP)
+	}
+	// 先把新规则换到引擎里面, 加载失败就恢复旧规则, 数据库不动; 统计数据保留
+	oldRule := e.GetRule(uuid)
+	if oldRule != nil {
+		e.UnloadRule(uuid)
+	}
 	if err := e.LoadRule(rule); err != nil {
+		e.UnloadRule(uuid)
+		restoreRule(e, oldRule)
 		c.JSON(200, Error400(err))
+		return
+	}
+	var err error
+	if form.UUID != "" {
+		err = hh.ReplaceMRule(uuid, mRule)
 	} else {
-		c.JSON(200, Ok())
+		err = hh.InsertMRule(mRule)
+	}
+	if err != nil {
+		e.UnloadRule(uuid)
+		restoreRule(e, oldRule)
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 更新失败的时候把旧规则重新加载回去, 旧的规则对象可能还在 worker 里面执行, 所以重新建一个
+//
+func restoreRule(e typex.RuleX, old *typex.Rule) {
+	if old == nil {
+		return
+	}
+	rule := typex.NewRule(e,
+		old.UUID,
+		old.Name,
+		old.Description,
+		old.FromSource,
+		old.FromDevice,
+		old.Success,
+		old.Actions,
+		old.Failed)
+	rule.FromTopic = old.FromTopic
+	rule.Sql = old.Sql
+	rule.OutEnds = old.OutEnds
+	rule.SetLimits(old.Limits)
+	rule.Schedule = old.Schedule
+	rule.SetStatus(old.GetStatus())
+	if err := e.LoadRule(rule); err != nil {
+		glogger.GLogger.Error("Restore rule error:", err)
 	}
-	return
+}
+
+/*
+*
+* 编译 SQL 规则, 同时检查数据来源和输出目标是否存在
+*
+ */
+func compileSqlRule(e typex.RuleX, sql string, outEnds []string) (*sqlrule.Program, error) {
+	program, err := sqlrule.Compile(sql, outEnds)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range program.From {
+		if e.GetInEnd(id) == nil && e.GetDevice(id) == nil {
+			return nil, fmt.Errorf("inend or device not exists: %v", id)
+		}
+	}
+	for _, id := range outEnds {
+		if e.GetOutEnd(id) == nil {
+			return nil, fmt.Errorf("outend not exists: %v", id)
+		}
+	}
+	return program, nil
+}
+
+//
+// FROM 里面的 UUID 可能是输入源也可能是设备, 合并到已有的来源里面
+//
+func splitSqlSources(e typex.RuleX, from []string, sources []string, devices []string) ([]string, []string) {
+	for _, id := range from {
+		if e.GetInEnd(id) != nil {
+			if !utils.SContains(sources, id) {
+				sources = append(sources, id)
+			}
+		} else if !utils.SContains(devices, id) {
+			devices = append(devices, id)
+		}
+	}
+	return sources, devices
 }
 
 //
@@ -158,6 +297,61 @@ func DeleteRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 
 }
 
+//
+// 启用规则
+//
+func StartRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMRule(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	// 先改引擎, 保存失败再改回去, 数据库和引擎不会不一致
+	rule := e.GetRule(uuid)
+	if rule == nil {
+		c.JSON(200, Error400(fmt.Errorf("rule not exists: %v", uuid)))
+		return
+	}
+	old := rule.GetStatus()
+	if err := e.StartRule(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMRuleStopped(uuid, false); err != nil {
+		rule.SetStatus(old)
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
+//
+// 停用规则, 不删除
+//
+func StopRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := hh.GetMRule(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	rule := e.GetRule(uuid)
+	if rule == nil {
+		c.JSON(200, Error400(fmt.Errorf("rule not exists: %v", uuid)))
+		return
+	}
+	old := rule.GetStatus()
+	if err := e.StopRule(uuid); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if err := hh.SetMRuleStopped(uuid, true); err != nil {
+		rule.SetStatus(old)
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, Ok())
+}
+
 /*
 *
 * 验证lua语法
@@ -240,3 +434,38 @@ func TestOutEndCallback(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 	e.PushOutQueue((value).(*typex.OutEnd), data)
 	c.JSON(200, Ok())
 }
+
+/*
+*
+* 内部主题的订阅和统计
+*
+ */
+func Topics(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	c.JSON(200, OkWithData(typex.DefaultTopicBus.Statistics()))
+}
+
+/*
+*
+* 试运行规则: 用样例数据跑一遍, 返回每一步的结果、日志和本来要发出去的数据
+*
+ */
+func DryRunRule(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		Actions  string           `json:"actions" binding:"required"`
+		Success  string           `json:"success" binding:"required"`
+		Failed   string           `json:"failed" binding:"required"`
+		Payloads []string         `json:"payloads" binding:"required"`
+		Limits   typex.RuleLimits `json:"limits"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	results, err := e.DryRunRule(form.Success, form.Actions, form.Failed, form.Limits, form.Payloads)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(results))
+}
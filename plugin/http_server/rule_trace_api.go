@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"time"
+
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+*
+* 打开规则追踪: 追踪 maxMessages 条消息或者 duration 秒
+*
+ */
+func StartRuleTrace(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		UUID        string `json:"uuid" binding:"required"`
+		MaxMessages int    `json:"maxMessages"`
+		Duration    int    `json:"duration"` // 单位秒
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if e.GetRule(form.UUID) == nil {
+		c.JSON(200, Error("rule not exists: "+form.UUID))
+		return
+	}
+	session, err := typex.DefaultRuleTracer.Start(form.UUID, form.MaxMessages,
+		time.Duration(form.Duration)*time.Second)
+	if err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(session))
+}
+
+/*
+*
+* 关闭规则追踪, 记录保留
+*
+ */
+func StopRuleTrace(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	typex.DefaultRuleTracer.Stop(uuid)
+	c.JSON(200, Ok())
+}
+
+/*
+*
+* 获取追踪记录, 不传 uuid 返回正在进行的追踪
+*
+ */
+func RuleTraces(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	if uuid == "" {
+		c.JSON(200, OkWithData(typex.DefaultRuleTracer.Sessions()))
+		return
+	}
+	c.JSON(200, OkWithData(typex.DefaultRuleTracer.Records(uuid)))
+}
+
+/*
+*
+* 清空追踪记录
+*
+ */
+func ClearRuleTraces(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	uuid, _ := c.GetQuery("uuid")
+	typex.DefaultRuleTracer.Clear(uuid)
+	c.JSON(200, Ok())
+}
@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/i4de/rulex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errShadowNotStarted = errors.New("shadow store not started")
+
+// 影子加上期望值和上报值的差异
+type shadowView struct {
+	typex.Shadow
+	Delta map[string]interface{} `json:"delta"`
+}
+
+func newShadowView(shadow typex.Shadow) shadowView {
+	return shadowView{Shadow: shadow, Delta: shadow.Delta()}
+}
+
+//
+// 影子列表, 可以按 UUID 查询
+//
+func Shadows(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	if typex.DefaultShadows == nil {
+		c.JSON(200, Error400(errShadowNotStarted))
+		return
+	}
+	uuid, _ := c.GetQuery("uuid")
+	if uuid == "" {
+		shadows := typex.DefaultShadows.All()
+		sort.Slice(shadows, func(i, j int) bool { return shadows[i].UUID < shadows[j].UUID })
+		data := []shadowView{}
+		for _, shadow := range shadows {
+			data = append(data, newShadowView(shadow))
+		}
+		c.JSON(200, OkWithData(data))
+		return
+	}
+	shadow, ok := typex.DefaultShadows.Get(uuid)
+	if !ok {
+		if e.GetInEnd(uuid) == nil && e.GetDevice(uuid) == nil {
+			c.JSON(200, Error("inend or device not exists:"+uuid))
+			return
+		}
+		shadow = typex.Shadow{UUID: uuid, Reported: map[string]typex.ShadowValue{},
+			Desired: map[string]typex.ShadowValue{}}
+	}
+	c.JSON(200, OkWithData(newShadowView(shadow)))
+}
+
+/*
+*
+* 设置期望值, 值为 null 表示删除; 和上报值不一样的字段会马上下发
+*
+ */
+func UpdateShadowDesired(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	type Form struct {
+		UUID    string                 `json:"uuid" binding:"required"`
+		Desired map[string]interface{} `json:"desired" binding:"required"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	if typex.DefaultShadows == nil {
+		c.JSON(200, Error400(errShadowNotStarted))
+		return
+	}
+	if e.GetInEnd(form.UUID) == nil && e.GetDevice(form.UUID) == nil {
+		c.JSON(200, Error("inend or device not exists:"+form.UUID))
+		return
+	}
+	shadow := typex.DefaultShadows.Desire(form.UUID, form.Desired)
+	if err := typex.DefaultShadows.DeliverDelta(e, form.UUID); err != nil {
+		c.JSON(200, Error400(err))
+		return
+	}
+	c.JSON(200, OkWithData(newShadowView(shadow)))
+}
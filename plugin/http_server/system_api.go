@@ -68,6 +68,7 @@ func System(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 			"cpuPercent":  calculateCpuPercent(cpuPercent),
 			"osArch":      runtime.GOOS + "-" + runtime.GOARCH,
 			"startedTime": StartedTime,
+			"queue":       typex.DefaultDataCacheQueue.Statistics(),
 		},
 	})
 }
@@ -91,7 +92,38 @@ func Drivers(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 // Get statistics data
 //
 func Statistics(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
-	c.JSON(200, OkWithData(statistics.AllStatistics()))
+	s := statistics.AllStatistics()
+	c.JSON(200, OkWithData(gin.H{
+		"inSuccess":  s.InSuccess,
+		"outSuccess": s.OutSuccess,
+		"inFailed":   s.InFailed,
+		"outFailed":  s.OutFailed,
+		"queue":      typex.DefaultDataCacheQueue.Statistics(),
+		"topics":     typex.DefaultTopicBus.Statistics(),
+	}))
+}
+
+//
+// 按资源统计的指标, 可以用 kind(inends/devices/rules/outends) 和 uuid 过滤
+//
+func Metrics(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
+	kind, _ := c.GetQuery("kind")
+	uuid, _ := c.GetQuery("uuid")
+	all := statistics.AllMetrics()
+	if kind == "" {
+		c.JSON(200, OkWithData(all))
+		return
+	}
+	metrics, ok := all[kind]
+	if !ok {
+		c.JSON(200, Error("unknown metrics kind: "+kind))
+		return
+	}
+	if uuid == "" {
+		c.JSON(200, OkWithData(metrics))
+		return
+	}
+	c.JSON(200, OkWithData(metrics[uuid]))
 }
 
 //
@@ -102,7 +102,7 @@ func Logs(c *gin.Context, hh *HttpApiServer, e typex.RuleX) {
 		Content string `json:"content" binding:"required"`
 	}
 	logs := []Data{}
-	for i, s := range glogger.GLOBAL_LOGGER.Slot() {
+	for i, s := range glogger.GLOBAL_LOGGER.Tail(1000) {
 		if s != "" {
 			logs = append(logs, Data{i, s})
 		}
@@ -1,8 +1,11 @@
 package httpserver
 
 import (
+	"sync"
+
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
 )
 
 /*
@@ -35,11 +38,75 @@ func configSocketIO(server *socketio.Server) {
 		return last
 	})
 
+	//
+	// 订阅规则追踪: 客户端发送规则 UUID, 之后收到 trace 事件
+	//
+	server.OnEvent("/", "trace", func(s socketio.Conn, ruleUUID string) {
+		s.Join(traceRoom(ruleUUID))
+	})
+	server.OnEvent("/", "untrace", func(s socketio.Conn, ruleUUID string) {
+		s.Leave(traceRoom(ruleUUID))
+	})
+	typex.DefaultRuleTracer.OnRecord(func(r typex.TraceRecord) {
+		server.BroadcastToRoom("/", traceRoom(r.RuleUUID), "trace", r)
+	})
+
+	//
+	// 订阅实时日志: 客户端发送过滤条件, 之后收到 log 事件;
+	// 缓冲满了丢掉的条数通过 log_dropped 事件告诉客户端
+	//
+	server.OnEvent("/", "logs", func(s socketio.Conn, filter glogger.LogFilter) string {
+		if err := subscribeLog(s, filter); err != nil {
+			return err.Error()
+		}
+		return "ok"
+	})
+	server.OnEvent("/", "unlogs", func(s socketio.Conn) {
+		unsubscribeLog(s.ID())
+	})
+
 	server.OnError("/", func(s socketio.Conn, e error) {
 		glogger.GLogger.Debug("meet error:", e)
 	})
 
 	server.OnDisconnect("/", func(s socketio.Conn, msg string) {
+		unsubscribeLog(s.ID())
 		glogger.GLogger.Debug("closed", msg)
 	})
 }
+
+func traceRoom(ruleUUID string) string {
+	return "trace:" + ruleUUID
+}
+
+// 每个连接一个日志订阅, key 是连接 ID
+var logSubscribers sync.Map
+
+/*
+*
+* 重新订阅会替换掉之前的过滤条件
+*
+ */
+func subscribeLog(s socketio.Conn, filter glogger.LogFilter) error {
+	sub, err := glogger.SubscribeLog(filter, glogger.DEFAULT_LOG_BUFFER)
+	if err != nil {
+		return err
+	}
+	unsubscribeLog(s.ID())
+	logSubscribers.Store(s.ID(), sub)
+	go func() {
+		for entry := range sub.Entries() {
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				s.Emit("log_dropped", dropped)
+			}
+			s.Emit("log", entry)
+		}
+	}()
+	return nil
+}
+
+func unsubscribeLog(id string) {
+	if sub, ok := logSubscribers.LoadAndDelete(id); ok {
+		glogger.UnsubscribeLog(sub.(*glogger.LogSubscriber))
+	}
+}
@@ -1,6 +1,9 @@
 package rulexlib
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/i4de/rulex/typex"
 
 	lua "github.com/yuin/gopher-lua"
@@ -8,30 +11,90 @@ import (
 
 /*
 *
-* 改变模型值
+* 改变模型值, 同时更新影子的上报值:
+* rulexlib:SetModelValue(uuid, name, value) -> err
 *
  */
 func SetModelValue(rx typex.RuleX) func(*lua.LState) int {
 	return func(l *lua.LState) int {
 		uuid := l.ToString(2)
 		name := l.ToString(3)
-		value := l.ToString(4)
-		setValue(rx, uuid, name, value)
-		return 0
+		value, err := luaToGo(l.Get(4))
+		if err == nil {
+			err = setValue(rx, uuid, map[string]interface{}{name: value})
+		}
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
 	}
 }
 
 /*
 *
-* 改变值
+* 一次上报多个值: rulexlib:ReportShadow(uuid, {k1 = v1, k2 = v2}) -> err
 *
  */
-func setValue(rx typex.RuleX, uuid, name, value string) {
+func ReportShadow(rx typex.RuleX) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		uuid := l.ToString(2)
+		var err error
+		if table := l.ToTable(3); table == nil {
+			err = errors.New("values must be a table")
+		} else {
+			var v interface{}
+			if v, err = luaToGo(table); err == nil {
+				// 空 table 会被编码成数组
+				values, _ := v.(map[string]interface{})
+				err = setValue(rx, uuid, values)
+			}
+		}
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
 
+/*
+*
+* 改变值: InEnd 定义了数据模型的话只能改模型里面的字段;
+* 值只保存在影子里面, 数据模型是只读的定义, 多个 worker 同时执行也不会冲突
+*
+ */
+func setValue(rx typex.RuleX, uuid string, values map[string]interface{}) error {
 	in := rx.GetInEnd(uuid)
-	if in != nil {
-		DataModel := in.DataModelsMap[name]
-		DataModel.Value = value
-		in.DataModelsMap[name] = DataModel
+	if in == nil && rx.GetDevice(uuid) == nil {
+		return errors.New("inend or device not exists:" + uuid)
+	}
+	if in != nil && len(in.DataModelsMap) > 0 {
+		for name := range values {
+			if _, ok := in.DataModelsMap[name]; !ok {
+				return errors.New("data model not exists:" + name)
+			}
+		}
+	}
+	if typex.DefaultShadows != nil {
+		typex.DefaultShadows.Report(uuid, values)
+		// 上报以后还有差异, 上次没下发成功的话再发一次
+		typex.DefaultShadows.Redeliver(rx, uuid)
+	}
+	return nil
+}
+
+// Lua 的值转成 Go 的值, table 按 JSON 转换
+func luaToGo(value lua.LValue) (interface{}, error) {
+	b, err := _Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
 	}
+	return v, nil
 }
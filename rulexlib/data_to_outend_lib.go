@@ -0,0 +1,21 @@
+package rulexlib
+
+import (
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 通用转发: 不关心目标类型, 直接把数据推到 OutEnd 的队列
+*
+ */
+func DataToOutEnd(rx typex.RuleX) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		id := l.ToString(2)
+		data := l.ToString(3)
+		handleDataFormat(rx, id, data)
+		return 0
+	}
+}
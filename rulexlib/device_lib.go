@@ -47,6 +47,11 @@ func WriteDevice(rx typex.RuleX) func(*lua.LState) int {
 		devUUID := l.ToString(2)
 		data := l.ToString(3)
 		Device := rx.GetDevice(devUUID)
+		if Device != nil && Device.Paused() {
+			l.Push(lua.LNil)
+			l.Push(lua.LString("device paused:" + devUUID))
+			return 2
+		}
 		if Device != nil {
 			n, err := Device.Device.OnWrite([]byte(data))
 			if err != nil {
@@ -0,0 +1,48 @@
+package rulexlib
+
+import (
+	"errors"
+
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 写本地历史数据: rulexlib:WriteHistory(metric, value, {tag1 = "v1"}) -> err,
+* 标签可以不传
+*
+ */
+func WriteHistory(rx typex.RuleX) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		err := writeHistory(l)
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+func writeHistory(l *lua.LState) error {
+	if typex.DefaultHistory == nil {
+		return errors.New("history store not started")
+	}
+	value, ok := l.Get(3).(lua.LNumber)
+	if !ok {
+		return errors.New("value must be a number")
+	}
+	tags := map[string]string{}
+	if table, ok := l.Get(4).(*lua.LTable); ok {
+		table.ForEach(func(k, v lua.LValue) {
+			tags[k.String()] = v.String()
+		})
+	}
+	return typex.DefaultHistory.Write(typex.HistoryPoint{
+		Metric: l.ToString(2),
+		Tags:   tags,
+		Value:  float64(value),
+	})
+}
@@ -14,6 +14,17 @@ func Log(rx typex.RuleX) func(*lua.LState) int {
 	return func(l *lua.LState) int {
 		content := l.ToString(2)
 		glogger.LUA_LOGGER.Write([]byte("[" + time.Now().Format("2006-01-02 15:04:05") + "]: " + content + "\n"))
+		// 推给实时日志, 带上规则和数据来源
+		entry := glogger.LogEntry{
+			Source:  glogger.LOG_SOURCE_LUA,
+			Level:   "info",
+			Rule:    typex.CurrentRuleUUID(l),
+			Message: content,
+		}
+		if envelope := typex.CurrentEnvelope(l); envelope != nil {
+			entry.Resource = envelope.Origin
+		}
+		glogger.PublishLog(entry)
 		return 0
 	}
 }
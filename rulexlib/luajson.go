@@ -90,6 +90,12 @@ func (i invalidTypeError) Error() string {
 	return `cannot encode ` + lua.LValueType(i).String() + ` to JSON`
 }
 
+// EncodeValue returns the JSON encoding of value as string.
+func EncodeValue(value lua.LValue) (string, error) {
+	b, err := _Encode(value)
+	return string(b), err
+}
+
 // _Encode returns the JSON encoding of value.
 func _Encode(value lua.LValue) ([]byte, error) {
 	return json.Marshal(jsonValue{
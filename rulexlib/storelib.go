@@ -1,6 +1,8 @@
 package rulexlib
 
 import (
+	"time"
+
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/typex"
 
@@ -35,3 +37,56 @@ func StoreDelete(rx typex.RuleX) func(l *lua.LState) int {
 		return 0
 	}
 }
+
+/*
+*
+* 设置值并且指定过期时间: rulexlib:VSetTTL(k, v, seconds)
+*
+ */
+func StoreSetWithTTL(rx typex.RuleX) func(l *lua.LState) int {
+	return func(l *lua.LState) int {
+		k := l.ToString(2)
+		v := l.ToString(3)
+		ttl := l.ToNumber(4)
+		core.GlobalStore.SetWithTTL(k, v, time.Duration(float64(ttl)*float64(time.Second)))
+		return 0
+	}
+}
+
+/*
+*
+* 模糊查询 Key: rulexlib:VKeys("AAA*") -> {k1, k2}
+*
+ */
+func StoreKeys(rx typex.RuleX) func(l *lua.LState) int {
+	return func(l *lua.LState) int {
+		pattern := l.ToString(2)
+		table := l.NewTable()
+		for _, k := range core.GlobalStore.FuzzyGet(pattern) {
+			table.Append(lua.LString(k))
+		}
+		l.Push(table)
+		return 1
+	}
+}
+
+/*
+*
+* 原子加: rulexlib:VIncr(k, delta) -> value, err
+*
+ */
+func StoreIncr(rx typex.RuleX) func(l *lua.LState) int {
+	return func(l *lua.LState) int {
+		k := l.ToString(2)
+		delta := l.OptInt64(3, 1)
+		v, err := core.GlobalStore.Incr(k, delta)
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(lua.LNumber(v))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
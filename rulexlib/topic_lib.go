@@ -0,0 +1,29 @@
+package rulexlib
+
+import (
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 发布到内部主题: rulexlib:Publish(topic, data) -> err
+* 订阅了该主题的规则会收到数据
+*
+ */
+func Publish(rx typex.RuleX) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		topic := l.ToString(2)
+		data := l.ToString(3)
+		err := typex.DefaultTopicBus.Publish(rx, typex.CurrentEnvelope(l), topic, data)
+		if err != nil {
+			glogger.GLogger.Error("Publish error:", err)
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
@@ -0,0 +1,253 @@
+package rulexlib
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 流式窗口聚合:
+* 时间滚动窗口: rulexlib:TumblingWindow(key, value, size[, ts]) -> result
+* 时间滑动窗口: rulexlib:SlidingWindow(key, value, size, slide[, ts]) -> result
+* 计数窗口:     rulexlib:CountWindow(key, value, size[, slide]) -> result
+* size/slide 对时间窗口是秒, 对计数窗口是条数; ts 是数据自带的毫秒时间戳, 不传就用当前时间
+* 窗口关闭的时候返回聚合结果, 其他时候返回 nil:
+* {key, start, end, count, sum, avg, min, max, first, last, stddev}
+*
+ */
+
+//
+// 每个规则最多保留多少个窗口, 超过以后丢弃最久没有数据的窗口
+//
+const MAX_WINDOW_KEYS int = 1024
+
+//
+// 每个滑动窗口最多保留多少个样本
+//
+const MAX_WINDOW_SAMPLES int = 10000
+
+// 窗口聚合值, 方差用 Welford 算法增量计算
+type windowAgg struct {
+	count int
+	sum   float64
+	mean  float64
+	m2    float64
+	min   float64
+	max   float64
+	first float64
+	last  float64
+}
+
+func (a *windowAgg) add(v float64) {
+	if a.count == 0 {
+		a.min, a.max, a.first = v, v, v
+	}
+	a.count++
+	a.sum += v
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	a.last = v
+}
+
+func (a *windowAgg) table(l *lua.LState, key string, start int64, end int64) *lua.LTable {
+	t := l.NewTable()
+	t.RawSetString("key", lua.LString(key))
+	t.RawSetString("start", lua.LNumber(start))
+	t.RawSetString("end", lua.LNumber(end))
+	t.RawSetString("count", lua.LNumber(a.count))
+	t.RawSetString("sum", lua.LNumber(a.sum))
+	t.RawSetString("avg", lua.LNumber(a.mean))
+	t.RawSetString("min", lua.LNumber(a.min))
+	t.RawSetString("max", lua.LNumber(a.max))
+	t.RawSetString("first", lua.LNumber(a.first))
+	t.RawSetString("last", lua.LNumber(a.last))
+	stddev := 0.0
+	if a.count > 0 {
+		stddev = math.Sqrt(a.m2 / float64(a.count))
+	}
+	t.RawSetString("stddev", lua.LNumber(stddev))
+	return t
+}
+
+type windowSample struct {
+	ts    int64
+	value float64
+}
+
+type windowState struct {
+	start   int64 // 滚动窗口: 当前窗口开始时间; 滑动窗口: 下一个窗口结束时间
+	agg     windowAgg
+	samples []windowSample
+	pending int // 计数窗口: 上次输出以后新来的数据条数
+	touched time.Time
+}
+
+/*
+*
+* 一个规则的所有窗口状态, 规则的多个虚拟机共享
+*
+ */
+type WindowStore struct {
+	lock   sync.Mutex
+	states map[string]*windowState
+}
+
+func NewWindowStore() *WindowStore {
+	return &WindowStore{states: map[string]*windowState{}}
+}
+
+// 调用的时候必须持有锁
+func (s *WindowStore) state(key string) *windowState {
+	if st, ok := s.states[key]; ok {
+		st.touched = time.Now()
+		return st
+	}
+	if len(s.states) >= MAX_WINDOW_KEYS {
+		var oldest string
+		var oldestTime time.Time
+		for k, st := range s.states {
+			if oldest == "" || st.touched.Before(oldestTime) {
+				oldest, oldestTime = k, st.touched
+			}
+		}
+		delete(s.states, oldest)
+	}
+	st := &windowState{touched: time.Now()}
+	s.states[key] = st
+	return st
+}
+
+// 窗口数量
+func (s *WindowStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.states)
+}
+
+// 第 n 个参数是时间戳, 没有就用当前时间
+func windowTs(l *lua.LState, n int) int64 {
+	if l.GetTop() >= n && l.Get(n) != lua.LNil {
+		return int64(l.CheckNumber(n))
+	}
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func TumblingWindow(rx typex.RuleX, store *WindowStore) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		key := l.ToString(2)
+		value := float64(l.CheckNumber(3))
+		size := int64(l.CheckNumber(4) * 1000)
+		if size <= 0 {
+			l.ArgError(4, "window size must be greater than 0")
+		}
+		ts := windowTs(l, 5)
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		st := store.state("T:" + key + ":" + lua.LNumber(size).String())
+		var result lua.LValue = lua.LNil
+		if st.agg.count > 0 && ts >= st.start+size {
+			result = st.agg.table(l, key, st.start, st.start+size)
+			st.agg = windowAgg{}
+		}
+		if st.agg.count == 0 {
+			st.start = ts / size * size
+		}
+		st.agg.add(value)
+		l.Push(result)
+		return 1
+	}
+}
+
+func SlidingWindow(rx typex.RuleX, store *WindowStore) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		key := l.ToString(2)
+		value := float64(l.CheckNumber(3))
+		size := int64(l.CheckNumber(4) * 1000)
+		slide := int64(l.CheckNumber(5) * 1000)
+		if size <= 0 || slide <= 0 {
+			l.ArgError(4, "window size and slide must be greater than 0")
+		}
+		ts := windowTs(l, 6)
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		st := store.state("S:" + key + ":" + lua.LNumber(size).String() + ":" + lua.LNumber(slide).String())
+		var result lua.LValue = lua.LNil
+		if st.start == 0 {
+			st.start = (ts/slide + 1) * slide
+		}
+		if ts >= st.start {
+			// 只输出最近关闭的一个窗口
+			end := ts / slide * slide
+			agg := windowAgg{}
+			for _, sample := range st.samples {
+				if sample.ts >= end-size && sample.ts < end {
+					agg.add(sample.value)
+				}
+			}
+			if agg.count > 0 {
+				result = agg.table(l, key, end-size, end)
+			}
+			st.start = end + slide
+		}
+		st.samples = append(st.samples, windowSample{ts: ts, value: value})
+		// 下一个窗口用不到的样本丢掉
+		drop := 0
+		for drop < len(st.samples) && st.samples[drop].ts < st.start-size {
+			drop++
+		}
+		if len(st.samples)-drop > MAX_WINDOW_SAMPLES {
+			drop = len(st.samples) - MAX_WINDOW_SAMPLES
+		}
+		// 重新切片就行, append 扩容的时候旧数组会被回收
+		st.samples = st.samples[drop:]
+		l.Push(result)
+		return 1
+	}
+}
+
+func CountWindow(rx typex.RuleX, store *WindowStore) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		key := l.ToString(2)
+		value := float64(l.CheckNumber(3))
+		size := int(l.CheckNumber(4))
+		slide := size
+		if l.GetTop() >= 5 && l.Get(5) != lua.LNil {
+			slide = int(l.CheckNumber(5))
+		}
+		if size <= 0 || size > MAX_WINDOW_SAMPLES || slide <= 0 {
+			l.ArgError(4, "invalid count window size or slide")
+		}
+		ts := time.Now().UnixNano() / int64(time.Millisecond)
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		st := store.state("C:" + key + ":" + lua.LNumber(size).String() + ":" + lua.LNumber(slide).String())
+		st.samples = append(st.samples, windowSample{ts: ts, value: value})
+		if len(st.samples) > size {
+			st.samples = st.samples[len(st.samples)-size:]
+		}
+		st.pending++
+		var result lua.LValue = lua.LNil
+		if len(st.samples) == size && st.pending >= slide {
+			agg := windowAgg{}
+			for _, sample := range st.samples {
+				agg.add(sample.value)
+			}
+			result = agg.table(l, key, st.samples[0].ts, st.samples[size-1].ts)
+			st.pending = 0
+			if slide >= size {
+				st.samples = nil
+			}
+		}
+		l.Push(result)
+		return 1
+	}
+}
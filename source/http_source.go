@@ -19,6 +19,18 @@ type httpConfig struct {
 	DataModels []typex.XDataModel `json:"dataModels" title:"数据模型" info:""`
 }
 
+//
+// 认证相关的请求头不放进信封, 信封会出现在追踪, 调试和死信里面
+//
+var httpCredentialHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+	"X-Csrf-Token":        true,
+}
+
 //
 type httpInEndSource struct {
 	typex.XStatus
@@ -59,7 +71,17 @@ func (hh *httpInEndSource) Start(cctx typex.CCTX) error {
 				"message": err.Error(),
 			})
 		} else {
-			hh.RuleEngine.WorkInEnd(hh.RuleEngine.GetInEnd(hh.PointId), inForm.Data)
+			envelope := typex.NewEnvelope([]byte(inForm.Data))
+			envelope.Topic = c.Request.URL.Path
+			envelope.Peer = c.ClientIP()
+			envelope.ContentType = c.ContentType()
+			for k := range c.Request.Header {
+				if httpCredentialHeaders[http.CanonicalHeaderKey(k)] {
+					continue
+				}
+				envelope.SetHeader(k, c.Request.Header.Get(k))
+			}
+			hh.RuleEngine.WorkInEndEnvelope(hh.RuleEngine.GetInEnd(hh.PointId), envelope)
 			c.JSON(200, gin.H{
 				"message": "ok",
 				"data":    inForm,
@@ -9,6 +9,7 @@ import (
 
 	"time"
 
+	"github.com/i4de/rulex/common"
 	"github.com/i4de/rulex/core"
 
 	"github.com/i4de/rulex/glogger"
@@ -23,12 +24,13 @@ import (
 var _sourceState typex.SourceState = typex.SOURCE_UP
 
 type modBusConfig struct {
-	Mode           string          `json:"mode" title:"工作模式" info:"RTU/TCP"`
-	Timeout        int             `json:"timeout" validate:"required" title:"连接超时" info:""`
-	SlaverId       byte            `json:"slaverId" validate:"required" title:"TCP端口" info:""`
-	Frequency      int64           `json:"frequency" validate:"required" title:"采集频率" info:""`
-	Config         interface{}     `json:"config" validate:"required" title:"工作模式" info:""`
-	RegisterParams []registerParam `json:"registerParams" validate:"required" title:"寄存器配置" info:""`
+	Mode           string            `json:"mode" title:"工作模式" info:"RTU/TCP"`
+	Timeout        int               `json:"timeout" validate:"required" title:"连接超时" info:""`
+	SlaverId       byte              `json:"slaverId" validate:"required" title:"TCP端口" info:""`
+	Frequency      int64             `json:"frequency" validate:"required" title:"采集频率" info:""`
+	Config         interface{}       `json:"config" validate:"required" title:"工作模式" info:""`
+	RegisterParams []registerParam   `json:"registerParams" validate:"required" title:"寄存器配置" info:""`
+	Points         common.PointTable `json:"points" title:"点位表" info:"配置以后输出解析好的工程值"`
 }
 
 const (
@@ -183,6 +185,13 @@ func (m *modbusMasterSource) Start(cctx typex.CCTX) error {
 	if err := utils.BindSourceConfig(config, &mainConfig); err != nil {
 		return err
 	}
+	areas := map[string]int{}
+	for _, rp := range mainConfig.RegisterParams {
+		areas[rp.Tag] = common.ModbusAreaSize(rp.Function, rp.Quantity)
+	}
+	if err := mainConfig.Points.Validate(areas); err != nil {
+		return err
+	}
 
 	if mainConfig.Mode == "TCP" {
 		var tcpConfig tcpConfig
@@ -272,6 +281,19 @@ func (m *modbusMasterSource) Start(cctx typex.CCTX) error {
 							glogger.GLogger.Error("NewModbusMasterSource ReadData error: ", err)
 							_sourceState = typex.SOURCE_DOWN
 
+						} else if len(mainConfig.Points) > 0 {
+							// 只输出这个寄存器上的点位
+							// 解析失败的点位跳过, 其他点位照常输出
+							values, err := mainConfig.Points.Decode(map[string][]byte{rp.Tag: results})
+							if err != nil {
+								glogger.GLogger.Error("NewModbusMasterSource decode error: ", err)
+							}
+							bytes, _ := json.Marshal(values)
+							envelope := typex.NewEnvelope(bytes)
+							envelope.ContentType = "application/json"
+							envelope.SetHeader("tag", rp.Tag)
+							envelope.SetHeader("slaverId", fmt.Sprintf("%v", mainConfig.SlaverId))
+							m.RuleEngine.WorkInEndEnvelope(m.RuleEngine.GetInEnd(m.PointId), envelope)
 						} else {
 							data := registerData{
 								Tag:      rp.Tag,
@@ -283,7 +305,11 @@ func (m *modbusMasterSource) Start(cctx typex.CCTX) error {
 								Value: hex.EncodeToString(results),
 							}
 							bytes, _ := json.Marshal(data)
-							m.RuleEngine.WorkInEnd(m.RuleEngine.GetInEnd(m.PointId), string(bytes))
+							envelope := typex.NewEnvelope(bytes)
+							envelope.ContentType = "application/json"
+							envelope.SetHeader("tag", rp.Tag)
+							envelope.SetHeader("slaverId", fmt.Sprintf("%v", mainConfig.SlaverId))
+							m.RuleEngine.WorkInEndEnvelope(m.RuleEngine.GetInEnd(m.PointId), envelope)
 						}
 					}
 				}
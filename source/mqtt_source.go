@@ -47,7 +47,9 @@ func (mm *mqttInEndSource) Start(cctx typex.CCTX) error {
 	}
 
 	var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-		work, err := mm.RuleEngine.WorkInEnd(mm.RuleEngine.GetInEnd(mm.PointId), string(msg.Payload()))
+		envelope := typex.NewEnvelope(msg.Payload())
+		envelope.Topic = msg.Topic()
+		work, err := mm.RuleEngine.WorkInEndEnvelope(mm.RuleEngine.GetInEnd(mm.PointId), envelope)
 		if !work {
 			glogger.GLogger.Error(err)
 		}
@@ -60,7 +60,9 @@ func (nt *natsSource) Start(cctx typex.CCTX) error {
 		//
 		_, err := nt.natsConnector.Subscribe(nt.topic, func(msg *nats.Msg) {
 			if nt.natsConnector != nil {
-				work, err1 := nt.RuleEngine.WorkInEnd(nt.RuleEngine.GetInEnd(nt.PointId), string(msg.Data))
+				envelope := typex.NewEnvelope(msg.Data)
+				envelope.Topic = msg.Subject
+				work, err1 := nt.RuleEngine.WorkInEndEnvelope(nt.RuleEngine.GetInEnd(nt.PointId), envelope)
 				if !work {
 					glogger.GLogger.Error(err1)
 				}
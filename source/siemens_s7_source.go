@@ -3,8 +3,10 @@ package source
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/i4de/rulex/common"
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/typex"
@@ -26,14 +28,15 @@ type dbValue struct {
 	Value string `json:"value"`
 }
 type siemensS7config struct {
-	Host        string `json:"host" validate:"required" title:"IP地址" info:""`          // 127.0.0.1
-	Rack        *int   `json:"rack" validate:"required" title:"架号" info:""`            // 0
-	Slot        *int   `json:"slot" validate:"required" title:"槽号" info:""`            // 1
-	Model       string `json:"model" validate:"required" title:"型号" info:""`           // s7-200 s7 1500
-	Timeout     *int   `json:"timeout" validate:"required" title:"连接超时时间" info:""`     // 5s
-	IdleTimeout *int   `json:"idleTimeout" validate:"required" title:"心跳超时时间" info:""` // 5s
-	Frequency   *int   `json:"frequency" validate:"required" title:"采集频率" info:""`     // 5s
-	Dbs         []db   `json:"dbs" validate:"required" title:"采集配置" info:""`           // Db
+	Host        string            `json:"host" validate:"required" title:"IP地址" info:""`          // 127.0.0.1
+	Rack        *int              `json:"rack" validate:"required" title:"架号" info:""`            // 0
+	Slot        *int              `json:"slot" validate:"required" title:"槽号" info:""`            // 1
+	Model       string            `json:"model" validate:"required" title:"型号" info:""`           // s7-200 s7 1500
+	Timeout     *int              `json:"timeout" validate:"required" title:"连接超时时间" info:""`     // 5s
+	IdleTimeout *int              `json:"idleTimeout" validate:"required" title:"心跳超时时间" info:""` // 5s
+	Frequency   *int              `json:"frequency" validate:"required" title:"采集频率" info:""`     // 5s
+	Dbs         []db              `json:"dbs" validate:"required" title:"采集配置" info:""`           // Db
+	Points      common.PointTable `json:"points" title:"点位表" info:"配置以后输出解析好的工程值"`
 }
 type siemensS7Source struct {
 	typex.XStatus
@@ -82,6 +85,13 @@ func (s7 *siemensS7Source) Start(cctx typex.CCTX) error {
 	if err := utils.BindSourceConfig(config, &mainConfig); err != nil {
 		return err
 	}
+	areas := map[string]int{}
+	for _, d := range mainConfig.Dbs {
+		areas[d.Tag] = d.Size
+	}
+	if err := mainConfig.Points.Validate(areas); err != nil {
+		return err
+	}
 	handler := gos7.NewTCPClientHandler(mainConfig.Host, *mainConfig.Rack, *mainConfig.Slot)
 	handler.Timeout = 5 * time.Second
 	if err := handler.Connect(); err != nil {
@@ -118,13 +128,28 @@ func (s7 *siemensS7Source) Start(cctx typex.CCTX) error {
 					glogger.GLogger.Error(err)
 				} else {
 					// glogger.GLogger.Info("client.AGReadDB dataBuffer:", dataBuffer)
-					dbv := dbValue{Value: string(dataBuffer[:d.Size])}
-					dbv.Tag = d.Tag
-					dbv.Address = d.Address
-					dbv.Start = d.Start
-					dbv.Size = d.Size
-					bytes, _ := json.Marshal(dbv)
-					work, err := s7.RuleEngine.WorkInEnd(s7.RuleEngine.GetInEnd(s7.PointId), string(bytes))
+					var bytes []byte
+					if len(mainConfig.Points) > 0 {
+						// 只输出这个DB块上的点位
+						// 解析失败的点位跳过, 其他点位照常输出
+						values, err := mainConfig.Points.Decode(map[string][]byte{d.Tag: dataBuffer[:d.Size]})
+						if err != nil {
+							glogger.GLogger.Error(err)
+						}
+						bytes, _ = json.Marshal(values)
+					} else {
+						dbv := dbValue{Value: string(dataBuffer[:d.Size])}
+						dbv.Tag = d.Tag
+						dbv.Address = d.Address
+						dbv.Start = d.Start
+						dbv.Size = d.Size
+						bytes, _ = json.Marshal(dbv)
+					}
+					envelope := typex.NewEnvelope(bytes)
+					envelope.ContentType = "application/json"
+					envelope.SetHeader("tag", d.Tag)
+					envelope.SetHeader("dbAddress", fmt.Sprintf("%v", d.Address))
+					work, err := s7.RuleEngine.WorkInEndEnvelope(s7.RuleEngine.GetInEnd(s7.PointId), envelope)
 					if !work {
 						glogger.GLogger.Error(err)
 					}
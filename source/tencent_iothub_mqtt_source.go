@@ -75,7 +75,9 @@ func (tc *tencentIothubSource) Start(cctx typex.CCTX) error {
 	ActionTopic := fmt.Sprintf(_ActionTopic, mainConfig.ProductId, mainConfig.DeviceName)
 
 	var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-		work, err := tc.RuleEngine.WorkInEnd(tc.RuleEngine.GetInEnd(tc.PointId), string(msg.Payload()))
+		envelope := typex.NewEnvelope(msg.Payload())
+		envelope.Topic = msg.Topic()
+		work, err := tc.RuleEngine.WorkInEndEnvelope(tc.RuleEngine.GetInEnd(tc.PointId), envelope)
 		if !work {
 			glogger.GLogger.Error(err)
 		}
@@ -1,8 +1,11 @@
 package source
 
 import (
+	"fmt"
+
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/typex"
+	"github.com/i4de/rulex/utils"
 )
 
 var SM typex.SourceRegistry = core.NewSourceTypeManager()
@@ -25,3 +28,31 @@ func LoadSt() {
 	SM.Register(typex.UART_MODULE, core.GenInConfig(typex.UART_MODULE, "About UART_MODULE", uartConfig{}))
 	SM.Register(typex.RULEX_UDP, core.GenInConfig(typex.RULEX_UDP, "About RULEX_UDP", udpConfig{}))
 }
+
+//
+// 每种资源的配置结构体, 和各自 Init 里面用的保持一致
+//
+var sourceConfigs = map[typex.InEndType]func() interface{}{
+	typex.MQTT:            func() interface{} { return &mqttConfig{} },
+	typex.HTTP:            func() interface{} { return &httpConfig{} },
+	typex.COAP:            func() interface{} { return &coAPConfig{} },
+	typex.GRPC:            func() interface{} { return &grpcConfig{} },
+	typex.UART_MODULE:     func() interface{} { return &uartConfig{} },
+	typex.MODBUS_MASTER:   func() interface{} { return &modBusConfig{} },
+	typex.SNMP_SERVER:     func() interface{} { return &snmpConfig{} },
+	typex.NATS_SERVER:     func() interface{} { return &natsConfig{} },
+	typex.SIEMENS_S7:      func() interface{} { return &siemensS7config{} },
+	typex.RULEX_UDP:       func() interface{} { return &udpConfig{} },
+	typex.TENCENT_IOT_HUB: func() interface{} { return &tencentMqttConfig{} },
+}
+
+//
+// 加载之前先用资源的配置结构体校验一下配置
+//
+func ValidateConfig(Type typex.InEndType, config map[string]interface{}) error {
+	newConfig, ok := sourceConfigs[Type]
+	if !ok {
+		return fmt.Errorf("unsupported InEnd type:%s", Type)
+	}
+	return utils.BindSourceConfig(config, newConfig())
+}
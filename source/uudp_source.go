@@ -49,7 +49,9 @@ func (u *udpSource) Start(cctx typex.CCTX) error {
 				glogger.GLogger.Error(err.Error())
 			} else {
 				// glogger.GLogger.Infof("Receive udp data:<%s> %s\n", remoteAddr, data[:n])
-				work, err := u.RuleEngine.WorkInEnd(u.RuleEngine.GetInEnd(u.PointId), string(data[:n]))
+				envelope := typex.NewEnvelope(append([]byte{}, data[:n]...))
+				envelope.Peer = remoteAddr.String()
+				work, err := u.RuleEngine.WorkInEndEnvelope(u.RuleEngine.GetInEnd(u.PointId), envelope)
 				if !work {
 					glogger.GLogger.Error(err)
 				}
@@ -0,0 +1,249 @@
+package sqlrule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+*
+* SQL 规则: 解析以后编译成普通的 Lua Actions, 和 Lua 规则走同一套执行流程
+* SELECT payload.temp AS t, deviceId FROM "INEND1" WHERE payload.temp > 30
+* - payload 是解析后的 JSON 数据, 不带前缀的字段也是从 payload 里面取
+* - meta 是消息的元数据, 比如 meta.topic
+* - 数组下标从 0 开始: payload.values[0]
+* - 字段不存在的时候是 NULL, 和 NULL 比较的结果都是 false
+*
+ */
+
+//
+// Lua 辅助函数, 所有的运算都对 nil 安全
+//
+const luaHelpers = `local __sql = {}
+function __sql.get(v, ...)
+    for _, k in ipairs({...}) do
+        if type(v) ~= "table" then return nil end
+        v = v[k]
+    end
+    return v
+end
+function __sql.truthy(v)
+    return v ~= nil and v ~= false
+end
+function __sql.cmp(op, a, b)
+    if a == nil or b == nil or type(a) ~= type(b) then
+        if op == "!=" then return a ~= nil and b ~= nil end
+        return false
+    end
+    if op == "=" then return a == b end
+    if op == "!=" then return a ~= b end
+    if type(a) ~= "number" and type(a) ~= "string" then return false end
+    if op == "<" then return a < b end
+    if op == "<=" then return a <= b end
+    if op == ">" then return a > b end
+    return a >= b
+end
+function __sql.arith(op, a, b)
+    if type(a) ~= "number" or type(b) ~= "number" then return nil end
+    if op == "+" then return a + b end
+    if op == "-" then return a - b end
+    if op == "*" then return a * b end
+    if b == 0 then return nil end
+    if op == "/" then return a / b end
+    return a % b
+end
+function __sql.isin(v, ...)
+    if v == nil then return false end
+    local n = select("#", ...)
+    for i = 1, n do
+        if v == select(i, ...) then return true end
+    end
+    return false
+end
+function __sql.num(f)
+    return function(v, ...)
+        if type(v) ~= "number" then return nil end
+        return f(v, ...)
+    end
+end
+__sql.abs = __sql.num(math.abs)
+__sql.floor = __sql.num(math.floor)
+__sql.ceil = __sql.num(math.ceil)
+__sql.round = __sql.num(function(v, n)
+    local m = 10 ^ (n or 0)
+    return math.floor(v * m + 0.5) / m
+end)
+function __sql.lower(v)
+    if type(v) ~= "string" then return nil end
+    return string.lower(v)
+end
+function __sql.upper(v)
+    if type(v) ~= "string" then return nil end
+    return string.upper(v)
+end
+function __sql.len(v)
+    if type(v) ~= "string" and type(v) ~= "table" then return nil end
+    return #v
+end
+function __sql.concat(...)
+    local s = ""
+    local n = select("#", ...)
+    for i = 1, n do
+        local v = select(i, ...)
+        if v ~= nil then s = s .. tostring(v) end
+    end
+    return s
+end
+function __sql.now()
+    return os.time()
+end
+`
+
+/*
+*
+* 编译结果
+*
+ */
+type Program struct {
+	*Statement
+	Actions string `json:"actions"`
+}
+
+/*
+*
+* 编译 SQL, 输出的数据转发到 outEnds
+*
+ */
+func Compile(sql string, outEnds []string) (*Program, error) {
+	if len(outEnds) == 0 {
+		return nil, errors.New("sql rule must have at least one outEnd")
+	}
+	stmt, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	b := &strings.Builder{}
+	b.WriteString(luaHelpers)
+	b.WriteString("Actions = {\n")
+	b.WriteString("    function(data, meta)\n")
+	b.WriteString("        local payload = rulexlib:J2T(data)\n")
+	b.WriteString("        if payload == nil then payload = data end\n")
+	b.WriteString("        meta = meta or {}\n")
+	if stmt.where != nil {
+		fmt.Fprintf(b, "        if not __sql.truthy(%v) then return true, data end\n", compileExpr(stmt.where))
+	}
+	if len(stmt.Fields) == 0 {
+		b.WriteString("        local result = data\n")
+	} else {
+		b.WriteString("        local out = {}\n")
+		for _, field := range stmt.Fields {
+			fmt.Fprintf(b, "        out[%v] = %v\n", luaQuote(field.Name), compileExpr(field.expr))
+		}
+		b.WriteString("        local result, err = rulexlib:T2J(out)\n")
+		b.WriteString("        if result == nil then return false, err end\n")
+	}
+	for _, outEnd := range outEnds {
+		fmt.Fprintf(b, "        rulexlib:DataToOutEnd(%v, result)\n", luaQuote(outEnd))
+	}
+	b.WriteString("        return true, result\n")
+	b.WriteString("    end\n")
+	b.WriteString("}\n")
+	return &Program{Statement: stmt, Actions: b.String()}, nil
+}
+
+func compileExpr(e expr) string {
+	switch e := e.(type) {
+	case *numberExpr:
+		return strconv.FormatFloat(e.value, 'g', -1, 64)
+	case *stringExpr:
+		return luaQuote(e.value)
+	case *boolExpr:
+		return strconv.FormatBool(e.value)
+	case *nullExpr:
+		return "nil"
+	case *pathExpr:
+		root := "payload"
+		keys := e.keys
+		switch e.root {
+		case "payload", "meta":
+			root = e.root
+		default:
+			keys = append([]interface{}{e.root}, keys...)
+		}
+		if len(keys) == 0 {
+			return root
+		}
+		args := []string{root}
+		for _, k := range keys {
+			switch k := k.(type) {
+			case int:
+				args = append(args, strconv.Itoa(k+1))
+			case string:
+				args = append(args, luaQuote(k))
+			}
+		}
+		return "__sql.get(" + strings.Join(args, ", ") + ")"
+	case *unaryExpr:
+		if e.op == "NOT" {
+			return "(not __sql.truthy(" + compileExpr(e.operand) + "))"
+		}
+		return "__sql.arith(\"-\", 0, " + compileExpr(e.operand) + ")"
+	case *binaryExpr:
+		left, right := compileExpr(e.left), compileExpr(e.right)
+		switch e.op {
+		case "AND":
+			return "(__sql.truthy(" + left + ") and __sql.truthy(" + right + "))"
+		case "OR":
+			return "(__sql.truthy(" + left + ") or __sql.truthy(" + right + "))"
+		case "+", "-", "*", "/", "%":
+			return "__sql.arith(" + luaQuote(e.op) + ", " + left + ", " + right + ")"
+		}
+		return "__sql.cmp(" + luaQuote(e.op) + ", " + left + ", " + right + ")"
+	case *isNullExpr:
+		if e.not {
+			return "(" + compileExpr(e.operand) + " ~= nil)"
+		}
+		return "(" + compileExpr(e.operand) + " == nil)"
+	case *inExpr:
+		args := []string{compileExpr(e.operand)}
+		for _, item := range e.list {
+			args = append(args, compileExpr(item))
+		}
+		s := "__sql.isin(" + strings.Join(args, ", ") + ")"
+		if e.not {
+			return "(not " + s + ")"
+		}
+		return s
+	case *callExpr:
+		args := []string{}
+		for _, arg := range e.args {
+			args = append(args, compileExpr(arg))
+		}
+		return "__sql." + e.name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "nil"
+}
+
+//
+// Lua 字符串字面量, 控制字符用十进制转义
+//
+func luaQuote(s string) string {
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package sqlrule
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tEOF tokenType = iota
+	tIdent
+	tNumber
+	tString
+	tQuoted // "inend-uuid"
+	tOp
+	tComma
+	tDot
+	tLParen
+	tRParen
+	tLBracket
+	tRBracket
+	tStar
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	if t.typ == tEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("'%v' at %v", t.val, t.pos)
+}
+
+// 关键字不区分大小写
+func (t token) is(keyword string) bool {
+	return t.typ == tIdent && strings.EqualFold(t.val, keyword)
+}
+
+/*
+*
+* 词法分析
+*
+ */
+func lex(input string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(input)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tIdent, string(runes[start:i]), start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tNumber, string(runes[start:i]), start})
+		case c == '\'' || c == '"':
+			start := i
+			quote := c
+			i++
+			s := []rune{}
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at %v", start)
+				}
+				if runes[i] == quote {
+					// 两个引号表示引号本身
+					if i+1 < len(runes) && runes[i+1] == quote {
+						s = append(s, quote)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				s = append(s, runes[i])
+				i++
+			}
+			typ := tString
+			if quote == '"' {
+				typ = tQuoted
+			}
+			tokens = append(tokens, token{typ, string(s), start})
+		case c == ',':
+			tokens = append(tokens, token{tComma, ",", i})
+			i++
+		case c == '.':
+			tokens = append(tokens, token{tDot, ".", i})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tRParen, ")", i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tRBracket, "]", i})
+			i++
+		case c == '*':
+			tokens = append(tokens, token{tStar, "*", i})
+			i++
+		case strings.ContainsRune("=<>!+-/%", c):
+			start := i
+			i++
+			if i < len(runes) {
+				two := string(runes[start : i+1])
+				if two == "<=" || two == ">=" || two == "!=" || two == "<>" {
+					i++
+				}
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at %v", start)
+			}
+			tokens = append(tokens, token{tOp, op, start})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at %v", c, i)
+		}
+	}
+	tokens = append(tokens, token{tEOF, "", len(runes)})
+	return tokens, nil
+}
@@ -0,0 +1,482 @@
+package sqlrule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+*
+* 语法树节点
+*
+ */
+type expr interface{}
+
+type numberExpr struct{ value float64 }
+type stringExpr struct{ value string }
+type boolExpr struct{ value bool }
+type nullExpr struct{}
+
+// payload.a.b[1] / meta.topic / deviceId
+type pathExpr struct {
+	root string
+	keys []interface{} // string 或者 int
+}
+
+type unaryExpr struct {
+	op      string // NOT -
+	operand expr
+}
+
+type binaryExpr struct {
+	op    string // AND OR = != < <= > >= + - * / %
+	left  expr
+	right expr
+}
+
+// a IS [NOT] NULL
+type isNullExpr struct {
+	operand expr
+	not     bool
+}
+
+// a [NOT] IN (1, 2, 3)
+type inExpr struct {
+	operand expr
+	list    []expr
+	not     bool
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+/*
+*
+* 查询的一列: SELECT payload.temp AS t
+*
+ */
+type Field struct {
+	Name string `json:"name"`
+	expr expr
+}
+
+/*
+*
+* 解析后的查询语句
+*
+ */
+type Statement struct {
+	Fields []Field  `json:"fields"` // 为空表示 SELECT *
+	From   []string `json:"from"`
+	where  expr
+}
+
+// 支持的函数和参数个数, -1 表示不限
+var functions = map[string]int{
+	"abs":    1,
+	"floor":  1,
+	"ceil":   1,
+	"round":  -1,
+	"lower":  1,
+	"upper":  1,
+	"len":    1,
+	"concat": -1,
+	"now":    0,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(keyword string) bool {
+	if p.peek().is(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.typ != tOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.val == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, fmt.Errorf("expected %v but got %v", what, t)
+	}
+	return t, nil
+}
+
+var reserved = []string{"SELECT", "FROM", "WHERE", "AS", "AND", "OR", "NOT", "IS", "IN", "NULL", "TRUE", "FALSE"}
+
+func isReserved(s string) bool {
+	for _, k := range reserved {
+		if strings.EqualFold(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+* 解析 SQL: SELECT fields FROM "uuid"[, "uuid"] [WHERE condition]
+*
+ */
+func Parse(sql string) (*Statement, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if !p.accept("SELECT") {
+		return nil, errors.New("sql must start with SELECT")
+	}
+	stmt := &Statement{}
+	if p.peek().typ == tStar {
+		p.next()
+	} else {
+		names := map[string]bool{}
+		for {
+			field, err := p.parseField(len(stmt.Fields))
+			if err != nil {
+				return nil, err
+			}
+			if names[field.Name] {
+				return nil, fmt.Errorf("duplicate field name: %v", field.Name)
+			}
+			names[field.Name] = true
+			stmt.Fields = append(stmt.Fields, field)
+			if p.peek().typ != tComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if !p.accept("FROM") {
+		return nil, fmt.Errorf("expected FROM but got %v", p.peek())
+	}
+	for {
+		t := p.next()
+		if t.typ != tQuoted && t.typ != tString && (t.typ != tIdent || isReserved(t.val)) {
+			return nil, fmt.Errorf("expected source uuid but got %v", t)
+		}
+		stmt.From = append(stmt.From, t.val)
+		if p.peek().typ != tComma {
+			break
+		}
+		p.next()
+	}
+	if p.accept("WHERE") {
+		if stmt.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.typ != tEOF {
+		return nil, fmt.Errorf("unexpected %v", t)
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseField(index int) (Field, error) {
+	e, err := p.parseOr()
+	if err != nil {
+		return Field{}, err
+	}
+	field := Field{expr: e}
+	if p.accept("AS") {
+		t := p.next()
+		if (t.typ != tIdent || isReserved(t.val)) && t.typ != tQuoted {
+			return field, fmt.Errorf("expected alias but got %v", t)
+		}
+		field.Name = t.val
+	} else if path, ok := e.(*pathExpr); ok {
+		// 没有别名就用最后一级字段名
+		field.Name = path.root
+		for i := len(path.keys) - 1; i >= 0; i-- {
+			if key, ok := path.keys[i].(string); ok {
+				field.Name = key
+				break
+			}
+		}
+	} else {
+		field.Name = "expr" + strconv.Itoa(index+1)
+	}
+	return field, nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{"OR", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{"AND", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{"NOT", operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (expr, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.acceptOp("=", "!=", "<>", "<", "<=", ">", ">="); ok {
+		if op == "<>" {
+			op = "!="
+		}
+		right, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryExpr{op, left, right}, nil
+	}
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if !p.accept("NULL") {
+			return nil, fmt.Errorf("expected NULL but got %v", p.peek())
+		}
+		return &isNullExpr{left, not}, nil
+	}
+	not := false
+	if p.peek().is("NOT") && p.tokens[p.pos+1].is("IN") {
+		p.next()
+		not = true
+	}
+	if p.accept("IN") {
+		if _, err := p.expect(tLParen, "'('"); err != nil {
+			return nil, err
+		}
+		in := &inExpr{operand: left, not: not}
+		for {
+			item, err := p.parseAdd()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if p.peek().typ != tComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdd() (expr, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op, left, right}
+	}
+}
+
+func (p *parser) parseMul() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		if p.peek().typ == tStar {
+			p.next()
+			op = "*"
+		} else if o, ok := p.acceptOp("/", "%"); ok {
+			op = o
+		} else {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op, left, right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if _, ok := p.acceptOp("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{"-", operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.typ {
+	case tNumber:
+		v, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", t)
+		}
+		return &numberExpr{v}, nil
+	case tString:
+		return &stringExpr{t.val}, nil
+	case tLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tIdent:
+		switch {
+		case t.is("TRUE"):
+			return &boolExpr{true}, nil
+		case t.is("FALSE"):
+			return &boolExpr{false}, nil
+		case t.is("NULL"):
+			return &nullExpr{}, nil
+		case isReserved(t.val):
+			return nil, fmt.Errorf("unexpected %v", t)
+		}
+		if p.peek().typ == tLParen {
+			return p.parseCall(t)
+		}
+		return p.parsePath(t)
+	}
+	return nil, fmt.Errorf("unexpected %v", t)
+}
+
+func (p *parser) parseCall(name token) (expr, error) {
+	p.next()
+	fn := strings.ToLower(name.val)
+	argc, ok := functions[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %v", name)
+	}
+	call := &callExpr{name: fn}
+	if p.peek().typ != tRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek().typ != tComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(tRParen, "')'"); err != nil {
+		return nil, err
+	}
+	if argc >= 0 && len(call.args) != argc {
+		return nil, fmt.Errorf("function %v expects %v arguments", fn, argc)
+	}
+	return call, nil
+}
+
+func (p *parser) parsePath(root token) (expr, error) {
+	path := &pathExpr{root: root.val}
+	for {
+		switch p.peek().typ {
+		case tDot:
+			p.next()
+			t := p.next()
+			if t.typ != tIdent && t.typ != tQuoted {
+				return nil, fmt.Errorf("expected field name but got %v", t)
+			}
+			path.keys = append(path.keys, t.val)
+		case tLBracket:
+			p.next()
+			t := p.next()
+			switch t.typ {
+			case tNumber:
+				i, err := strconv.Atoi(t.val)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %v", t)
+				}
+				path.keys = append(path.keys, i)
+			case tString, tQuoted:
+				path.keys = append(path.keys, t.val)
+			default:
+				return nil, fmt.Errorf("expected index but got %v", t)
+			}
+			if _, err := p.expect(tRBracket, "']'"); err != nil {
+				return nil, err
+			}
+		default:
+			return path, nil
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package statistics
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// 指标的资源类型
+//
+const (
+	INEND  string = "inends"
+	DEVICE string = "devices"
+	RULE   string = "rules"
+	OUTEND string = "outends"
+)
+
+//
+// 延迟直方图的桶, 单位毫秒, 最后还有一个 +Inf 桶
+//
+var LatencyBuckets = []float64{0.1, 0.5, 1, 5, 10, 50, 100, 500, 1000, 5000}
+
+/*
+*
+* 延迟直方图: 规则是 Lua 执行时间, OutEnd 是投递时间
+*
+ */
+type Histogram struct {
+	Buckets []float64 `json:"buckets"` // 每个桶的上限, 单位毫秒
+	Counts  []uint64  `json:"counts"`  // 比 Buckets 多一个, 最后一个是 +Inf
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Max     float64   `json:"max"`
+}
+
+func (h *Histogram) observe(ms float64) {
+	if h.Counts == nil {
+		h.Buckets = LatencyBuckets
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(h.Buckets) && ms > h.Buckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += ms
+	if ms > h.Max {
+		h.Max = ms
+	}
+}
+
+/*
+*
+* 单个资源的指标
+*
+ */
+type Metrics struct {
+	Messages    uint64     `json:"messages"`
+	Errors      uint64     `json:"errors"`
+	Bytes       uint64     `json:"bytes"`
+	LastMessage time.Time  `json:"lastMessage"`
+	Latency     *Histogram `json:"latency,omitempty"`
+}
+
+type resourceMetrics struct {
+	lock    sync.Mutex
+	metrics Metrics
+}
+
+var metricsLock sync.RWMutex
+var allMetrics = map[string]map[string]*resourceMetrics{
+	INEND:  {},
+	DEVICE: {},
+	RULE:   {},
+	OUTEND: {},
+}
+
+func getMetrics(kind string, uuid string) *resourceMetrics {
+	metricsLock.RLock()
+	m, ok := allMetrics[kind][uuid]
+	metricsLock.RUnlock()
+	if ok {
+		return m
+	}
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if m, ok = allMetrics[kind][uuid]; !ok {
+		if allMetrics[kind] == nil {
+			allMetrics[kind] = map[string]*resourceMetrics{}
+		}
+		m = &resourceMetrics{}
+		allMetrics[kind][uuid] = m
+	}
+	return m
+}
+
+//
+// 记录一条消息
+//
+func Record(kind string, uuid string, bytes int, err error) {
+	m := getMetrics(kind, uuid)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.record(bytes, err)
+}
+
+//
+// 记录一条消息和它的处理耗时
+//
+func Observe(kind string, uuid string, bytes int, cost time.Duration, err error) {
+	m := getMetrics(kind, uuid)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.record(bytes, err)
+	if m.metrics.Latency == nil {
+		m.metrics.Latency = &Histogram{}
+	}
+	m.metrics.Latency.observe(float64(cost) / float64(time.Millisecond))
+}
+
+func (m *resourceMetrics) record(bytes int, err error) {
+	m.metrics.Messages++
+	if err != nil {
+		m.metrics.Errors++
+	}
+	m.metrics.Bytes += uint64(bytes)
+	m.metrics.LastMessage = time.Now()
+}
+
+//
+// 资源删除以后把指标也删掉
+//
+func RemoveMetrics(kind string, uuid string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	delete(allMetrics[kind], uuid)
+}
+
+//
+// 某个资源的指标快照, 没有记录过返回 nil
+//
+func GetMetrics(kind string, uuid string) *Metrics {
+	metricsLock.RLock()
+	m, ok := allMetrics[kind][uuid]
+	metricsLock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return m.snapshot()
+}
+
+func (m *resourceMetrics) snapshot() *Metrics {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := m.metrics
+	if m.metrics.Latency != nil {
+		h := *m.metrics.Latency
+		h.Counts = append([]uint64{}, h.Counts...)
+		s.Latency = &h
+	}
+	return &s
+}
+
+//
+// 所有资源的指标快照: 类型 -> UUID -> 指标
+//
+func AllMetrics() map[string]map[string]*Metrics {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	result := map[string]map[string]*Metrics{}
+	for kind, resources := range allMetrics {
+		result[kind] = map[string]*Metrics{}
+		for uuid, m := range resources {
+			result[kind][uuid] = m.snapshot()
+		}
+	}
+	return result
+}
+
+//
+// 清空所有资源的指标
+//
+func ResetMetrics() {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	for kind := range allMetrics {
+		allMetrics[kind] = map[string]*resourceMetrics{}
+	}
+}
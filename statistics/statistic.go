@@ -17,7 +17,8 @@ func init() {
 	statisticsCache = statistics{}
 }
 func IncIn() {
-
+	lock.Lock()
+	defer lock.Unlock()
 	statisticsCache.InSuccess = statisticsCache.InSuccess + 1
 }
 func DecIn() {
@@ -53,11 +54,15 @@ func IncOutFailed() {
 }
 
 func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
 	statisticsCache.InSuccess = 0
 	statisticsCache.InFailed = 0
 	statisticsCache.OutFailed = 0
 	statisticsCache.OutSuccess = 0
 }
 func AllStatistics() statistics {
+	lock.Lock()
+	defer lock.Unlock()
 	return statisticsCache
 }
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+)
+
+// 持久化方式
+const (
+	SYNC_SNAPSHOT      = "snapshot"      // 定时把整个缓存写成快照
+	SYNC_WRITE_THROUGH = "write_through" // 每次修改都追加到日志并且刷盘
+)
+
+//
+// 持久化缓存配置
+//
+type PersistentConfig struct {
+	Path             string        // 快照文件路径, 日志文件是 Path + ".log"
+	SyncMode         string        // snapshot 或者 write_through
+	SnapshotInterval time.Duration // 快照间隔
+	MaxDiskSize      int64         // 磁盘上最多占多少字节, 小于等于0不限制
+}
+
+//
+// 快照和日志里面的一条记录, 快照里面只有 set
+//
+type record struct {
+	Op       string    `json:"op"`
+	Key      string    `json:"key"`
+	Value    string    `json:"value,omitempty"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+/*
+*
+* 持久化缓存: 内存里面还是 RulexStore, 修改同步到磁盘, 重启以后恢复;
+* 快照先写临时文件再改名, 日志每条刷盘, 断电最多丢最后一条没写完的记录
+*
+ */
+type PersistentStore struct {
+	*RulexStore
+	config PersistentConfig
+	lock   sync.Mutex // 保证内存和磁盘的修改顺序一致
+	dirty  bool
+	log    *os.File
+	logLen int64
+	cancel context.CancelFunc
+}
+
+/*
+*
+* 打开持久化缓存, 先加载快照再重放日志, 然后合并成新的快照
+*
+ */
+func NewPersistentStore(maxSize int, config PersistentConfig) (*PersistentStore, error) {
+	if config.Path == "" {
+		return nil, errors.New("store path can not be empty")
+	}
+	if config.SyncMode == "" {
+		config.SyncMode = SYNC_SNAPSHOT
+	}
+	if config.SyncMode != SYNC_SNAPSHOT && config.SyncMode != SYNC_WRITE_THROUGH {
+		return nil, errors.New("unsupported store sync mode:" + config.SyncMode)
+	}
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = 5 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0755); err != nil {
+		return nil, err
+	}
+	ps := &PersistentStore{
+		RulexStore: NewRulexStore(maxSize).(*RulexStore),
+		config:     config,
+	}
+	if err := ps.replay(config.Path); err != nil {
+		return nil, err
+	}
+	if err := ps.replay(ps.logPath()); err != nil {
+		return nil, err
+	}
+	if err := ps.compact(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(typex.GCTX)
+	ps.cancel = cancel
+	if config.SyncMode == SYNC_SNAPSHOT {
+		go ps.snapshotLoop(ctx)
+	}
+	return ps, nil
+}
+
+func (ps *PersistentStore) logPath() string {
+	return ps.config.Path + ".log"
+}
+
+// 设置值
+func (ps *PersistentStore) Set(k string, v string) {
+	ps.SetWithTTL(k, v, 0)
+}
+
+// 设置值, ttl 小于等于0表示永不过期
+func (ps *PersistentStore) SetWithTTL(k string, v string, ttl time.Duration) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.RulexStore.SetWithTTL(k, v, ttl)
+	ps.changed(k)
+}
+
+// 删除值
+func (ps *PersistentStore) Delete(k string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	ps.RulexStore.Delete(k)
+	ps.changed(k)
+	return nil
+}
+
+// 原子加
+func (ps *PersistentStore) Incr(k string, delta int64) (int64, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	v, err := ps.RulexStore.Incr(k, delta)
+	if err != nil {
+		return 0, err
+	}
+	ps.changed(k)
+	return v, nil
+}
+
+//
+// 某个 Key 改了: 快照模式只做标记, 写穿模式马上追加日志
+//
+func (ps *PersistentStore) changed(k string) {
+	if ps.config.SyncMode == SYNC_SNAPSHOT {
+		ps.dirty = true
+		return
+	}
+	rec, ok := ps.RulexStore.record(k)
+	if !ok {
+		rec = record{Op: "del", Key: k}
+	}
+	if err := ps.appendLog(rec); err != nil {
+		glogger.GLogger.Error("Store write log error:", err)
+	}
+}
+
+func (ps *PersistentStore) appendLog(rec record) error {
+	if ps.log == nil {
+		f, err := os.OpenFile(ps.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		ps.log = f
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := ps.log.Write(line); err != nil {
+		return err
+	}
+	if err := ps.log.Sync(); err != nil {
+		return err
+	}
+	ps.logLen += int64(len(line))
+	// 日志太大就合并成快照
+	if ps.config.MaxDiskSize > 0 && ps.logLen > ps.config.MaxDiskSize/2 {
+		return ps.compact()
+	}
+	return nil
+}
+
+func (ps *PersistentStore) snapshotLoop(ctx context.Context) {
+	ticker := time.NewTicker(ps.config.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ps.Flush(); err != nil {
+			glogger.GLogger.Error("Store snapshot error:", err)
+		}
+	}
+}
+
+/*
+*
+* 有修改就写一次快照
+*
+ */
+func (ps *PersistentStore) Flush() error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if !ps.dirty {
+		return nil
+	}
+	return ps.compact()
+}
+
+/*
+*
+* 写快照并且清空日志: 最近用过的在前面, 超过磁盘上限的部分直接淘汰
+*
+ */
+func (ps *PersistentStore) compact() error {
+	// 写穿模式快照和日志各占一半
+	limit := ps.config.MaxDiskSize
+	if ps.config.SyncMode == SYNC_WRITE_THROUGH {
+		limit = limit / 2
+	}
+	buffer := bytes.Buffer{}
+	for _, rec := range ps.RulexStore.records() {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if limit > 0 && int64(buffer.Len()+len(line)) > limit {
+			glogger.GLogger.Warn("Max store disk size reached, evict:", rec.Key)
+			ps.RulexStore.Delete(rec.Key)
+			continue
+		}
+		buffer.Write(line)
+	}
+	if err := writeFileSync(ps.config.Path, buffer.Bytes()); err != nil {
+		return err
+	}
+	if ps.log != nil {
+		ps.log.Close()
+		ps.log = nil
+	}
+	if err := os.Remove(ps.logPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ps.logLen = 0
+	ps.dirty = false
+	return nil
+}
+
+//
+// 先写临时文件并且刷盘, 再改名覆盖
+//
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//
+// 重放快照或者日志, 最后一行没写完的话直接丢掉
+//
+func (ps *PersistentStore) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	recs := []record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		rec := record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			glogger.GLogger.Warn("Store skip broken record:", err)
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// 快照里面最近用过的在前面, 倒着加载才能保持 LRU 顺序
+	if path == ps.config.Path {
+		for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+			recs[i], recs[j] = recs[j], recs[i]
+		}
+	}
+	ps.RulexStore.load(recs)
+	return nil
+}
+
+/*
+*
+* 关闭缓存, 最后写一次快照
+*
+ */
+func (ps *PersistentStore) Close() error {
+	ps.cancel()
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	return ps.compact()
+}
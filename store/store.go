@@ -1,62 +1,268 @@
 package store
 
 import (
+	"container/list"
+	"errors"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/i4de/rulex/glogger"
 	"github.com/i4de/rulex/typex"
 )
 
+//
+// 缓存的一个值, expireAt 为零表示永不过期
+//
+type entry struct {
+	key      string
+	value    string
+	expireAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+/*
+*
+* 内存缓存: 支持过期时间, 超过最大数量以后淘汰最久没用的值(LRU)
+*
+ */
 type RulexStore struct {
 	maxSize int
-	len     int
-	bucket  sync.Map
+	lock    sync.Mutex
+	lru     *list.List // 最近使用的在前面
+	bucket  map[string]*list.Element
 }
 
 func NewRulexStore(maxSize int) typex.XStore {
 	return &RulexStore{
 		maxSize: maxSize,
-		len:     0,
-		bucket:  sync.Map{},
+		lru:     list.New(),
+		bucket:  map[string]*list.Element{},
 	}
 
 }
 
 // 设置值
 func (rs *RulexStore) Set(k string, v string) {
-	rs.bucket.Store(k, v)
-	if (rs.len + 1) > rs.maxSize {
-		glogger.GLogger.Error("Max store size reached:", rs.len)
-	} else {
-		rs.len += 1
+	rs.SetWithTTL(k, v, 0)
+}
+
+// 设置值, ttl 小于等于0表示永不过期
+func (rs *RulexStore) SetWithTTL(k string, v string, ttl time.Duration) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	expireAt := time.Time{}
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	rs.set(k, v, expireAt)
+}
+
+func (rs *RulexStore) set(k string, v string, expireAt time.Time) {
+	if el, ok := rs.bucket[k]; ok {
+		e := el.Value.(*entry)
+		e.value = v
+		e.expireAt = expireAt
+		rs.lru.MoveToFront(el)
+		return
+	}
+	if rs.maxSize > 0 && len(rs.bucket) >= rs.maxSize {
+		rs.evict()
+	}
+	rs.bucket[k] = rs.lru.PushFront(&entry{key: k, value: v, expireAt: expireAt})
+}
+
+//
+// 腾出位置: 先清理过期的值, 还是满的话淘汰最久没用的值
+//
+func (rs *RulexStore) evict() {
+	rs.purge(time.Now())
+	for len(rs.bucket) >= rs.maxSize {
+		rs.remove(rs.lru.Back())
+	}
+}
+
+func (rs *RulexStore) purge(now time.Time) {
+	for el := rs.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).expired(now) {
+			rs.remove(el)
+		}
+		el = next
+	}
+}
+
+func (rs *RulexStore) remove(el *list.Element) {
+	rs.lru.Remove(el)
+	delete(rs.bucket, el.Value.(*entry).key)
+}
+
+//
+// 取一个没有过期的值, 过期的顺手删掉
+//
+func (rs *RulexStore) lookup(k string) (*entry, bool) {
+	el, ok := rs.bucket[k]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if e.expired(time.Now()) {
+		rs.remove(el)
+		return nil, false
+	}
+	rs.lru.MoveToFront(el)
+	return e, true
+}
+
+//
+// 某个 Key 当前的记录, 持久化用
+//
+func (rs *RulexStore) record(k string) (record, bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	el, ok := rs.bucket[k]
+	if !ok {
+		return record{}, false
+	}
+	e := el.Value.(*entry)
+	return record{Op: "set", Key: e.key, Value: e.value, ExpireAt: e.expireAt}, true
+}
+
+//
+// 所有没过期的记录, 最近用过的在前面
+//
+func (rs *RulexStore) records() []record {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	now := time.Now()
+	recs := []record{}
+	for el := rs.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if !e.expired(now) {
+			recs = append(recs, record{Op: "set", Key: e.key, Value: e.value, ExpireAt: e.expireAt})
+		}
+	}
+	return recs
+}
+
+//
+// 按顺序重放记录, 过期的跳过
+//
+func (rs *RulexStore) load(recs []record) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	now := time.Now()
+	for _, rec := range recs {
+		if rec.Op == "del" {
+			if el, ok := rs.bucket[rec.Key]; ok {
+				rs.remove(el)
+			}
+			continue
+		}
+		if !rec.ExpireAt.IsZero() && !now.Before(rec.ExpireAt) {
+			if el, ok := rs.bucket[rec.Key]; ok {
+				rs.remove(el)
+			}
+			continue
+		}
+		rs.set(rec.Key, rec.Value, rec.ExpireAt)
 	}
 }
 
 // 获取值
 func (rs *RulexStore) Get(k string) string {
-	v, ok := rs.bucket.Load(k)
-	if ok {
-		return v.(string)
-	} else {
-		return ""
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if e, ok := rs.lookup(k); ok {
+		return e.value
 	}
+	return ""
 }
+
 func (rs *RulexStore) Delete(k string) error {
-	rs.bucket.Delete(k)
-	if rs.len > 0 {
-		rs.len -= 1
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if el, ok := rs.bucket[k]; ok {
+		rs.remove(el)
 	}
 	return nil
 }
 
 // 统计数量
 func (rs *RulexStore) Count() int {
-	return rs.len
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.purge(time.Now())
+	return len(rs.bucket)
 }
 
-// 模糊查询匹配
+/*
+*
+* 原子加: 不存在的值从0开始, 过期时间保持不变
+*
+ */
+func (rs *RulexStore) Incr(k string, delta int64) (int64, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	var value int64
+	expireAt := time.Time{}
+	if e, ok := rs.lookup(k); ok {
+		v, err := strconv.ParseInt(e.value, 10, 64)
+		if err != nil {
+			return 0, errors.New("value is not an integer:" + k)
+		}
+		value = v
+		expireAt = e.expireAt
+	}
+	value += delta
+	rs.set(k, strconv.FormatInt(value, 10), expireAt)
+	return value, nil
+}
+
+// 模糊查询匹配, 返回匹配的 Key, 按字母排序
 // 支持: *AAA AAA* A*B
-func (rs *RulexStore) FuzzyGet(k string) string {
-	// TODO 未来实现
-	return ""
+func (rs *RulexStore) FuzzyGet(k string) []string {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.purge(time.Now())
+	keys := []string{}
+	for key := range rs.bucket {
+		if Match(k, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+*
+* 通配符匹配, '*' 匹配任意多个字符
+*
+ */
+func Match(pattern string, s string) bool {
+	// 最后一个 '*' 的位置和当时匹配到的位置, 失配以后从这里回溯
+	star, mark := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, mark = p, i
+			p++
+		} else if p < len(pattern) && pattern[p] == s[i] {
+			p++
+			i++
+		} else if star >= 0 {
+			mark++
+			p, i = star+1, mark
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
 }
@@ -1,8 +1,11 @@
 package target
 
 import (
+	"fmt"
+
 	"github.com/i4de/rulex/core"
 	"github.com/i4de/rulex/typex"
+	"github.com/i4de/rulex/utils"
 )
 
 var TM typex.TargetRegistry = core.NewTargetTypeManager()
@@ -21,3 +24,26 @@ func LoadTt() {
 	TM.Register(typex.NATS_TARGET, core.GenOutConfig(typex.NATS_TARGET, "About NATS_TARGET", natsConfig{}))
 	TM.Register(typex.TDENGINE_TARGET, core.GenOutConfig(typex.TDENGINE_TARGET, "About TDENGINE_TARGET", tdEngineConfig{}))
 }
+
+//
+// 每种目标的配置结构体, 和各自 Start 里面用的保持一致
+//
+var targetConfigs = map[typex.TargetType]func() interface{}{
+	typex.HTTP_TARGET:       func() interface{} { return &httpConfig{} },
+	typex.MONGO_SINGLE:      func() interface{} { return &mongoConfig{} },
+	typex.MQTT_TARGET:       func() interface{} { return &mqttConfig{} },
+	typex.NATS_TARGET:       func() interface{} { return &natsConfig{} },
+	typex.TDENGINE_TARGET:   func() interface{} { return &tdEngineConfig{} },
+	typex.GRPC_CODEC_TARGET: func() interface{} { return &_codecTargetConfig{} },
+}
+
+//
+// 加载之前先用目标的配置结构体校验一下配置
+//
+func ValidateConfig(Type typex.TargetType, config map[string]interface{}) error {
+	newConfig, ok := targetConfigs[Type]
+	if !ok {
+		return fmt.Errorf("unsupported OutEnd type:%s", Type)
+	}
+	return utils.BindSourceConfig(config, newConfig())
+}
@@ -0,0 +1,194 @@
+package test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+)
+
+func startHistory(t *testing.T, dir string) *typex.HistoryStore {
+	if err := typex.StartHistoryStore(typex.HistoryConfig{
+		Path:                dir,
+		RawRetention:        time.Hour,
+		DownsampleInterval:  time.Minute,
+		DownsampleRetention: 24 * time.Hour,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return typex.DefaultHistory
+}
+
+/*
+*
+* 按时间范围, 聚合间隔和标签查询
+*
+ */
+func Test_history_query(t *testing.T) {
+	dir := "./" + GenDate() + "-history"
+	defer os.RemoveAll(dir)
+	history := startHistory(t, dir)
+	defer history.Close()
+	base := time.Now().Add(-10*time.Minute).Truncate(time.Minute).UnixNano() / int64(time.Millisecond)
+	for i := 0; i < 6; i++ {
+		// 每 20 秒一个点, 两个设备
+		ts := base + int64(i)*20000
+		history.Write(typex.HistoryPoint{Metric: "temp", Tags: map[string]string{"device": "d1"},
+			Value: float64(i), Timestamp: ts})
+		history.Write(typex.HistoryPoint{Metric: "temp", Tags: map[string]string{"device": "d2"},
+			Value: 100, Timestamp: ts})
+	}
+	raw, err := history.Query(typex.HistoryQuery{Metric: "temp", Tags: map[string]string{"device": "d1"},
+		Start: base, End: base + 40000})
+	if err != nil || len(raw) != 1 || len(raw[0].Points) != 3 {
+		t.Fatal(raw, err)
+	}
+	avg, err := history.Query(typex.HistoryQuery{Metric: "temp", Tags: map[string]string{"device": "d1"},
+		Start: base, End: base + 120000, Interval: time.Minute})
+	if err != nil || len(avg[0].Points) != 2 || avg[0].Points[0].Value != 1 || avg[0].Points[1].Value != 4 {
+		t.Fatal(avg, err)
+	}
+	max, err := history.Query(typex.HistoryQuery{Metric: "temp", Start: base, End: base + 120000,
+		Interval: time.Hour, Aggregation: typex.HISTORY_MAX})
+	if err != nil || len(max) != 2 || max[0].Points[0].Value != 5 || max[1].Points[0].Value != 100 {
+		t.Fatal(max, err)
+	}
+	if _, err := history.Query(typex.HistoryQuery{Metric: "temp", Aggregation: "median"}); err == nil {
+		t.Fatal("unsupported aggregation should fail")
+	}
+	if len(history.Series("temp")) != 2 {
+		t.Fatal(history.Series("temp"))
+	}
+}
+
+/*
+*
+* 原始数据过期以后降采样, 重启以后恢复, 不会重复计算
+*
+ */
+func Test_history_downsample(t *testing.T) {
+	dir := "./" + GenDate() + "-history"
+	defer os.RemoveAll(dir)
+	history := startHistory(t, dir)
+	old := time.Now().Add(-2*time.Hour).Truncate(time.Minute).UnixNano() / int64(time.Millisecond)
+	for i := 0; i < 4; i++ {
+		history.Write(typex.HistoryPoint{Metric: "power", Value: 10, Timestamp: old + int64(i)*10000})
+	}
+	history.Write(typex.HistoryPoint{Metric: "power", Value: 20})
+	history.Maintain(time.Now())
+	info := history.Series("power")
+	if len(info) != 1 || info[0].Raw != 1 || info[0].Rollups != 1 {
+		t.Fatal(info)
+	}
+	if err := history.Write(typex.HistoryPoint{Metric: "power", Value: 1, Timestamp: old}); err == nil {
+		t.Fatal("point older than downsampled data should fail")
+	}
+	if err := history.Close(); err != nil {
+		t.Fatal(err)
+	}
+	history = startHistory(t, dir)
+	defer history.Close()
+	end := time.Now().UnixNano() / int64(time.Millisecond)
+	sum, err := history.Query(typex.HistoryQuery{Metric: "power", Start: old, End: end,
+		Interval: 24 * time.Hour, Aggregation: typex.HISTORY_SUM})
+	if err != nil || len(sum) != 1 {
+		t.Fatal(sum, err)
+	}
+	total := 0.0
+	for _, p := range sum[0].Points {
+		total += p.Value
+	}
+	if total != 60 {
+		t.Fatal(sum)
+	}
+}
+
+/*
+*
+* 序列数和内存里面的原始数据点数有上限, 超过的点提前降采样, 重启以后不丢
+*
+ */
+func Test_history_limits(t *testing.T) {
+	dir := "./" + GenDate() + "-history"
+	defer os.RemoveAll(dir)
+	config := typex.HistoryConfig{
+		Path:                dir,
+		RawRetention:        time.Hour,
+		DownsampleInterval:  time.Minute,
+		DownsampleRetention: 24 * time.Hour,
+		MaxSeries:           2,
+		MaxPoints:           3,
+	}
+	if err := typex.StartHistoryStore(config); err != nil {
+		t.Fatal(err)
+	}
+	history := typex.DefaultHistory
+	base := time.Now().Add(-10*time.Minute).Truncate(time.Minute).UnixNano() / int64(time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if err := history.Write(typex.HistoryPoint{Metric: "a", Value: float64(i), Timestamp: base + int64(i)*1000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := history.Write(typex.HistoryPoint{Metric: "b", Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Write(typex.HistoryPoint{Metric: "c", Value: 1}); err == nil {
+		t.Fatal("series over limit should fail")
+	}
+	check := func() {
+		info := history.Series("a")
+		if len(info) != 1 || info[0].Raw != 3 || info[0].Rollups != 1 {
+			t.Fatal(info)
+		}
+		sum, err := history.Query(typex.HistoryQuery{Metric: "a", Start: base, End: base + 60000,
+			Interval: time.Hour, Aggregation: typex.HISTORY_SUM})
+		if err != nil || len(sum) != 1 || len(sum[0].Points) != 1 || sum[0].Points[0].Value != 45 {
+			t.Fatal(sum, err)
+		}
+	}
+	check()
+	if err := history.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := typex.StartHistoryStore(config); err != nil {
+		t.Fatal(err)
+	}
+	history = typex.DefaultHistory
+	defer history.Close()
+	check()
+}
+
+/*
+*
+* 规则里面写历史数据
+*
+ */
+func Test_history_lua(t *testing.T) {
+	dir := "./" + GenDate() + "-history"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	history := startHistory(t, dir)
+	defer history.Close()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "history", "history", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data)
+			local err = rulexlib:WriteHistory("humidity", tonumber(data), {room = "r1"})
+			if err ~= nil then error(err) end
+			return true, data
+		end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	engine.WorkInEnd(in, "45.5")
+	time.Sleep(100 * time.Millisecond)
+	series, err := history.Query(typex.HistoryQuery{Metric: "humidity", Tags: map[string]string{"room": "r1"}})
+	if err != nil || len(series) != 1 || series[0].Points[0].Value != 45.5 {
+		t.Fatal(series, err)
+	}
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+)
+
+/*
+*
+* 按大小滚动, 压缩, 只保留最近的几个文件
+*
+ */
+func Test_log_rotate(t *testing.T) {
+	dir := "./" + GenDate() + "-log"
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+	lw := glogger.NewLogWriter(dir+"/rulex-log.txt", glogger.RotateConfig{
+		MaxSize:    100,
+		MaxBackups: 2,
+		Compress:   true,
+	})
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := lw.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// 滚动出去的文件名精确到毫秒
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backups := lw.Backups()
+	if len(backups) != 2 {
+		t.Fatal(backups)
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".txt.gz") {
+			t.Fatal(b)
+		}
+	}
+	if tail := lw.Tail(10); len(tail) != 1 || tail[0] != strings.TrimSpace(line) {
+		t.Fatal(tail)
+	}
+}
+
+/*
+*
+* 滚动失败(这里是文件被外部删掉了)以后重新打开原来的文件接着写
+*
+ */
+func Test_log_rotate_failed(t *testing.T) {
+	dir := "./" + GenDate() + "-log-failed"
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+	lw := glogger.NewLogWriter(dir+"/rulex-log.txt", glogger.RotateConfig{MaxSize: 100})
+	defer lw.Close()
+	line := strings.Repeat("x", 59) + "\n"
+	if _, err := lw.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(dir + "/rulex-log.txt")
+	if _, err := lw.Write([]byte(line)); err == nil {
+		t.Fatal("rotate error not returned")
+	}
+	if _, err := lw.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	if tail := lw.Tail(10); len(tail) != 2 {
+		t.Fatal(tail)
+	}
+}
+
+/*
+*
+* 从文件末尾往前读最后几行, 跨过读取的块也不会把一行截断
+*
+ */
+func Test_log_tail(t *testing.T) {
+	dir := "./" + GenDate() + "-log"
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+	lw := glogger.NewLogWriter(dir+"/rulex-log.txt", glogger.RotateConfig{})
+	defer lw.Close()
+	for i := 0; i < 2000; i++ {
+		lw.Write([]byte(fmt.Sprintf("%04d %s\n", i, strings.Repeat("y", 95))))
+	}
+	tail := lw.Tail(3)
+	if len(tail) != 3 || !strings.HasPrefix(tail[0], "1997 ") || !strings.HasPrefix(tail[2], "1999 ") {
+		t.Fatal(tail)
+	}
+	if all := lw.Tail(0); len(all) != 2000 || !strings.HasPrefix(all[0], "0000 ") {
+		t.Fatal(len(all))
+	}
+}
+
+/*
+*
+* 运行时修改全局级别和模块级别
+*
+ */
+func Test_log_level(t *testing.T) {
+	dir := "./" + GenDate() + "-log"
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+	glogger.StartGLogger(true, dir+"/rulex-log.txt")
+	buffer := bytes.Buffer{}
+	glogger.GLogger.SetOutput(&buffer)
+	defer glogger.GLogger.SetOutput(os.Stdout)
+	defer glogger.SetLevel("info")
+
+	if err := glogger.SetLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	if err := glogger.SetModuleLevel("engine", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	defer glogger.SetModuleLevel("engine", "")
+	glogger.GLogger.WithField("module", "engine").Debug("engine-debug")
+	glogger.GLogger.WithField("module", "engine/sub").Debug("sub-debug")
+	glogger.GLogger.WithField("module", "target").Info("target-info")
+	// 测试包自己的日志按全局级别过滤
+	glogger.GLogger.Info("test-info")
+	glogger.GLogger.Warn("test-warn")
+	out := buffer.String()
+	for _, expect := range []string{"engine-debug", "sub-debug", "test-warn"} {
+		if !strings.Contains(out, expect) {
+			t.Fatal(expect, out)
+		}
+	}
+	for _, unexpect := range []string{"target-info", "test-info"} {
+		if strings.Contains(out, unexpect) {
+			t.Fatal(unexpect, out)
+		}
+	}
+	level, modules := glogger.Levels()
+	if level != "warning" || modules["engine"] != "debug" {
+		t.Fatal(level, modules)
+	}
+	if err := glogger.SetLevel("verbose"); err == nil {
+		t.Fatal("unsupported level should fail")
+	}
+}
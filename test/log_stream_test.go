@@ -0,0 +1,151 @@
+package test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/typex"
+)
+
+func receiveLog(t *testing.T, sub *glogger.LogSubscriber) glogger.LogEntry {
+	select {
+	case e := <-sub.Entries():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no log received")
+	}
+	return glogger.LogEntry{}
+}
+
+/*
+*
+* 按级别, 资源和文本过滤主日志
+*
+ */
+func Test_log_stream_filter(t *testing.T) {
+	// 模块名从调用者里面取, 单独跑这个测试的时候日志还没初始化
+	glogger.GLogger.SetReportCaller(true)
+	sub, err := glogger.SubscribeLog(glogger.LogFilter{Level: "warn", Resource: "dev-1", Text: "TIMEOUT"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer glogger.UnsubscribeLog(sub)
+	glogger.GLogger.WithField("resource", "dev-1").Info("read timeout")
+	glogger.GLogger.WithField("resource", "dev-2").Warn("read timeout")
+	glogger.GLogger.WithField("resource", "dev-1").Warn("connect refused")
+	glogger.GLogger.WithField("resource", "dev-1").Error("read timeout")
+	e := receiveLog(t, sub)
+	if e.Source != glogger.LOG_SOURCE_RULEX || e.Level != "error" || e.Resource != "dev-1" || e.Module != "test" {
+		t.Fatal(e)
+	}
+	select {
+	case e := <-sub.Entries():
+		t.Fatal("unexpected log", e)
+	default:
+	}
+	if _, err := glogger.SubscribeLog(glogger.LogFilter{Level: "verbose"}, 0); err == nil {
+		t.Fatal("unsupported level should fail")
+	}
+}
+
+/*
+*
+* 缓冲满了丢弃并计数, 取消订阅以后通道关闭
+*
+ */
+func Test_log_stream_slow_client(t *testing.T) {
+	sub, err := glogger.SubscribeLog(glogger.LogFilter{Text: "flood"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		glogger.PublishLog(glogger.LogEntry{Level: "info", Message: "flood"})
+	}
+	if dropped := sub.TakeDropped(); dropped != 3 {
+		t.Fatal(dropped)
+	}
+	if sub.TakeDropped() != 0 {
+		t.Fatal("dropped counter should reset")
+	}
+	glogger.UnsubscribeLog(sub)
+	count := 0
+	for range sub.Entries() {
+		count++
+	}
+	if count != 2 {
+		t.Fatal(count)
+	}
+}
+
+/*
+*
+* 规则里面的 Lua 日志带上规则和资源 UUID
+*
+ */
+func Test_log_stream_lua(t *testing.T) {
+	dir := "./" + GenDate() + "-log"
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+	glogger.StartLuaLogger(dir + "/rulex-lua-log.txt")
+	defer glogger.LUA_LOGGER.Close()
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "log", "log", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data)
+			rulexlib:log("lua says " .. data)
+			return true, data
+		end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := glogger.SubscribeLog(glogger.LogFilter{Rule: rule.UUID}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer glogger.UnsubscribeLog(sub)
+	engine.WorkInEnd(in, "hello")
+	e := receiveLog(t, sub)
+	if e.Source != glogger.LOG_SOURCE_LUA || e.Message != "lua says hello" || e.Resource != in.UUID {
+		t.Fatal(e)
+	}
+}
+
+/*
+*
+* 引擎执行规则的日志带上规则和资源字段
+*
+ */
+func Test_log_stream_rule_fields(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "fields", "fields", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data)
+			error("bad data")
+		end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.RemoveRule(rule.UUID)
+	sub, err := glogger.SubscribeLog(glogger.LogFilter{Rule: rule.UUID, Level: "error"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer glogger.UnsubscribeLog(sub)
+	engine.WorkInEnd(in, "hello")
+	e := receiveLog(t, sub)
+	if e.Rule != rule.UUID || e.Resource != in.UUID {
+		t.Fatal(e)
+	}
+}
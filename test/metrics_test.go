@@ -0,0 +1,89 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 按资源统计: 输入、规则执行、输出各自计数, 规则和输出有延迟直方图
+*
+ */
+func Test_resource_metrics(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	statistics.ResetMetrics()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "metrics", "metrics", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data)
+				if data == "bad" then error("bad data") end
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	engine.PushInQueue(in, "good")
+	engine.PushInQueue(in, "bad")
+	time.Sleep(200 * time.Millisecond)
+
+	inMetrics := statistics.GetMetrics(statistics.INEND, in.UUID)
+	if inMetrics == nil || inMetrics.Messages != 2 || inMetrics.Bytes != 7 || inMetrics.Errors != 0 ||
+		inMetrics.LastMessage.IsZero() || inMetrics.Latency != nil {
+		t.Fatal(inMetrics)
+	}
+	ruleMetrics := statistics.GetMetrics(statistics.RULE, rule.UUID)
+	if ruleMetrics == nil || ruleMetrics.Messages != 2 || ruleMetrics.Errors != 1 ||
+		ruleMetrics.Latency == nil || ruleMetrics.Latency.Count != 2 ||
+		len(ruleMetrics.Latency.Counts) != len(statistics.LatencyBuckets)+1 {
+		t.Fatal(ruleMetrics)
+	}
+
+	target := &flakyTarget{up: true}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "out", "", map[string]interface{}{})
+	out.Target = target
+	typex.DeliverToOutEnd(out, "hello")
+	outMetrics := statistics.GetMetrics(statistics.OUTEND, out.UUID)
+	if outMetrics == nil || outMetrics.Messages != 1 || outMetrics.Bytes != 5 || outMetrics.Latency.Count != 1 {
+		t.Fatal(outMetrics)
+	}
+
+	// 并发记录不丢数据
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				statistics.Observe(statistics.DEVICE, "device", 1, time.Millisecond, nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if m := statistics.AllMetrics()[statistics.DEVICE]["device"]; m.Messages != 1000 || m.Latency.Count != 1000 {
+		t.Fatal(m)
+	}
+
+	// 更新规则的时候只卸载, 统计数据保留
+	engine.UnloadRule(rule.UUID)
+	if engine.GetRule(rule.UUID) != nil || statistics.GetMetrics(statistics.RULE, rule.UUID) == nil {
+		t.Fatal("metrics removed with rule update")
+	}
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	engine.RemoveRule(rule.UUID)
+	if statistics.GetMetrics(statistics.RULE, rule.UUID) != nil {
+		t.Fatal("metrics not removed with rule")
+	}
+}
@@ -0,0 +1,257 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/diskqueue"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 磁盘队列: 顺序, 重启后恢复, 超过容量丢弃最旧消息
+*
+ */
+func Test_disk_queue(t *testing.T) {
+	dir := "./" + GenDate() + "-diskqueue"
+	defer os.RemoveAll(dir)
+	q, err := diskqueue.Open(dir, 8*(8+1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := q.Push([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != 8 || q.Dropped() != 2 {
+		t.Fatalf("len=%v dropped=%v", q.Len(), q.Dropped())
+	}
+	// 重新打开
+	q, err = diskqueue.Open(dir, 8*(8+1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < 10; i++ {
+		msg, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != strconv.Itoa(i) {
+			t.Fatalf("out of order: %v != %v", string(msg.Data), i)
+		}
+		q.Remove(msg.Seq)
+	}
+	if _, err := q.Peek(); err != diskqueue.ErrEmpty {
+		t.Fatal("queue should be empty")
+	}
+}
+
+/*
+*
+* 批量同步: 没同步之前也能读, 同步以后重新打开不丢
+*
+ */
+func Test_disk_queue_batch_sync(t *testing.T) {
+	dir := "./" + GenDate() + "-diskqueue"
+	defer os.RemoveAll(dir)
+	q, err := diskqueue.Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SetSyncInterval(time.Hour)
+	for _, s := range []string{"a", "b", "c"} {
+		if err := q.Push([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := q.Peek()
+	if err != nil || string(msg.Data) != "a" {
+		t.Fatal(msg, err)
+	}
+	if err := q.Remove(msg.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = diskqueue.Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := q.Peek(); q.Len() != 2 || err != nil || string(msg.Data) != "b" {
+		t.Fatal(q.Len(), msg, err)
+	}
+}
+
+// 可以控制上下线的假目标
+type flakyTarget struct {
+	typex.XStatus
+	lock     sync.Mutex
+	up       bool
+	received []string
+}
+
+func (f *flakyTarget) Test(string) bool                          { return true }
+func (f *flakyTarget) Init(string, map[string]interface{}) error { return nil }
+func (f *flakyTarget) Start(typex.CCTX) error                    { f.setUp(true); return nil }
+func (f *flakyTarget) Enabled() bool                             { return true }
+func (f *flakyTarget) Reload()                                   {}
+func (f *flakyTarget) Pause()                                    {}
+func (f *flakyTarget) Details() *typex.OutEnd                    { return nil }
+func (f *flakyTarget) Configs() *typex.XConfig                   { return nil }
+func (f *flakyTarget) Stop()                                     { f.setUp(false) }
+func (f *flakyTarget) setUp(up bool)                             { f.lock.Lock(); f.up = up; f.lock.Unlock() }
+func (f *flakyTarget) Status() typex.SourceState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.up {
+		return typex.SOURCE_UP
+	}
+	return typex.SOURCE_DOWN
+}
+func (f *flakyTarget) To(data interface{}) (interface{}, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.up {
+		return nil, errors.New("uplink down")
+	}
+	f.received = append(f.received, data.(string))
+	return nil, nil
+}
+
+/*
+*
+* 断网的时候缓存, 恢复以后按顺序补发
+*
+ */
+func Test_outend_buffer_replay(t *testing.T) {
+	dir := "./" + GenDate() + "-buffer"
+	defer os.RemoveAll(dir)
+	target := &flakyTarget{}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "flaky", "", map[string]interface{}{
+		typex.OUTEND_BUFFER_CONFIG_KEY: map[string]interface{}{"enable": true},
+	})
+	out.Target = target
+	if err := typex.StartOutEndBuffer(out, dir); err != nil {
+		t.Fatal(err)
+	}
+	defer typex.StopOutEndBuffer(out)
+	for i := 0; i < 5; i++ {
+		typex.DeliverToOutEnd(out, strconv.Itoa(i))
+	}
+	if out.BufferStatus().Messages != 5 {
+		t.Fatalf("buffered %v messages", out.BufferStatus().Messages)
+	}
+	target.setUp(true)
+	time.Sleep(1500 * time.Millisecond)
+	typex.DeliverToOutEnd(out, "5")
+	target.lock.Lock()
+	defer target.lock.Unlock()
+	if len(target.received) != 6 {
+		t.Fatalf("received %v messages", len(target.received))
+	}
+	for i, v := range target.received {
+		if v != strconv.Itoa(i) {
+			t.Fatalf("out of order: %v != %v", v, i)
+		}
+	}
+	t.Log(out.BufferStatus())
+}
+
+/*
+*
+* 重试不阻塞投递, 重试以后依然失败的消息进死信, 恢复以后可以重新投递
+*
+ */
+func Test_outend_dead_letter(t *testing.T) {
+	dir := "./" + GenDate() + "-deadletter"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	if err := typex.StartDeadLetterStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	target := &flakyTarget{}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "flaky", "", map[string]interface{}{
+		typex.OUTEND_RETRY_CONFIG_KEY: map[string]interface{}{
+			"maxAttempts":     3,
+			"initialInterval": 50,
+		},
+	})
+	out.Target = target
+	engine.SaveOutEnd(out)
+	start := time.Now()
+	typex.DeliverToOutEnd(out, "hello")
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatal("retry should not block the worker:", elapsed)
+	}
+	if typex.DefaultDeadLetters.Len() != 0 {
+		t.Fatal("dead letter before retries finished")
+	}
+	// 50ms 和 100ms 以后各重试一次
+	time.Sleep(300 * time.Millisecond)
+	dls := typex.DefaultDeadLetters.List(out.UUID, 0)
+	if len(dls) != 1 || dls[0].Attempts != 3 || dls[0].Error != "uplink down" {
+		t.Fatal(dls)
+	}
+	target.setUp(true)
+	if err := typex.DefaultDeadLetters.Replay(engine, dls[0].Id, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	target.lock.Lock()
+	defer target.lock.Unlock()
+	if len(target.received) != 1 || typex.DefaultDeadLetters.Len() != 0 {
+		t.Fatal(target.received, typex.DefaultDeadLetters.Len())
+	}
+}
+
+/*
+*
+* 没有缓存的时候失败的消息按顺序重试, 后面的消息排在后面
+*
+ */
+func Test_outend_retry_order(t *testing.T) {
+	dir := "./" + GenDate() + "-retry"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	if err := typex.StartDeadLetterStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	target := &flakyTarget{}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "flaky", "", map[string]interface{}{
+		typex.OUTEND_RETRY_CONFIG_KEY: map[string]interface{}{
+			"maxAttempts":     10,
+			"initialInterval": 20,
+			"multiplier":      1,
+		},
+	})
+	out.Target = target
+	engine.SaveOutEnd(out)
+	for i := 0; i < 5; i++ {
+		typex.DeliverToOutEnd(out, strconv.Itoa(i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	target.setUp(true)
+	typex.DeliverToOutEnd(out, "5")
+	time.Sleep(200 * time.Millisecond)
+	target.lock.Lock()
+	defer target.lock.Unlock()
+	if len(target.received) != 6 || typex.DefaultDeadLetters.Len() != 0 {
+		t.Fatal(target.received, typex.DefaultDeadLetters.Len())
+	}
+	for i, v := range target.received {
+		if v != strconv.Itoa(i) {
+			t.Fatal("out of order:", target.received)
+		}
+	}
+}
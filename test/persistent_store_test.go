@@ -0,0 +1,91 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/store"
+)
+
+/*
+*
+* 写穿模式: 每次修改都落盘, 不用关闭也能恢复, 没写完的最后一行丢掉
+*
+ */
+func Test_persistent_store_write_through(t *testing.T) {
+	dir := "./" + GenDate() + "-store"
+	defer os.RemoveAll(dir)
+	config := store.PersistentConfig{Path: dir + "/store.db", SyncMode: store.SYNC_WRITE_THROUGH}
+	s, err := store.NewPersistentStore(16, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("k", "v")
+	s.Set("deleted", "v")
+	s.Delete("deleted")
+	s.Incr("counter", 3)
+	s.SetWithTTL("expired", "v", 20*time.Millisecond)
+	// 模拟断电: 不关闭, 日志最后半行
+	f, err := os.OpenFile(config.Path+".log", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"set","key":"bro`)
+	f.Close()
+	time.Sleep(30 * time.Millisecond)
+
+	s2, err := store.NewPersistentStore(16, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if s2.Get("k") != "v" || s2.Get("counter") != "3" || s2.Get("deleted") != "" ||
+		s2.Get("expired") != "" || s2.Count() != 2 {
+		t.Fatal(s2.FuzzyGet("*"))
+	}
+}
+
+/*
+*
+* 快照模式: 关闭的时候写快照, 超过磁盘上限淘汰最久没用的值
+*
+ */
+func Test_persistent_store_snapshot(t *testing.T) {
+	dir := "./" + GenDate() + "-store"
+	defer os.RemoveAll(dir)
+	config := store.PersistentConfig{
+		Path:             dir + "/store.db",
+		SyncMode:         store.SYNC_SNAPSHOT,
+		SnapshotInterval: time.Hour,
+		MaxDiskSize:      250,
+	}
+	s, err := store.NewPersistentStore(0, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		s.Set(k, strings.Repeat(k, 40))
+	}
+	s.Get("a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(config.Path)
+	if err != nil || info.Size() > config.MaxDiskSize {
+		t.Fatal(info, err)
+	}
+	s2, err := store.NewPersistentStore(0, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	// b 最久没用, 被淘汰
+	if s2.Get("a") == "" || s2.Get("c") == "" || s2.Get("b") != "" {
+		t.Fatal(s2.FuzzyGet("*"))
+	}
+	if _, err := store.NewPersistentStore(0, store.PersistentConfig{Path: config.Path, SyncMode: "never"}); err == nil {
+		t.Fatal("unsupported sync mode should fail")
+	}
+}
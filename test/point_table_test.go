@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/i4de/rulex/common"
+)
+
+/*
+*
+* 点位表解析: 各种数据类型, 字节序, 字序, 倍率和偏移
+*
+ */
+func Test_point_table_decode(t *testing.T) {
+	// 4 个寄存器: int16(-2), uint16(600), float32(12.5) 按 CDAB 排列
+	raw := []byte{0xFF, 0xFE, 0x02, 0x58}
+	f := make([]byte, 4)
+	binary.BigEndian.PutUint32(f, math.Float32bits(12.5))
+	raw = append(raw, f[2], f[3], f[0], f[1])
+	// int32(-100000) 按 BADC 排列
+	i32 := make([]byte, 4)
+	binary.BigEndian.PutUint32(i32, uint32(0xFFFE7960))
+	raw = append(raw, i32[1], i32[0], i32[3], i32[2])
+	// float64(3.25) 按 DCBA 排列
+	f64 := make([]byte, 8)
+	binary.BigEndian.PutUint64(f64, math.Float64bits(3.25))
+	for i := 7; i >= 0; i-- {
+		raw = append(raw, f64[i])
+	}
+	table := common.PointTable{
+		{Name: "a", Tag: "hr", Address: 0, DataType: common.POINT_INT16},
+		{Name: "b", Tag: "hr", Address: 2, DataType: common.POINT_UINT16, Scale: 0.1, Offset: -10, Unit: "℃"},
+		{Name: "c", Tag: "hr", Address: 4, DataType: common.POINT_FLOAT32, WordOrder: common.LITTLE_ENDIAN},
+		{Name: "d", Tag: "hr", Address: 8, DataType: common.POINT_INT32, ByteOrder: common.LITTLE_ENDIAN},
+		{Name: "e", Tag: "hr", Address: 12, DataType: common.POINT_FLOAT64,
+			ByteOrder: common.LITTLE_ENDIAN, WordOrder: common.LITTLE_ENDIAN},
+		{Name: "f", Tag: "coil", Address: 0, Bit: 2, DataType: common.POINT_BOOL},
+		{Name: "g", Tag: "db", Address: 0, Length: 8, DataType: common.POINT_STRING},
+	}
+	if err := table.Validate(map[string]int{"hr": len(raw), "coil": 1, "db": 8}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := table.Decode(map[string][]byte{
+		"hr":   raw,
+		"coil": {0b00000100},
+		"db":   []byte("rulex\x00\x00\x00"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expects := map[string]interface{}{
+		"a": float64(-2), "b": float64(50), "c": float64(12.5),
+		"d": float64(-100000), "e": float64(3.25), "f": true, "g": "rulex",
+	}
+	for name, expect := range expects {
+		value := values[name].Value
+		if f, ok := value.(float64); ok {
+			if math.Abs(f-expect.(float64)) > 1e-9 {
+				t.Fatal(name, value, expect)
+			}
+			continue
+		}
+		if value != expect {
+			t.Fatal(name, value, expect)
+		}
+	}
+	if values["b"].Unit != "℃" {
+		t.Fatal(values["b"])
+	}
+}
+
+func Test_point_table_validate(t *testing.T) {
+	cases := []common.PointTable{
+		{{Name: "a", Tag: "x", DataType: common.POINT_INT16}},
+		{{Name: "a", Tag: "hr", DataType: "int64"}},
+		{{Name: "a", Tag: "hr", DataType: common.POINT_STRING}},
+		{{Name: "a", Tag: "hr", DataType: common.POINT_INT16, ByteOrder: "middle"}},
+		{{Name: "a", Tag: "hr", DataType: common.POINT_INT16}, {Name: "a", Tag: "hr", DataType: common.POINT_INT16}},
+		// 超出采集区
+		{{Name: "a", Tag: "hr", Address: 2, DataType: common.POINT_INT32}},
+		{{Name: "a", Tag: "hr", Length: 5, DataType: common.POINT_STRING}},
+	}
+	for i, table := range cases {
+		if err := table.Validate(map[string]int{"hr": common.ModbusAreaSize(common.READ_HOLDING_REGISTERS, 2)}); err == nil {
+			t.Fatal("case should fail:", i)
+		}
+	}
+	if common.ModbusAreaSize(common.READ_COIL, 9) != 2 {
+		t.Fatal(common.ModbusAreaSize(common.READ_COIL, 9))
+	}
+	// 解析失败的点位跳过, 不影响其他点位
+	table := common.PointTable{
+		{Name: "a", Tag: "hr", Address: 2, DataType: common.POINT_INT32},
+		{Name: "b", Tag: "hr", Address: 0, DataType: common.POINT_UINT16},
+	}
+	values, err := table.Decode(map[string][]byte{"hr": {0, 1, 2, 3}})
+	if err == nil {
+		t.Fatal("out of range should be reported")
+	}
+	if _, ok := values["a"]; ok || values["b"].Value != float64(1) {
+		t.Fatal(values)
+	}
+}
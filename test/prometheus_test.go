@@ -0,0 +1,57 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	httpserver "github.com/i4de/rulex/plugin/http_server"
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* Prometheus 文本格式: 资源名作为标签, 规则执行次数和延迟直方图
+*
+ */
+func Test_prometheus_metrics(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	statistics.ResetMetrics()
+	in := typex.NewInEnd(typex.HTTP, `in "1"`, "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "prom", "prom-rule", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) return true, data end }`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	engine.PushInQueue(in, "a")
+	engine.PushInQueue(in, "b")
+	time.Sleep(200 * time.Millisecond)
+	buf := &bytes.Buffer{}
+	httpserver.WritePrometheusMetrics(buf, engine)
+	text := buf.String()
+	for _, want := range []string{
+		"# TYPE rulex_in_success_total counter\n",
+		"# TYPE rulex_queue_depth gauge\n",
+		`rulex_inend_status{uuid="` + in.UUID + `",name="in \"1\"",type="HTTP"} 0` + "\n",
+		`rulex_inend_messages_total{uuid="` + in.UUID + `",name="in \"1\"",type="HTTP"} 2` + "\n",
+		`rulex_rule_status{uuid="` + rule.UUID + `",name="prom-rule"} 1` + "\n",
+		`rulex_rule_messages_total{uuid="` + rule.UUID + `",name="prom-rule"} 2` + "\n",
+		`rulex_rule_errors_total{uuid="` + rule.UUID + `",name="prom-rule"} 0` + "\n",
+		"# TYPE rulex_rule_latency_milliseconds histogram\n",
+		`rulex_rule_latency_milliseconds_bucket{uuid="` + rule.UUID + `",name="prom-rule",le="+Inf"} 2` + "\n",
+		`rulex_rule_latency_milliseconds_count{uuid="` + rule.UUID + `",name="prom-rule"} 2` + "\n",
+		"# TYPE rulex_memory_alloc_bytes gauge\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatal("missing:", want, "\n", text)
+		}
+	}
+	engine.RemoveRule(rule.UUID)
+}
@@ -0,0 +1,354 @@
+package test
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 同一个资源的数据必须按顺序执行, 不同资源之间并行
+*
+ */
+func Test_queue_worker_order(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	core.GlobalConfig.WorkerPoolSize = 4
+	engine.Start()
+
+	lock := sync.Mutex{}
+	received := map[string][]string{}
+	inEnds := []*typex.InEnd{}
+	for i := 0; i < 4; i++ {
+		in := typex.NewInEnd(typex.HTTP, "in"+strconv.Itoa(i), "", map[string]interface{}{})
+		rule := typex.NewRule(engine, "rule"+strconv.Itoa(i), "", "", []string{}, []string{},
+			`function Success() end`,
+			`Actions = { function(data) rulexlib:Record(data) return true, data end }`,
+			`function Failed(error) end`)
+		if err := core.VerifyCallback(rule); err != nil {
+			t.Fatal(err)
+		}
+		rule.AddLib(engine, "Record", func(in *typex.InEnd) func(l *lua.LState) int {
+			return func(l *lua.LState) int {
+				lock.Lock()
+				received[in.UUID] = append(received[in.UUID], l.ToString(2))
+				lock.Unlock()
+				return 0
+			}
+		}(in))
+		rule.SetVMPoolSize(4)
+		in.BindRule(rule)
+		inEnds = append(inEnds, in)
+	}
+	for n := 0; n < 100; n++ {
+		for _, in := range inEnds {
+			if err := engine.PushInQueue(in, strconv.Itoa(n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	t.Log(typex.DefaultDataCacheQueue.Statistics())
+	for _, in := range inEnds {
+		if len(received[in.UUID]) != 100 {
+			t.Fatalf("%v received %v messages", in.UUID, len(received[in.UUID]))
+		}
+		for n, v := range received[in.UUID] {
+			if v != strconv.Itoa(n) {
+				t.Fatalf("%v out of order: %v != %v", in.UUID, v, n)
+			}
+		}
+	}
+}
+
+/*
+*
+* 虚拟机池: 并发取出来的虚拟机互不相同, 并且都加载了脚本
+*
+ */
+func Test_rule_vm_pool(t *testing.T) {
+	rule := typex.NewRule(nil, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) return true, data end }`,
+		`function Failed(error) end`)
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+	rule.SetVMPoolSize(3)
+	vms := map[*lua.LState]bool{}
+	for i := 0; i < 3; i++ {
+		vm, err := rule.AcquireVM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm.GetGlobal(core.ACTIONS_KEY).Type() != lua.LTTable {
+			t.Fatal("'Actions' not loaded")
+		}
+		vms[vm] = true
+	}
+	if len(vms) != 3 {
+		t.Fatal("vm reused while still acquired")
+	}
+	for vm := range vms {
+		rule.ReleaseVM(vm)
+	}
+}
+
+//
+// 第一个数据阻塞在 worker 里面, 后面的数据按溢出策略处理
+//
+func queueOverflowCase(t *testing.T, policy string) ([]string, typex.QueueStatistics) {
+	engine := TestEngine()
+	defer engine.Stop()
+	typex.StartQueue(engine, typex.QueueConfig{
+		MaxQueueSize:   1,
+		Workers:        1,
+		OverflowPolicy: policy,
+		BlockTimeout:   10 * time.Millisecond,
+		SpillPath:      "./rulex-test-spill",
+	})
+	gate := make(chan struct{})
+	lock := sync.Mutex{}
+	received := []string{}
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) rulexlib:Record(data) return true, data end }`,
+		`function Failed(error) end`)
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		<-gate
+		lock.Lock()
+		received = append(received, l.ToString(2))
+		lock.Unlock()
+		return 0
+	})
+	in.BindRule(rule)
+	engine.PushInQueue(in, "0")
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i < 5; i++ {
+		engine.PushInQueue(in, strconv.Itoa(i))
+	}
+	close(gate)
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	return received, typex.DefaultDataCacheQueue.Statistics()
+}
+
+func Test_queue_overflow_policy(t *testing.T) {
+	defer os.RemoveAll("./rulex-test-spill")
+	received, s := queueOverflowCase(t, typex.QUEUE_OVERFLOW_REJECT)
+	if len(received) != 2 || s.Rejected != 3 {
+		t.Fatal("reject:", received, s)
+	}
+	received, s = queueOverflowCase(t, typex.QUEUE_OVERFLOW_DROP_OLDEST)
+	if len(received) != 3 || received[1] != "1" || received[2] != "4" || s.DroppedOldest != 2 {
+		t.Fatal("drop_oldest:", received, s)
+	}
+	received, s = queueOverflowCase(t, typex.QUEUE_OVERFLOW_BLOCK)
+	if len(received) != 2 || s.BlockTimeouts != 3 {
+		t.Fatal("block:", received, s)
+	}
+	received, s = queueOverflowCase(t, typex.QUEUE_OVERFLOW_SPILL)
+	if len(received) != 5 || s.Spilled != 3 {
+		t.Fatal("spill:", received, s)
+	}
+	for i, v := range received {
+		if v != strconv.Itoa(i) {
+			t.Fatal("spill out of order:", received)
+		}
+	}
+}
+
+/*
+*
+* 通道是共享的: 磁盘上有积压的时候, 不落盘的 InEnd 还是按自己的策略处理
+*
+ */
+func Test_queue_spill_shared_lane(t *testing.T) {
+	defer os.RemoveAll("./rulex-test-spill-shared")
+	engine := TestEngine()
+	defer engine.Stop()
+	typex.StartQueue(engine, typex.QueueConfig{
+		MaxQueueSize:   2,
+		Workers:        1,
+		OverflowPolicy: typex.QUEUE_OVERFLOW_REJECT,
+		SpillPath:      "./rulex-test-spill-shared",
+	})
+	gate := make(chan struct{})
+	lock := sync.Mutex{}
+	received := []string{}
+	spillIn := typex.NewInEnd(typex.HTTP, "spill", "", map[string]interface{}{
+		typex.INEND_OVERFLOW_POLICY_KEY: typex.QUEUE_OVERFLOW_SPILL,
+	})
+	rejectIn := typex.NewInEnd(typex.HTTP, "reject", "", map[string]interface{}{})
+	engine.SaveInEnd(spillIn)
+	engine.SaveInEnd(rejectIn)
+	rule := typex.NewRule(engine, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) rulexlib:Record(data) return true, data end }`,
+		`function Failed(error) end`)
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		<-gate
+		lock.Lock()
+		received = append(received, l.ToString(2))
+		lock.Unlock()
+		return 0
+	})
+	spillIn.BindRule(rule)
+	rejectIn.BindRule(rule)
+	engine.PushInQueue(spillIn, "0")
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i < 4; i++ {
+		engine.PushInQueue(spillIn, strconv.Itoa(i))
+	}
+	engine.PushInQueue(rejectIn, "x")
+	s := typex.DefaultDataCacheQueue.Statistics()
+	if s.Spilled != 1 || s.Rejected != 1 {
+		t.Fatal(s)
+	}
+	close(gate)
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 4 || received[3] != "3" {
+		t.Fatal(received)
+	}
+}
+
+/*
+*
+* drop_oldest 只丢自己的旧数据, 不会挤掉同一个通道里别的资源的数据
+*
+ */
+func Test_queue_drop_oldest_shared_lane(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	typex.StartQueue(engine, typex.QueueConfig{
+		MaxQueueSize:   2,
+		Workers:        1,
+		OverflowPolicy: typex.QUEUE_OVERFLOW_REJECT,
+	})
+	gate := make(chan struct{})
+	lock := sync.Mutex{}
+	received := []string{}
+	dropIn := typex.NewInEnd(typex.HTTP, "drop", "", map[string]interface{}{
+		typex.INEND_OVERFLOW_POLICY_KEY: typex.QUEUE_OVERFLOW_DROP_OLDEST,
+	})
+	otherIn := typex.NewInEnd(typex.HTTP, "other", "", map[string]interface{}{})
+	engine.SaveInEnd(dropIn)
+	engine.SaveInEnd(otherIn)
+	rule := typex.NewRule(engine, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) rulexlib:Record(data) return true, data end }`,
+		`function Failed(error) end`)
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		<-gate
+		lock.Lock()
+		received = append(received, l.ToString(2))
+		lock.Unlock()
+		return 0
+	})
+	dropIn.BindRule(rule)
+	otherIn.BindRule(rule)
+	engine.PushInQueue(dropIn, "0")
+	time.Sleep(50 * time.Millisecond)
+	engine.PushInQueue(otherIn, "x")
+	for i := 1; i < 5; i++ {
+		engine.PushInQueue(dropIn, strconv.Itoa(i))
+	}
+	s := typex.DefaultDataCacheQueue.Statistics()
+	if s.DroppedOldest != 1 || s.DropDepth != 2 {
+		t.Fatal(s)
+	}
+	close(gate)
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(received, ",") != "0,x,1,3,4" {
+		t.Fatal(received)
+	}
+}
+
+func Test_queue_unknown_overflow_policy(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	if err := typex.StartQueue(engine, typex.QueueConfig{
+		MaxQueueSize:   2,
+		OverflowPolicy: "drop_newest",
+	}); err == nil {
+		t.Fatal("unknown policy accepted")
+	}
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{
+		typex.INEND_OVERFLOW_POLICY_KEY: "drop_newest",
+		"port":                          2591,
+	})
+	if err := engine.LoadInEnd(in); err == nil {
+		t.Fatal("unknown InEnd policy accepted")
+	}
+}
+
+/*
+*
+* 信封: Actions 的第二个参数是元数据, 老的单参数脚本不受影响
+*
+ */
+func Test_envelope_meta(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.MQTT, "in", "", map[string]interface{}{})
+	rule := typex.NewRule(engine, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data, meta)
+				rulexlib:Record(data, meta.origin, meta.originType, meta.topic, meta.headers.qos)
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []string, 1)
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		done <- []string{l.ToString(2), l.ToString(3), l.ToString(4), l.ToString(5), l.ToString(6)}
+		return 0
+	})
+	in.BindRule(rule)
+	envelope := typex.NewEnvelope([]byte("hello")).SetHeader("qos", "1")
+	envelope.Topic = "rulex/test"
+	if _, err := engine.WorkInEndEnvelope(in, envelope); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-done:
+		expect := []string{"hello", in.UUID, typex.ORIGIN_INEND, "rulex/test", "1"}
+		for i := range expect {
+			if v[i] != expect[i] {
+				t.Fatal(v)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 暂停 InEnd 以后数据直接丢弃, 恢复以后继续处理
+*
+ */
+func Test_pause_inend(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	statistics.ResetMetrics()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	in.SetState(typex.SOURCE_UP)
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "pause", "pause", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data) return true, data end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.PauseInEnd(in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if !in.Paused() {
+		t.Fatal("inend should be paused")
+	}
+	engine.PushInQueue(in, "dropped")
+	time.Sleep(100 * time.Millisecond)
+	if m := statistics.GetMetrics(statistics.RULE, rule.UUID); m != nil && m.Messages != 0 {
+		t.Fatal(m)
+	}
+	if err := engine.ResumeInEnd(in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if in.Paused() {
+		t.Fatal("inend should be resumed")
+	}
+	engine.PushInQueue(in, "accepted")
+	time.Sleep(100 * time.Millisecond)
+	if m := statistics.GetMetrics(statistics.RULE, rule.UUID); m == nil || m.Messages != 1 {
+		t.Fatal(m)
+	}
+	if err := engine.PauseInEnd("not-exists"); err == nil {
+		t.Fatal("pause should fail for unknown inend")
+	}
+}
+
+/*
+*
+* 暂停 OutEnd 以后数据进缓存, 恢复以后补发
+*
+ */
+func Test_pause_outend(t *testing.T) {
+	dir := "./" + GenDate() + "-pause"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	target := &flakyTarget{up: true}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "paused", "", map[string]interface{}{
+		typex.OUTEND_BUFFER_CONFIG_KEY: map[string]interface{}{"enable": true},
+	})
+	out.Target = target
+	engine.SaveOutEnd(out)
+	if err := typex.StartOutEndBuffer(out, dir); err != nil {
+		t.Fatal(err)
+	}
+	defer typex.StopOutEndBuffer(out)
+	if err := engine.PauseOutEnd(out.UUID); err != nil {
+		t.Fatal(err)
+	}
+	// 暂停的时候目标不停, 连接保持
+	if target.Status() != typex.SOURCE_UP {
+		t.Fatal("target should keep running")
+	}
+	// 守护协程同步状态不会覆盖暂停
+	if out.UpdateState(typex.SOURCE_UP) || !out.Paused() {
+		t.Fatal("supervisor should not resume a paused outend")
+	}
+	for i := 0; i < 3; i++ {
+		typex.DeliverToOutEnd(out, strconv.Itoa(i))
+	}
+	// 暂停期间不补发
+	time.Sleep(1500 * time.Millisecond)
+	target.lock.Lock()
+	received := len(target.received)
+	target.lock.Unlock()
+	if received != 0 || out.BufferStatus().Messages != 3 {
+		t.Fatalf("received %v, buffered %v", received, out.BufferStatus().Messages)
+	}
+	if err := engine.ResumeOutEnd(out.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if out.GetState() != typex.SOURCE_UP {
+		t.Fatal("outend state:", out.GetState())
+	}
+	time.Sleep(1500 * time.Millisecond)
+	target.lock.Lock()
+	defer target.lock.Unlock()
+	if len(target.received) != 3 {
+		t.Fatal(target.received)
+	}
+}
+
+/*
+*
+* 没有缓存的 OutEnd 暂停以后: 规则推数据直接拒绝, 已经在队列里的数据排队, 恢复以后按顺序投递
+*
+ */
+func Test_pause_outend_without_buffer(t *testing.T) {
+	dir := "./" + GenDate() + "-pause-nobuffer"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	if err := typex.StartDeadLetterStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	target := &flakyTarget{up: true}
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "paused", "", map[string]interface{}{
+		typex.OUTEND_RETRY_CONFIG_KEY: map[string]interface{}{"initialInterval": 20},
+	})
+	out.Target = target
+	engine.SaveOutEnd(out)
+	if err := engine.PauseOutEnd(out.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.PushOutQueue(out, "rejected"); err == nil {
+		t.Fatal("paused outend without buffer should reject data")
+	}
+	for i := 0; i < 3; i++ {
+		typex.DeliverToOutEnd(out, strconv.Itoa(i))
+	}
+	time.Sleep(100 * time.Millisecond)
+	target.lock.Lock()
+	received := len(target.received)
+	target.lock.Unlock()
+	if received != 0 || typex.DefaultDeadLetters.Len() != 0 {
+		t.Fatal(received, typex.DefaultDeadLetters.Len())
+	}
+	if err := engine.ResumeOutEnd(out.UUID); err != nil {
+		t.Fatal(err)
+	}
+	typex.DeliverToOutEnd(out, "3")
+	time.Sleep(100 * time.Millisecond)
+	target.lock.Lock()
+	defer target.lock.Unlock()
+	if len(target.received) != 4 || target.received[3] != "3" {
+		t.Fatal(target.received)
+	}
+}
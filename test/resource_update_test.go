@@ -0,0 +1,86 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/i4de/rulex/source"
+	"github.com/i4de/rulex/target"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 更新配置之前先用资源的配置结构体校验
+*
+ */
+func Test_validate_resource_config(t *testing.T) {
+	if err := source.ValidateConfig(typex.HTTP, map[string]interface{}{}); err == nil {
+		t.Fatal("port is required")
+	}
+	if err := source.ValidateConfig(typex.HTTP, map[string]interface{}{"port": 2580}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.ValidateConfig("UNKNOWN", map[string]interface{}{}); err == nil {
+		t.Fatal("unknown type should fail")
+	}
+	if err := target.ValidateConfig(typex.HTTP_TARGET, map[string]interface{}{"url": "http://127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.ValidateConfig(typex.HTTP_TARGET, map[string]interface{}{"url": 1}); err == nil {
+		t.Fatal("url should be a string")
+	}
+}
+
+/*
+*
+* 重启以后 UUID 和规则绑定都保留
+*
+ */
+func Test_restart_inend_keep_bindings(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{"port": 27001})
+	if err := engine.LoadInEnd(in); err != nil {
+		t.Fatal(err)
+	}
+	rule := typex.NewRule(engine, "bind", "bind", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data) return true, data end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	supervisor := in.Supervisor
+	in.Config = map[string]interface{}{"port": 27002}
+	if err := engine.RestartInEnd(in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if engine.GetInEnd(in.UUID) != in || in.Supervisor == supervisor {
+		t.Fatal("inend should be restarted in place")
+	}
+	if _, ok := in.BindRules[rule.UUID]; !ok {
+		t.Fatal("rule binding lost")
+	}
+	engine.RemoveRule(rule.UUID)
+	engine.RemoveInEnd(in.UUID)
+}
+
+func Test_restart_outend(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	out := typex.NewOutEnd(typex.HTTP_TARGET, "out", "", map[string]interface{}{"url": "http://127.0.0.1:27003"})
+	if err := engine.LoadOutEnd(out); err != nil {
+		t.Fatal(err)
+	}
+	supervisor := out.Supervisor
+	out.Config = map[string]interface{}{"url": "http://127.0.0.1:27004"}
+	if err := engine.RestartOutEnd(out.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if engine.GetOutEnd(out.UUID) != out || out.Supervisor == supervisor {
+		t.Fatal("outend should be restarted in place")
+	}
+	engine.RemoveOutEnd(out.UUID)
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 试运行: 输出被拦截, 日志被收集, 错误带行号
+*
+ */
+func Test_rule_dry_run(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	core.GlobalStore.Delete("last")
+	results, err := engine.DryRunRule(
+		`function Success() rulexlib:log("success") end`,
+		`Actions = {
+			function(data)
+				print("step1", data)
+				return true, rulexlib:J2T(data)
+			end,
+			function(t)
+				if t.error then
+					error("bad data")
+				end
+				rulexlib:VSet("last", t.value)
+				rulexlib:DataToMqtt("OUTEND", rulexlib:T2J(t))
+				rulexlib:WriteDevice("DEVICE", rulexlib:VGet("last"))
+				return true, t
+			end
+		}`,
+		`function Failed(error) rulexlib:log(error) end`,
+		typex.RuleLimits{},
+		[]string{`{"value":"1"}`, `{"error":true}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatal(results)
+	}
+	ok := results[0]
+	if ok.Callback != core.SUCCESS_KEY || ok.Error != "" || len(ok.Steps) != 2 ||
+		ok.Steps[1].Output != `{"value":"1"}` {
+		t.Fatal(ok)
+	}
+	if len(ok.Outputs) != 2 || ok.Outputs[0].Lib != "DataToMqtt" || ok.Outputs[0].Target != "OUTEND" ||
+		ok.Outputs[1].Lib != "WriteDevice" || ok.Outputs[1].Data != "1" {
+		t.Fatal(ok.Outputs)
+	}
+	if len(ok.Logs) != 2 || ok.Logs[0] != `step1	{"value":"1"}` || ok.Logs[1] != "success" {
+		t.Fatal(ok.Logs)
+	}
+	bad := results[1]
+	if bad.Callback != core.FAILED_KEY || !strings.Contains(bad.Error, "<string>:8:") || len(bad.Outputs) != 0 {
+		t.Fatal(bad)
+	}
+	if core.GlobalStore.Get("last") != "" {
+		t.Fatal("dry run changed global store")
+	}
+	// 编解码不会调用真实的目标
+	results, err = engine.DryRunRule(`function Success() end`,
+		`Actions = {
+			function(data)
+				local r, err = rulexlib:RPCENC("CODEC", data)
+				rulexlib:log(err)
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`, typex.RuleLimits{}, []string{"raw"})
+	if err != nil || len(results[0].Outputs) != 1 || results[0].Outputs[0].Lib != "RPCENC" ||
+		results[0].Outputs[0].Data != "raw" || len(results[0].Logs) != 1 {
+		t.Fatal(results, err)
+	}
+	if _, err := engine.DryRunRule(`function Success() end`, `Actions = {`,
+		`function Failed(error) end`, typex.RuleLimits{}, []string{""}); err == nil {
+		t.Fatal("syntax error not reported")
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 规则超过限制: 调用被中断, Failed 收到原因, 计数增加, worker 不会卡死
+*
+ */
+func Test_rule_limits(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "rule", "", "", []string{in.UUID}, []string{},
+		`function Success() rulexlib:Record("ok") end`,
+		`Actions = {
+			function(data)
+				if data == "loop" then
+					while true do end
+				end
+				if data == "deep" then
+					local function f(n) return f(n + 1) + 1 end
+					f(1)
+				end
+				if data == "registry" then
+					local t = {}
+					for i = 1, 5000 do t[i] = i end
+					local x = { unpack(t) }
+				end
+				return true, data
+			end
+		}`,
+		`function Failed(error) rulexlib:Record(error) end`)
+	rule.SetLimits(typex.RuleLimits{Timeout: 100, CallStackSize: 32, RegistryMaxSize: 2048})
+	lock := sync.Mutex{}
+	received := []string{}
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		lock.Lock()
+		received = append(received, l.ToString(2))
+		lock.Unlock()
+		return 0
+	})
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"loop", "deep", "registry", "hello"} {
+		engine.PushInQueue(in, data)
+	}
+	time.Sleep(500 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 4 || received[3] != "ok" {
+		t.Fatal(received)
+	}
+	for i, reason := range []string{"timeout", "call stack", "registry"} {
+		if !strings.Contains(received[i], reason) {
+			t.Fatal(received[i])
+		}
+	}
+	if rule.Violations.Timeout != 1 || rule.Violations.CallStack != 1 || rule.Violations.Registry != 1 {
+		t.Fatal(rule.Violations)
+	}
+}
+
+/*
+*
+* 默认限制和以前一样
+*
+ */
+func Test_rule_default_limits(t *testing.T) {
+	rule := typex.NewRule(nil, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) return true, data end }`,
+		`function Failed(error) end`)
+	rule.SetLimits(typex.RuleLimits{})
+	if rule.Limits != typex.DefaultRuleLimits() {
+		t.Fatal(rule.Limits)
+	}
+	if err := core.VerifyCallback(rule); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+	"github.com/i4de/rulex/utils"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* cron 表达式的下一次触发时间
+*
+ */
+func Test_cron_next(t *testing.T) {
+	base := time.Date(2022, 6, 15, 10, 7, 30, 0, time.UTC) // 周三
+	cases := map[string]time.Time{
+		"* * * * *":      time.Date(2022, 6, 15, 10, 8, 0, 0, time.UTC),
+		"*/15 * * * *":   time.Date(2022, 6, 15, 10, 15, 0, 0, time.UTC),
+		"0 9-17/4 * * *": time.Date(2022, 6, 15, 13, 0, 0, 0, time.UTC),
+		"30 2 1,20 * *":  time.Date(2022, 6, 20, 2, 30, 0, 0, time.UTC),
+		"0 0 * * 0":      time.Date(2022, 6, 19, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":     time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 */5 * 1":    time.Date(2022, 7, 11, 0, 0, 0, 0, time.UTC),
+		"@hourly":        time.Date(2022, 6, 15, 11, 0, 0, 0, time.UTC),
+	}
+	for expr, expect := range cases {
+		cron, err := utils.ParseCron(expr)
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if next := cron.Next(base); !next.Equal(expect) {
+			t.Fatalf("%v: %v != %v", expr, next, expect)
+		}
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := utils.ParseCron(expr); err == nil {
+			t.Fatal("should be invalid:", expr)
+		}
+	}
+}
+
+/*
+*
+* 固定间隔的定时规则: 不需要输入数据, 记录执行次数和失败
+*
+ */
+func Test_rule_schedule(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	rule := typex.NewRule(engine, "rule", "", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data, meta)
+				rulexlib:Record(data, meta.originType)
+				error("always failed")
+			end
+		}`,
+		`function Failed(error) end`)
+	rule.Schedule = &typex.RuleSchedule{Interval: 1, Payload: "tick"}
+	lock := sync.Mutex{}
+	received := []string{}
+	rule.AddLib(engine, "Record", func(l *lua.LState) int {
+		lock.Lock()
+		received = append(received, l.ToString(2)+"@"+l.ToString(3))
+		lock.Unlock()
+		return 0
+	})
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	engine.RemoveRule(rule.UUID)
+	time.Sleep(1200 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 2 || received[0] != "tick@"+typex.ORIGIN_SCHEDULE {
+		t.Fatal(received)
+	}
+	b, _ := json.Marshal(rule.Schedule.Status)
+	status := struct {
+		Runs      int    `json:"runs"`
+		Failures  int    `json:"failures"`
+		LastError string `json:"lastError"`
+	}{}
+	json.Unmarshal(b, &status)
+	if status.Runs != 2 || status.Failures != 2 || !strings.Contains(status.LastError, "always failed") {
+		t.Fatal(string(b))
+	}
+	bad := &typex.RuleSchedule{Cron: "* * *"}
+	if bad.Validate() == nil {
+		t.Fatal("invalid cron accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 停用规则以后不再执行, 启用以后恢复, 绑定关系不变
+*
+ */
+func Test_start_stop_rule(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	statistics.ResetMetrics()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "status", "status", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {function(data) return true, data end}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.StopRule(rule.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if rule.GetStatus() != typex.RULE_STOP || in.BoundRules()[0].GetStatus() != typex.RULE_STOP {
+		t.Fatal("rule should be stopped")
+	}
+	engine.PushInQueue(in, "skipped")
+	time.Sleep(100 * time.Millisecond)
+	if m := statistics.GetMetrics(statistics.RULE, rule.UUID); m != nil && m.Messages != 0 {
+		t.Fatal(m)
+	}
+	if err := engine.StartRule(rule.UUID); err != nil {
+		t.Fatal(err)
+	}
+	engine.PushInQueue(in, "executed")
+	time.Sleep(100 * time.Millisecond)
+	if m := statistics.GetMetrics(statistics.RULE, rule.UUID); m == nil || m.Messages != 1 {
+		t.Fatal(m)
+	}
+	if err := engine.StopRule("not-exists"); err == nil {
+		t.Fatal("stop should fail for unknown rule")
+	}
+	engine.RemoveRule(rule.UUID)
+}
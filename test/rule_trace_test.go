@@ -0,0 +1,102 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 规则追踪: 只追踪 N 条消息, 记录输入、每一步结果、回调和库函数调用
+*
+ */
+func Test_rule_trace(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "rule", "", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data)
+				rulexlib:VSet("trace", data)
+				return true, data .. "!"
+			end,
+			function(data)
+				if data == "bad!" then
+					error("bad data")
+				end
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	streamed := make(chan typex.TraceRecord, 10)
+	typex.DefaultRuleTracer.OnRecord(func(r typex.TraceRecord) {
+		if r.RuleUUID == rule.UUID {
+			streamed <- r
+		}
+	})
+	if _, err := typex.DefaultRuleTracer.Start(rule.UUID, 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"ok", "bad", "ignored"} {
+		engine.PushInQueue(in, data)
+	}
+	time.Sleep(300 * time.Millisecond)
+	records := typex.DefaultRuleTracer.Records(rule.UUID)
+	if len(records) != 2 || len(streamed) != 2 {
+		t.Fatal(records)
+	}
+	ok := records[0]
+	if ok.Input != "ok" || ok.Callback != core.SUCCESS_KEY || len(ok.Steps) != 2 ||
+		ok.Steps[0].Output != "ok!" || len(ok.Calls) != 1 || ok.Calls[0].Lib != "VSet" {
+		t.Fatal(ok)
+	}
+	bad := records[1]
+	if bad.Callback != core.FAILED_KEY || bad.Error == "" || len(bad.Steps) != 2 || bad.Steps[1].Error == "" {
+		t.Fatal(bad)
+	}
+	if len(typex.DefaultRuleTracer.Sessions()) != 0 {
+		t.Fatal("trace session should be finished")
+	}
+	// 删除规则的时候追踪记录一起删掉
+	engine.RemoveRule(rule.UUID)
+	if len(typex.DefaultRuleTracer.Records(rule.UUID)) != 0 {
+		t.Fatal("trace records not removed with rule")
+	}
+}
+
+/*
+*
+* 慢的监听者不会阻塞执行, 缓冲满了丢弃
+*
+ */
+func Test_rule_trace_slow_listener(t *testing.T) {
+	tracer := typex.NewRuleTracer()
+	gate := make(chan struct{})
+	defer close(gate)
+	tracer.OnRecord(func(r typex.TraceRecord) {
+		<-gate
+	})
+	if _, err := tracer.Start("rule", 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < typex.TRACE_LISTENER_BUFFER+10; i++ {
+		tracer.Finish(tracer.Begin("rule", typex.NewEnvelope([]byte("data"))))
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("finish blocked by listener")
+	}
+	// 第一条被监听协程取走了, 阻塞在 gate 上
+	if tracer.Dropped() < 9 {
+		t.Fatal(tracer.Dropped())
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 影子: 上报值和期望值的差异, 重启以后从磁盘恢复
+*
+ */
+func Test_shadow_store(t *testing.T) {
+	dir := "./" + GenDate() + "-shadow"
+	defer os.RemoveAll(dir)
+	if err := typex.StartShadowStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	store := typex.DefaultShadows
+	store.Report("dev1", map[string]interface{}{"switch": "on", "temp": 20.5})
+	shadow := store.Desire("dev1", map[string]interface{}{"switch": "off", "temp": "20.5"})
+	delta := shadow.Delta()
+	if len(delta) != 1 || delta["switch"] != "off" || shadow.Version != 2 {
+		t.Fatal(shadow, delta)
+	}
+	// null 表示删除期望值
+	shadow = store.Desire("dev1", map[string]interface{}{"switch": nil})
+	if len(shadow.Delta()) != 0 || len(shadow.Desired) != 1 {
+		t.Fatal(shadow)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := typex.StartShadowStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer typex.DefaultShadows.Close()
+	shadow, ok := typex.DefaultShadows.Get("dev1")
+	if !ok || shadow.Reported["switch"].Value != "on" || shadow.Reported["temp"].Timestamp.IsZero() {
+		t.Fatal(shadow)
+	}
+}
+
+/*
+*
+* 期望值通过规则下发, 规则处理完以后上报, 差异消失
+*
+ */
+func Test_shadow_delta_to_rule(t *testing.T) {
+	dir := "./" + GenDate() + "-shadow-rule"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	if err := typex.StartShadowStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	in.DataModelsMap = map[string]typex.XDataModel{"switch": {Name: "switch"}}
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "shadow", "shadow", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data, meta)
+				if meta.topic == "$shadow/delta" then
+					local err = rulexlib:ReportShadow(meta.origin, rulexlib:J2T(data))
+					if err ~= nil then error(err) end
+				end
+				local err = rulexlib:SetModelValue(meta.origin, "unknown", 1)
+				if err == nil then error("unknown model should fail") end
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	typex.DefaultShadows.Desire(in.UUID, map[string]interface{}{"switch": "on"})
+	if err := typex.DefaultShadows.DeliverDelta(engine, in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	shadow, _ := typex.DefaultShadows.Get(in.UUID)
+	if len(shadow.Delta()) != 0 || shadow.Reported["switch"].Value != "on" {
+		t.Fatal(shadow)
+	}
+	engine.RemoveRule(rule.UUID)
+}
+
+/*
+*
+* 暂停的时候下发失败, 恢复以后重发
+*
+ */
+func Test_shadow_redeliver(t *testing.T) {
+	dir := "./" + GenDate() + "-shadow-redeliver"
+	defer os.RemoveAll(dir)
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	if err := typex.StartShadowStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+	rule := typex.NewRule(engine, "shadow-redeliver", "shadow-redeliver", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data, meta)
+				if meta.topic == "$shadow/delta" then
+					local err = rulexlib:ReportShadow(meta.origin, rulexlib:J2T(data))
+					if err ~= nil then error(err) end
+				end
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	if err := engine.LoadRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.RemoveRule(rule.UUID)
+	if err := engine.PauseInEnd(in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	typex.DefaultShadows.Desire(in.UUID, map[string]interface{}{"switch": "on"})
+	if err := typex.DefaultShadows.DeliverDelta(engine, in.UUID); err == nil {
+		t.Fatal("deliver to paused inend should fail")
+	}
+	if err := engine.ResumeInEnd(in.UUID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	shadow, _ := typex.DefaultShadows.Get(in.UUID)
+	if len(shadow.Delta()) != 0 {
+		t.Fatal(shadow)
+	}
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/i4de/rulex/sqlrule"
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* SQL 规则: 编译成 Actions 以后过滤、投影, 再转发到多个 OutEnd
+*
+ */
+func Test_sql_rule(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	program, err := sqlrule.Compile(`SELECT payload.temp AS t, deviceId, round(payload.temp * 1.8 + 32, 1) AS f,
+		upper(payload.tags[0]) AS tag FROM "INEND1", "DEVICE1"
+		WHERE payload.temp > 30 AND (deviceId IN ('d1', 'd2') OR payload.force = true) AND payload.skip IS NULL`,
+		[]string{"OUT1", "OUT2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.From) != 2 || program.From[0] != "INEND1" || program.From[1] != "DEVICE1" ||
+		len(program.Fields) != 4 || program.Fields[1].Name != "deviceId" {
+		t.Fatal(program.Statement)
+	}
+	results, err := engine.DryRunRule(`function Success() end`, program.Actions,
+		`function Failed(error) rulexlib:log(error) end`, typex.RuleLimits{},
+		[]string{
+			`{"temp":35,"deviceId":"d1","tags":["hot"]}`,
+			`{"temp":20,"deviceId":"d1"}`,
+			`{"temp":40,"deviceId":"d3","force":true}`,
+			`{"temp":40,"deviceId":"d1","skip":1}`,
+			`{"deviceId":"d1"}`,
+			`not json`,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := results[0].Outputs
+	if len(first) != 2 || first[0].Lib != "DataToOutEnd" || first[0].Target != "OUT1" || first[1].Target != "OUT2" ||
+		first[0].Data != `{"deviceId":"d1","f":95,"t":35,"tag":"HOT"}` {
+		t.Fatal(results[0])
+	}
+	if len(results[2].Outputs) != 2 || results[2].Outputs[0].Data != `{"deviceId":"d3","f":104,"t":40}` {
+		t.Fatal(results[2])
+	}
+	for _, i := range []int{1, 3, 4, 5} {
+		if len(results[i].Outputs) != 0 || results[i].Error != "" {
+			t.Fatal(i, results[i])
+		}
+	}
+	// SELECT * 原样转发
+	program, err = sqlrule.Compile(`select * from INEND1 where meta.nothing is null and not payload.v <> 'a''b'`, []string{"OUT1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = engine.DryRunRule(`function Success() end`, program.Actions,
+		`function Failed(error) end`, typex.RuleLimits{}, []string{`{"v":"a'b"}`, `{"v":"c"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[0].Outputs) != 1 || results[0].Outputs[0].Data != `{"v":"a'b"}` || len(results[1].Outputs) != 0 {
+		t.Fatal(results)
+	}
+	for _, bad := range []string{
+		`SELECT FROM "INEND1"`,
+		`SELECT a FROM`,
+		`SELECT a, a FROM "INEND1"`,
+		`SELECT a FROM "INEND1" WHERE`,
+		`SELECT a FROM "INEND1" WHERE a >`,
+		`SELECT foo(a) FROM "INEND1"`,
+		`SELECT 'a FROM "INEND1"`,
+		`UPDATE a FROM "INEND1"`,
+	} {
+		if _, err := sqlrule.Compile(bad, []string{"OUT1"}); err == nil {
+			t.Fatal("invalid sql accepted:", bad)
+		}
+	}
+	if _, err := sqlrule.Compile(`SELECT * FROM "INEND1"`, nil); err == nil {
+		t.Fatal("sql rule without outEnd accepted")
+	}
+}
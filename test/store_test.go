@@ -1,9 +1,12 @@
 package test
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/i4de/rulex/core"
+	"github.com/i4de/rulex/store"
 )
 
 func Test_get_set(t *testing.T) {
@@ -11,3 +14,70 @@ func Test_get_set(t *testing.T) {
 	core.GlobalStore.Set("k", "v")
 	t.Log(core.GlobalStore.Get("k"))
 }
+
+/*
+*
+* 过期时间
+*
+ */
+func Test_store_ttl(t *testing.T) {
+	s := store.NewRulexStore(16)
+	s.SetWithTTL("k", "v", 50*time.Millisecond)
+	s.Set("forever", "v")
+	if s.Get("k") != "v" {
+		t.Fatal("value should exist")
+	}
+	time.Sleep(80 * time.Millisecond)
+	if s.Get("k") != "" || s.Count() != 1 {
+		t.Fatal("value should expire", s.Count())
+	}
+}
+
+/*
+*
+* 超过最大数量淘汰最久没用的值
+*
+ */
+func Test_store_lru(t *testing.T) {
+	s := store.NewRulexStore(2)
+	s.Set("a", "1")
+	s.Set("b", "2")
+	s.Get("a")
+	s.Set("c", "3")
+	if s.Count() != 2 || s.Get("b") != "" || s.Get("a") != "1" || s.Get("c") != "3" {
+		t.Fatal("b should be evicted")
+	}
+}
+
+func Test_store_fuzzy_get(t *testing.T) {
+	s := store.NewRulexStore(0)
+	for _, k := range []string{"AAA", "xAAA", "AAAx", "AxB", "AB", "BA"} {
+		s.Set(k, "v")
+	}
+	cases := map[string][]string{
+		"*AAA": {"AAA", "xAAA"},
+		"AAA*": {"AAA", "AAAx"},
+		"A*B":  {"AB", "AxB"},
+		"*":    {"AAA", "AAAx", "AB", "AxB", "BA", "xAAA"},
+		"BA":   {"BA"},
+	}
+	for pattern, expect := range cases {
+		if keys := s.FuzzyGet(pattern); !reflect.DeepEqual(keys, expect) {
+			t.Fatal(pattern, keys)
+		}
+	}
+}
+
+func Test_store_incr(t *testing.T) {
+	s := store.NewRulexStore(0)
+	if v, err := s.Incr("n", 1); err != nil || v != 1 {
+		t.Fatal(v, err)
+	}
+	if v, err := s.Incr("n", 5); err != nil || v != 6 || s.Get("n") != "6" {
+		t.Fatal(v, err)
+	}
+	s.Set("s", "abc")
+	if _, err := s.Incr("s", 1); err == nil {
+		t.Fatal("incr should fail on string")
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 资源守护: 指数退避, 连续失败熔断, 冷却以后恢复
+*
+ */
+func Test_supervisor_backoff(t *testing.T) {
+	policy := typex.RestartPolicy{
+		Interval:    100 * time.Millisecond,
+		MaxInterval: time.Second,
+		Multiplier:  2,
+	}
+	for failures, want := range []time.Duration{100, 100, 200, 400, 800, 1000, 1000} {
+		if d := policy.Backoff(failures); d != want*time.Millisecond {
+			t.Fatalf("backoff(%v) = %v", failures, d)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := policy.Backoff(2); d < 100*time.Millisecond || d > 300*time.Millisecond {
+			t.Fatal("jitter out of range:", d)
+		}
+	}
+	config := typex.RulexConfig{SourceRestartInterval: 1000, SourceRestartJitter: 0.1, SourceRestartMaxAttempts: 0}
+	if p := typex.NewRestartPolicy(config); p.Interval != time.Second || p.MaxAttempts != 0 ||
+		p.Jitter != 0.1 || p.MaxInterval != time.Minute {
+		t.Fatal(p)
+	}
+}
+
+func Test_supervisor_circuit_breaker(t *testing.T) {
+	s := typex.NewSupervisor(typex.RestartPolicy{
+		Interval:    10 * time.Millisecond,
+		MaxInterval: 10 * time.Millisecond,
+		Multiplier:  2,
+		MaxAttempts: 3,
+		Cooldown:    100 * time.Millisecond,
+	})
+	var up, restarts, failed int32
+	hooks := typex.SupervisorHooks{
+		Alive:   func() bool { return true },
+		Healthy: func() bool { return atomic.LoadInt32(&up) == 1 },
+		Restart: func() error {
+			atomic.AddInt32(&restarts, 1)
+			return errors.New("connection refused")
+		},
+		Failed: func() { atomic.AddInt32(&failed, 1) },
+	}
+	for i := 0; i < 3; i++ {
+		s.Check(hooks)
+	}
+	if !s.Failed() || failed != 1 || restarts != 3 {
+		t.Fatalf("failed=%v restarts=%v", failed, restarts)
+	}
+	// 熔断期间不重启
+	s.Check(hooks)
+	if restarts != 3 {
+		t.Fatal("restarted while circuit is open")
+	}
+	b, _ := json.Marshal(s)
+	if !strings.Contains(string(b), `"lastError":"connection refused"`) || !strings.Contains(string(b), `"restarts":3`) {
+		t.Fatal(string(b))
+	}
+	// 冷却以后再试一次, 成功就恢复
+	time.Sleep(120 * time.Millisecond)
+	hooks.Restart = func() error {
+		atomic.AddInt32(&restarts, 1)
+		atomic.StoreInt32(&up, 1)
+		return nil
+	}
+	s.Check(hooks)
+	if s.Failed() || restarts != 4 || s.Restarts() != 4 {
+		t.Fatalf("not recovered: restarts=%v", restarts)
+	}
+
+	// 守护协程: 资源删除以后退出
+	var alive int32 = 1
+	var checks int32
+	loop := typex.NewSupervisor(typex.RestartPolicy{Interval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond})
+	loop.Start(typex.SupervisorHooks{
+		Alive: func() bool { return atomic.LoadInt32(&alive) == 1 },
+		Healthy: func() bool {
+			atomic.AddInt32(&checks, 1)
+			return true
+		},
+		Restart: func() error { return nil },
+	})
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&alive, 0)
+	time.Sleep(30 * time.Millisecond)
+	n := atomic.LoadInt32(&checks)
+	if n < 3 {
+		t.Fatal("supervisor did not check:", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&checks) != n {
+		t.Fatal("supervisor still running after resource removed")
+	}
+}
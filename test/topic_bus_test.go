@@ -0,0 +1,113 @@
+package test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/i4de/rulex/typex"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 内部主题: decode -> enrich 多级处理, enrich 再发回 decoded 形成环路要被丢弃
+*
+ */
+func Test_topic_bus(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	typex.DefaultTopicBus = typex.NewTopicBus()
+	in := typex.NewInEnd(typex.HTTP, "in", "", map[string]interface{}{})
+	engine.SaveInEnd(in)
+
+	decode := typex.NewRule(engine, "decode", "decode", "", []string{in.UUID}, []string{},
+		`function Success() end`,
+		`Actions = { function(data) rulexlib:Publish("decoded", data .. "|decoded") return true, data end }`,
+		`function Failed(error) end`)
+	enrich := typex.NewRule(engine, "enrich", "enrich", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data, meta)
+				rulexlib:Record(data, meta.originType, meta.topic)
+				rulexlib:Publish("enriched", data .. "|enriched")
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	enrich.FromTopic = []string{"decoded"}
+	loop := typex.NewRule(engine, "loop", "loop", "", []string{}, []string{},
+		`function Success() end`,
+		`Actions = {
+			function(data)
+				rulexlib:Record(data, rulexlib:Publish("decoded", data))
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`)
+	loop.FromTopic = []string{"enriched"}
+
+	lock := sync.Mutex{}
+	received := [][]string{}
+	record := func(l *lua.LState) int {
+		lock.Lock()
+		received = append(received, []string{l.ToString(2), l.ToString(3), l.ToString(4)})
+		lock.Unlock()
+		return 0
+	}
+	for _, r := range []*typex.Rule{decode, enrich, loop} {
+		if err := engine.LoadRule(r); err != nil {
+			t.Fatal(err)
+		}
+		r.AddLib(engine, "Record", record)
+	}
+	if err := engine.PushInQueue(in, "raw"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 2 {
+		t.Fatal(received)
+	}
+	if received[0][0] != "raw|decoded" || received[0][1] != typex.ORIGIN_TOPIC || received[0][2] != "decoded" {
+		t.Fatal(received[0])
+	}
+	if received[1][0] != "raw|decoded|enriched" || received[1][1] == "" {
+		t.Fatal("loop not detected:", received[1])
+	}
+	stats := typex.DefaultTopicBus.Statistics()
+	if len(stats) != 2 || stats[0].Topic != "decoded" || stats[0].Delivered != 1 || stats[0].Loops != 1 {
+		t.Fatal(stats)
+	}
+	engine.RemoveRule(enrich.UUID)
+	if len(typex.DefaultTopicBus.Subscribers("decoded")) != 0 {
+		t.Fatal("rule still subscribed")
+	}
+}
+
+/*
+*
+* 没有订阅者的主题统计有上限, 不会随着主题名一直涨
+*
+ */
+func Test_topic_bus_idle_counters(t *testing.T) {
+	bus := typex.NewTopicBus()
+	for i := 0; i < 1000; i++ {
+		if err := bus.Publish(nil, nil, "idle-"+strconv.Itoa(i), "data"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := bus.Statistics()
+	if len(stats) != 256 {
+		t.Fatal(len(stats))
+	}
+	for _, s := range stats {
+		if s.Published != 1 || s.NoSubscriber != 1 {
+			t.Fatal(s)
+		}
+	}
+}
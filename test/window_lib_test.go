@@ -0,0 +1,66 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/i4de/rulex/typex"
+)
+
+/*
+*
+* 窗口聚合: 用试运行执行脚本, 时间戳由数据带进来
+*
+ */
+func Test_window_lib(t *testing.T) {
+	engine := TestEngine()
+	defer engine.Stop()
+	engine.Start()
+	results, err := engine.DryRunRule(`function Success() end`,
+		`Actions = {
+			function(data)
+				local d = rulexlib:J2T(data)
+				local r = rulexlib:TumblingWindow("t", d.v, 60, d.ts)
+				if r ~= nil then
+					rulexlib:log("T " .. r.count .. " " .. r.avg .. " " .. r.min .. " " .. r.max .. " " .. r.start)
+				end
+				r = rulexlib:SlidingWindow("s", d.v, 60, 30, d.ts)
+				if r ~= nil then
+					rulexlib:log("S " .. r.count .. " " .. r.sum .. " " .. r.start)
+				end
+				r = rulexlib:CountWindow("c", d.v, 3, 1)
+				if r ~= nil then
+					rulexlib:log("C " .. r.count .. " " .. r.first .. " " .. r.last .. " " .. r.stddev)
+				end
+				return true, data
+			end
+		}`,
+		`function Failed(error) end`,
+		typex.RuleLimits{},
+		[]string{
+			`{"v":2,"ts":0}`,
+			`{"v":4,"ts":20000}`,
+			`{"v":6,"ts":40000}`,
+			`{"v":8,"ts":70000}`,
+			`{"v":10,"ts":130000}`,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]string{
+		{},
+		{},
+		{"S 2 6 -30000", "C 3 2 6 1.632993161855452"},
+		{"T 3 4 2 6 0", "S 3 12 0", "C 3 4 8 1.632993161855452"},
+		{"T 1 8 8 8 60000", "S 1 8 60000", "C 3 6 10 1.632993161855452"},
+	}
+	for i, r := range results {
+		if r.Error != "" || len(r.Logs) != len(expect[i]) {
+			t.Fatal(i, r.Error, r.Logs)
+		}
+		for j := range expect[i] {
+			if r.Logs[j] != expect[i][j] {
+				t.Fatalf("%v: %v != %v", i, r.Logs[j], expect[i][j])
+			}
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package typex
 
 // Source State
-type SourceState int
+type SourceState int32
 
 const (
 	SOURCE_DOWN  SourceState = 0
 	SOURCE_UP    SourceState = 1
 	SOURCE_PAUSE SourceState = 2
+	// 连续重启失败, 已经熔断
+	SOURCE_FAILED SourceState = 3
 )
 
 //
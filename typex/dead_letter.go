@@ -0,0 +1,370 @@
+package typex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/diskqueue"
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/utils"
+)
+
+//
+// 死信: 重试多次以后依然投递失败的消息
+//
+var DefaultDeadLetters *DeadLetterStore
+
+//
+// OutEnd 配置里的保留字段, 用来配置失败重试
+//
+const OUTEND_RETRY_CONFIG_KEY string = "retry"
+
+/*
+*
+* 失败重试配置, 间隔按指数增长
+*
+ */
+type OutEndRetryConfig struct {
+	MaxAttempts     int     `json:"maxAttempts"`     // 最多尝试次数, 包含第一次
+	InitialInterval int     `json:"initialInterval"` // 第一次重试间隔, 单位毫秒
+	MaxInterval     int     `json:"maxInterval"`     // 最大重试间隔, 单位毫秒
+	Multiplier      float64 `json:"multiplier"`      // 间隔增长倍数
+}
+
+/*
+*
+* 从 OutEnd 配置里读取重试配置, 默认不重试
+*
+ */
+func (o *OutEnd) RetryConfig() OutEndRetryConfig {
+	config := OutEndRetryConfig{
+		MaxAttempts:     1,
+		InitialInterval: 500,
+		MaxInterval:     30 * 1000,
+		Multiplier:      2,
+	}
+	if v, ok := o.Config[OUTEND_RETRY_CONFIG_KEY]; ok {
+		if m, ok := v.(map[string]interface{}); ok {
+			if err := utils.BindConfig(m, &config); err != nil {
+				glogger.GLogger.Error("OutEnd retry config error:", err)
+			}
+		}
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.Multiplier < 1 {
+		config.Multiplier = 1
+	}
+	return config
+}
+
+// 等待重试的消息太多的时候直接进死信, 防止目标长时间不可用占满内存
+const _MAX_PENDING_RETRIES int = 1024
+
+//
+// 投递一次, 失败的时候记录到死信里面
+//
+func deliverOnce(o *OutEnd, dl *DeadLetter) error {
+	start := time.Now()
+	_, err := o.Target.To(dl.Data)
+	statistics.Observe(statistics.OUTEND, o.UUID, len(dl.Data), time.Since(start), err)
+	if err == nil {
+		statistics.IncOut()
+		return nil
+	}
+	dl.Attempts++
+	dl.Error = err.Error()
+	dl.LastFailedAt = time.Now()
+	if dl.Attempts == 1 {
+		dl.FirstFailedAt = dl.LastFailedAt
+	}
+	return err
+}
+
+/*
+*
+* 失败重试队列: 每个 OutEnd 一个协程按顺序重试队头, 队头成功或者进死信之前
+* 后面的消息都排队, 这样投递顺序不变, 也不会和 worker 同时调用 Target.To;
+* 重试不占用队列的 worker, 队列空了协程就退出
+*
+ */
+type outEndRetry struct {
+	lock    sync.Mutex
+	pending []DeadLetter
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+//
+// 把消息放进重试队列, force 为 false 的时候只有队列里已经有消息才排进去
+//
+func (o *OutEnd) queueRetry(dl DeadLetter, force bool) bool {
+	r := &o.retry
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.pending) == 0 && !force {
+		return false
+	}
+	if len(r.pending) >= _MAX_PENDING_RETRIES {
+		saveDeadLetter(dl)
+		return true
+	}
+	r.pending = append(r.pending, dl)
+	if r.cancel == nil {
+		ctx, cancel := context.WithCancel(GCTX)
+		r.cancel = cancel
+		r.done = make(chan struct{})
+		go o.retryLoop(ctx, r.done)
+	}
+	return true
+}
+
+func (o *OutEnd) retryLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	r := &o.retry
+	config := o.RetryConfig()
+	initial := time.Duration(config.InitialInterval) * time.Millisecond
+	interval := initial
+	for {
+		r.lock.Lock()
+		if ctx.Err() != nil {
+			r.lock.Unlock()
+			return
+		}
+		if len(r.pending) == 0 {
+			r.cancel()
+			r.cancel = nil
+			r.lock.Unlock()
+			return
+		}
+		dl := r.pending[0]
+		r.lock.Unlock()
+		// 失败过的消息等一会再试, 暂停的时候也等着, 不投递
+		if dl.Attempts > 0 || o.Paused() {
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if o.Paused() {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err := deliverOnce(o, &dl)
+		r.lock.Lock()
+		if err == nil || dl.Attempts >= config.MaxAttempts {
+			r.pending = r.pending[1:]
+			interval = initial
+			if err != nil {
+				saveDeadLetter(dl)
+			}
+		} else {
+			r.pending[0] = dl
+			glogger.GLogger.Warnf("OutEnd [%v] deliver failed, retry after %v: %v", o.UUID, interval, dl.Error)
+			interval = time.Duration(float64(interval) * config.Multiplier)
+			if maxInterval := time.Duration(config.MaxInterval) * time.Millisecond; interval > maxInterval {
+				interval = maxInterval
+			}
+		}
+		r.lock.Unlock()
+	}
+}
+
+/*
+*
+* 停止重试协程, 还没重试完的消息进死信, 以后可以重新投递
+*
+ */
+func StopOutEndRetry(o *OutEnd) {
+	r := &o.retry
+	r.lock.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	if cancel != nil {
+		cancel()
+	}
+	r.lock.Unlock()
+	if cancel != nil {
+		<-done
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, dl := range r.pending {
+		saveDeadLetter(dl)
+	}
+	r.pending = nil
+}
+
+func saveDeadLetter(dl DeadLetter) {
+	glogger.GLogger.Errorf("OutEnd [%v] deliver failed after %v attempts: %v", dl.OutEnd, dl.Attempts, dl.Error)
+	statistics.IncOutFailed()
+	if DefaultDeadLetters == nil {
+		return
+	}
+	if err := DefaultDeadLetters.Add(dl); err != nil {
+		glogger.GLogger.Error("Dead letter save error:", err)
+	}
+}
+
+/*
+*
+* 一条死信
+*
+ */
+type DeadLetter struct {
+	Id            uint64    `json:"id"`
+	OutEnd        string    `json:"outEnd"`   // 目标 UUID
+	Data          string    `json:"data"`     // 原始数据
+	Error         string    `json:"error"`    // 最后一次失败原因
+	Attempts      int       `json:"attempts"` // 尝试次数
+	FirstFailedAt time.Time `json:"firstFailedAt"`
+	LastFailedAt  time.Time `json:"lastFailedAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+/*
+*
+* 死信存储, 基于磁盘队列, 超过大小以后丢弃最旧的死信
+*
+ */
+type DeadLetterStore struct {
+	queue *diskqueue.DiskQueue
+}
+
+/*
+*
+* 启动死信存储
+*
+ */
+func StartDeadLetterStore(basePath string, maxSize int64) error {
+	queue, err := diskqueue.Open(filepath.Join(basePath, "deadletter"), maxSize, 0)
+	if err != nil {
+		return err
+	}
+	DefaultDeadLetters = &DeadLetterStore{queue: queue}
+	return nil
+}
+
+/*
+*
+* 保存一条死信
+*
+ */
+func (s *DeadLetterStore) Add(dl DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return s.queue.Push(b)
+}
+
+/*
+*
+* 按时间顺序列出死信, outEnd 为空表示全部
+*
+ */
+func (s *DeadLetterStore) List(outEnd string, limit int) []DeadLetter {
+	dls := []DeadLetter{}
+	for _, msg := range s.queue.List(0) {
+		dl, err := decodeDeadLetter(msg)
+		if err != nil {
+			continue
+		}
+		if outEnd != "" && dl.OutEnd != outEnd {
+			continue
+		}
+		dls = append(dls, dl)
+		if limit > 0 && len(dls) >= limit {
+			break
+		}
+	}
+	return dls
+}
+
+/*
+*
+* 获取一条死信
+*
+ */
+func (s *DeadLetterStore) Get(id uint64) (DeadLetter, error) {
+	msg, err := s.queue.Get(id)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	return decodeDeadLetter(msg)
+}
+
+/*
+*
+* 删除一条死信
+*
+ */
+func (s *DeadLetterStore) Remove(id uint64) error {
+	return s.queue.Remove(id)
+}
+
+/*
+*
+* 清空死信, outEnd 为空表示全部
+*
+ */
+func (s *DeadLetterStore) Purge(outEnd string) (int, error) {
+	count := 0
+	for _, dl := range s.List(outEnd, 0) {
+		if err := s.queue.Remove(dl.Id); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+/*
+*
+* 重新投递: 推到 OutEnd 的队列里面, 成功进队列就删除死信
+* target 为空表示投递到原来的 OutEnd
+*
+ */
+func (s *DeadLetterStore) Replay(e RuleX, id uint64, target string) error {
+	dl, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		target = dl.OutEnd
+	}
+	outEnd := e.GetOutEnd(target)
+	if outEnd == nil {
+		return errors.New("OutEnd not exists:" + target)
+	}
+	if err := e.PushOutQueue(outEnd, dl.Data); err != nil {
+		return err
+	}
+	return s.queue.Remove(id)
+}
+
+// 死信数量
+func (s *DeadLetterStore) Len() int {
+	return s.queue.Len()
+}
+
+func decodeDeadLetter(msg diskqueue.Message) (DeadLetter, error) {
+	dl := DeadLetter{}
+	if err := json.Unmarshal(msg.Data, &dl); err != nil {
+		return dl, err
+	}
+	dl.Id = msg.Seq
+	dl.CreatedAt = msg.Ts
+	return dl, nil
+}
@@ -0,0 +1,38 @@
+package typex
+
+/*
+*
+* 试运行时被拦截下来的输出: 本来要发给 OutEnd、设备、内部主题的数据
+*
+ */
+type DryRunOutput struct {
+	Lib    string `json:"lib"`    // 调用的库函数, 比如 DataToMqtt
+	Target string `json:"target"` // OutEnd、设备的 UUID 或者主题
+	Data   string `json:"data"`
+}
+
+/*
+*
+* Actions 管道里每一步的结果
+*
+ */
+type DryRunStep struct {
+	Step   int    `json:"step"`
+	Next   string `json:"next"`   // 第一个返回值, 是否继续往下走
+	Output string `json:"output"` // 第二个返回值, 传给下一步的数据
+	Error  string `json:"error,omitempty"`
+}
+
+/*
+*
+* 一条样例数据的试运行结果
+*
+ */
+type DryRunResult struct {
+	Payload  string         `json:"payload"`
+	Steps    []DryRunStep   `json:"steps"`
+	Callback string         `json:"callback"` // 最后执行的回调: Success 或者 Failed
+	Logs     []string       `json:"logs"`     // rulexlib:log 和 print 的输出
+	Outputs  []DryRunOutput `json:"outputs"`
+	Error    string         `json:"error,omitempty"`
+}
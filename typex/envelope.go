@@ -0,0 +1,141 @@
+package typex
+
+import (
+	"context"
+	"time"
+
+	"github.com/i4de/rulex/utils"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+//
+// 消息来源类型
+//
+const (
+	ORIGIN_INEND  string = "INEND"
+	ORIGIN_DEVICE string = "DEVICE"
+	ORIGIN_TOPIC  string = "TOPIC" // 内部主题
+)
+
+/*
+*
+* 消息信封: 原始数据加上元数据, 规则里面可以知道数据从哪来的
+*
+ */
+type Envelope struct {
+	Payload     []byte            `json:"payload"`
+	Origin      string            `json:"origin"`         // InEnd 或者 Device 的 UUID
+	OriginType  string            `json:"originType"`     // INEND, DEVICE
+	ReceivedAt  time.Time         `json:"receivedAt"`     // 收到数据的时间
+	ContentType string            `json:"contentType"`    // 数据格式, 比如 application/json
+	Topic       string            `json:"topic"`          // MQTT Topic, NATS Subject, URL Path 等
+	Peer        string            `json:"peer"`           // 对端地址, 比如 UDP 客户端
+	Headers     map[string]string `json:"headers"`        // 其他元数据, 比如 HTTP Header, Modbus 从机号
+	TraceId     string            `json:"traceId"`        // 跟踪ID
+	Hops        []string          `json:"hops,omitempty"` // 经过的内部主题, 用来检测环路
+}
+
+/*
+*
+* 新建一个信封, 时间戳和跟踪ID自动生成
+*
+ */
+func NewEnvelope(payload []byte) *Envelope {
+	return &Envelope{
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+		Headers:    map[string]string{},
+		TraceId:    utils.MakeUUID("TRACE"),
+	}
+}
+
+/*
+*
+* 设置 Header, 支持链式调用
+*
+ */
+func (e *Envelope) SetHeader(k string, v string) *Envelope {
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	e.Headers[k] = v
+	return e
+}
+
+// 数据字符串, 兼容老的接口
+func (e *Envelope) String() string {
+	return string(e.Payload)
+}
+
+/*
+*
+* 转成 Lua Table, 作为 Actions 回调的第二个参数
+*
+ */
+func (e *Envelope) LuaTable(vm *lua.LState) *lua.LTable {
+	meta := vm.NewTable()
+	meta.RawSetString("origin", lua.LString(e.Origin))
+	meta.RawSetString("originType", lua.LString(e.OriginType))
+	meta.RawSetString("receivedAt", lua.LNumber(e.ReceivedAt.UnixNano()/int64(time.Millisecond)))
+	meta.RawSetString("contentType", lua.LString(e.ContentType))
+	meta.RawSetString("topic", lua.LString(e.Topic))
+	meta.RawSetString("peer", lua.LString(e.Peer))
+	meta.RawSetString("traceId", lua.LString(e.TraceId))
+	headers := vm.NewTable()
+	for k, v := range e.Headers {
+		headers.RawSetString(k, lua.LString(v))
+	}
+	meta.RawSetString("headers", headers)
+	return meta
+}
+
+type envelopeCtxKey struct{}
+
+/*
+*
+* 执行规则的时候把信封绑定到虚拟机上, rulexlib 里面可以拿到当前消息
+* ctx 取消的时候虚拟机会中断执行
+*
+ */
+func BindEnvelope(ctx context.Context, vm *lua.LState, e *Envelope) {
+	vm.SetContext(context.WithValue(ctx, envelopeCtxKey{}, e))
+}
+
+// 执行完以后解除绑定
+func UnbindEnvelope(vm *lua.LState) {
+	vm.RemoveContext()
+}
+
+//
+// 获取虚拟机当前正在处理的信封, 不在规则回调里面返回 nil
+//
+func CurrentEnvelope(vm *lua.LState) *Envelope {
+	ctx := vm.Context()
+	if ctx == nil {
+		return nil
+	}
+	e, _ := ctx.Value(envelopeCtxKey{}).(*Envelope)
+	return e
+}
+
+type ruleCtxKey struct{}
+
+//
+// 执行规则的时候带上规则 UUID, 比如日志要知道是哪个规则打的
+//
+func WithRuleUUID(ctx context.Context, ruleUUID string) context.Context {
+	return context.WithValue(ctx, ruleCtxKey{}, ruleUUID)
+}
+
+//
+// 虚拟机当前正在执行的规则, 不在规则回调里面返回空
+//
+func CurrentRuleUUID(vm *lua.LState) string {
+	ctx := vm.Context()
+	if ctx == nil {
+		return ""
+	}
+	ruleUUID, _ := ctx.Value(ruleCtxKey{}).(string)
+	return ruleUUID
+}
@@ -0,0 +1,754 @@
+package typex
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+)
+
+//
+// 本地历史数据: 不依赖云端数据库也能看最近几天的数据
+//
+var DefaultHistory *HistoryStore
+
+// 聚合方式
+const (
+	HISTORY_AVG   = "avg"
+	HISTORY_MIN   = "min"
+	HISTORY_MAX   = "max"
+	HISTORY_SUM   = "sum"
+	HISTORY_COUNT = "count"
+	HISTORY_LAST  = "last"
+)
+
+//
+// 历史数据配置
+//
+type HistoryConfig struct {
+	Path                string        // 数据目录
+	RawRetention        time.Duration // 原始数据保留多久, 过期以后降采样
+	DownsampleInterval  time.Duration // 降采样间隔, 小于等于0表示不降采样, 原始数据过期直接删掉
+	DownsampleRetention time.Duration // 降采样数据保留多久
+	MaxSeries           int           // 最多多少个时间序列, 超过以后新的序列写不进去
+	MaxPoints           int           // 每个序列内存里面最多保留的原始数据点, 超过以后最旧的提前降采样
+}
+
+//
+// 一个数据点, 时间戳单位毫秒
+//
+type HistoryPoint struct {
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+//
+// 查询条件: 时间范围, 聚合间隔和标签过滤, Interval 为0返回原始数据
+//
+type HistoryQuery struct {
+	Metric      string
+	Tags        map[string]string
+	Start       int64
+	End         int64
+	Interval    time.Duration
+	Aggregation string
+}
+
+type HistoryValue struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+//
+// 查询结果, 一个时间序列一组值
+//
+type HistorySeries struct {
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags"`
+	Points []HistoryValue    `json:"points"`
+}
+
+//
+// 时间序列概况
+//
+type HistorySeriesInfo struct {
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags"`
+	Raw       int               `json:"raw"`       // 原始数据点数
+	Rollups   int               `json:"rollups"`   // 降采样数据点数
+	LastValue float64           `json:"lastValue"` // 最新的值
+	LastTime  int64             `json:"lastTime"`
+}
+
+type historySample struct {
+	t int64
+	v float64
+}
+
+//
+// 降采样以后的一个桶, T 是桶的开始时间, I 是桶的长度, 都是毫秒
+//
+type historyRollup struct {
+	T     int64   `json:"t"`
+	I     int64   `json:"i"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int64   `json:"count"`
+	Last  float64 `json:"last"`
+}
+
+func (r *historyRollup) add(v float64) {
+	if r.Count == 0 || v < r.Min {
+		r.Min = v
+	}
+	if r.Count == 0 || v > r.Max {
+		r.Max = v
+	}
+	r.Sum += v
+	r.Count++
+	r.Last = v
+}
+
+func (r *historyRollup) merge(o historyRollup) {
+	if r.Count == 0 || o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if r.Count == 0 || o.Max > r.Max {
+		r.Max = o.Max
+	}
+	r.Sum += o.Sum
+	r.Count += o.Count
+	r.Last = o.Last
+}
+
+func (r *historyRollup) value(aggregation string) float64 {
+	switch aggregation {
+	case HISTORY_MIN:
+		return r.Min
+	case HISTORY_MAX:
+		return r.Max
+	case HISTORY_SUM:
+		return r.Sum
+	case HISTORY_COUNT:
+		return float64(r.Count)
+	case HISTORY_LAST:
+		return r.Last
+	}
+	return r.Sum / float64(r.Count)
+}
+
+type historyRollupLine struct {
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags"`
+	Rollup    historyRollup     `json:"rollup"`
+	Watermark int64             `json:"watermark,omitempty"` // 桶只合并了一部分的时候, 合并到哪里了
+}
+
+//
+// 一个时间序列: 指标名加上一组标签, watermark 之前的数据都已经降采样了
+//
+type historySeries struct {
+	metric    string
+	tags      map[string]string
+	raw       []historySample
+	rollups   []historyRollup
+	watermark int64
+}
+
+func historyKey(metric string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return metric + "{" + strings.Join(pairs, ",") + "}"
+}
+
+/*
+*
+* 历史数据存储: 原始数据按小时追加到文件, 过期以后降采样写到按天的文件,
+* 内存里面保留数据方便查询, 序列数和每个序列的原始数据点数都有上限, 启动的时候从文件恢复
+*
+ */
+type HistoryStore struct {
+	lock     sync.RWMutex
+	config   HistoryConfig
+	series   map[string]*historySeries
+	writer   *bufio.Writer
+	file     *os.File
+	fileName string
+	cancel   context.CancelFunc
+}
+
+/*
+*
+* 启动历史数据存储
+*
+ */
+func StartHistoryStore(config HistoryConfig) error {
+	if config.RawRetention <= 0 {
+		config.RawRetention = 24 * time.Hour
+	}
+	if config.DownsampleRetention <= 0 {
+		config.DownsampleRetention = 7 * 24 * time.Hour
+	}
+	if config.MaxSeries <= 0 {
+		config.MaxSeries = 1000
+	}
+	if config.MaxPoints <= 0 {
+		config.MaxPoints = 3600
+	}
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return err
+	}
+	store := &HistoryStore{config: config, series: map[string]*historySeries{}}
+	if err := store.load(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(GCTX)
+	store.cancel = cancel
+	DefaultHistory = store
+	go func(ctx context.Context) {
+		flush := time.NewTicker(time.Second)
+		defer flush.Stop()
+		maintain := time.NewTicker(time.Minute)
+		defer maintain.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-flush.C:
+				store.lock.Lock()
+				if store.writer != nil {
+					if err := store.writer.Flush(); err != nil {
+						glogger.GLogger.Error("History flush error:", err)
+					}
+				}
+				store.lock.Unlock()
+			case <-maintain.C:
+				store.Maintain(time.Now())
+			}
+		}
+	}(ctx)
+	return nil
+}
+
+/*
+*
+* 写入一个数据点, 时间戳为0表示现在
+*
+ */
+func (s *HistoryStore) Write(point HistoryPoint) error {
+	if point.Metric == "" {
+		return errors.New("metric can not be empty")
+	}
+	if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+		return errors.New("value must be a finite number")
+	}
+	if point.Timestamp == 0 {
+		point.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if point.Tags == nil {
+		point.Tags = map[string]string{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.insert(point); err != nil {
+		return err
+	}
+	return s.append(point)
+}
+
+func (s *HistoryStore) insert(point HistoryPoint) error {
+	key := historyKey(point.Metric, point.Tags)
+	series, ok := s.series[key]
+	if !ok {
+		if len(s.series) >= s.config.MaxSeries {
+			return fmt.Errorf("too many history series, max is %v", s.config.MaxSeries)
+		}
+		series = &historySeries{metric: point.Metric, tags: point.Tags}
+		s.series[key] = series
+	}
+	if point.Timestamp < series.watermark {
+		return fmt.Errorf("point is older than downsampled data: %v", point.Timestamp)
+	}
+	sample := historySample{t: point.Timestamp, v: point.Value}
+	// 一般都是按时间顺序来的, 乱序的话插到对应的位置
+	n := len(series.raw)
+	if n == 0 || series.raw[n-1].t <= sample.t {
+		series.raw = append(series.raw, sample)
+	} else {
+		i := sort.Search(n, func(i int) bool { return series.raw[i].t > sample.t })
+		series.raw = append(series.raw, historySample{})
+		copy(series.raw[i+1:], series.raw[i:])
+		series.raw[i] = sample
+	}
+	s.limitPoints(series)
+	return nil
+}
+
+//
+// 原始数据点超过上限: 最旧的提前降采样, 不降采样的话直接删掉
+//
+func (s *HistoryStore) limitPoints(series *historySeries) {
+	if len(series.raw) <= s.config.MaxPoints {
+		return
+	}
+	cutoff := series.raw[len(series.raw)-s.config.MaxPoints].t
+	interval := int64(s.config.DownsampleInterval / time.Millisecond)
+	if interval <= 0 {
+		series.raw = append([]historySample{}, series.raw[len(series.raw)-s.config.MaxPoints:]...)
+		return
+	}
+	// 同一毫秒的点太多的时候 cutoff 之前可能没有点, 直接保留
+	lines := series.fold(cutoff, interval)
+	if len(lines) > 0 {
+		if err := s.appendRollups(time.Now(), lines); err != nil {
+			glogger.GLogger.Error("History write rollup error:", err)
+		}
+	}
+}
+
+/*
+*
+* 把 cutoff 之前的原始数据合并到降采样的桶里面, 返回需要写到文件的桶;
+* 桶可能只合并了一部分, 后面再合并的时候整个桶重新写一遍, 加载的时候后面的覆盖前面的
+*
+ */
+func (series *historySeries) fold(cutoff, interval int64) []historyRollupLine {
+	lines := []historyRollupLine{}
+	n := sort.Search(len(series.raw), func(i int) bool { return series.raw[i].t >= cutoff })
+	for _, sample := range series.raw[:n] {
+		b := sample.t - sample.t%interval
+		last := len(series.rollups) - 1
+		if last < 0 || series.rollups[last].T != b {
+			series.rollups = append(series.rollups, historyRollup{T: b, I: interval})
+			last++
+		}
+		series.rollups[last].add(sample.v)
+	}
+	watermark := series.watermark
+	if cutoff > watermark {
+		watermark = cutoff
+	}
+	// 新生成或者更新过的桶写到文件里面
+	for i := len(series.rollups) - 1; i >= 0 && n > 0; i-- {
+		r := series.rollups[i]
+		if r.T+r.I <= series.watermark {
+			break
+		}
+		lines = append(lines, historyRollupLine{Metric: series.metric, Tags: series.tags,
+			Rollup: r, Watermark: watermark})
+	}
+	// 拷贝一份, 前面的点占用的内存可以回收
+	series.raw = append([]historySample{}, series.raw[n:]...)
+	series.watermark = watermark
+	return lines
+}
+
+//
+// 追加到当前小时的原始数据文件
+//
+func (s *HistoryStore) append(point HistoryPoint) error {
+	name := "raw-" + time.Now().Format("2006010215") + ".log"
+	if name != s.fileName {
+		if err := s.closeFile(); err != nil {
+			glogger.GLogger.Error("History close file error:", err)
+		}
+		f, err := os.OpenFile(filepath.Join(s.config.Path, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s.file = f
+		s.fileName = name
+		s.writer = bufio.NewWriter(f)
+	}
+	b, err := json.Marshal(point)
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(b); err != nil {
+		return err
+	}
+	return s.writer.WriteByte('\n')
+}
+
+func (s *HistoryStore) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	s.writer = nil
+	s.fileName = ""
+	return err
+}
+
+/*
+*
+* 查询历史数据
+*
+ */
+func (s *HistoryStore) Query(q HistoryQuery) ([]HistorySeries, error) {
+	if q.Metric == "" {
+		return nil, errors.New("metric can not be empty")
+	}
+	if q.End == 0 {
+		q.End = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if q.Start == 0 {
+		q.Start = q.End - int64(time.Hour/time.Millisecond)
+	}
+	if q.Start > q.End {
+		return nil, errors.New("start must be less than end")
+	}
+	if q.Aggregation == "" {
+		q.Aggregation = HISTORY_AVG
+	}
+	switch q.Aggregation {
+	case HISTORY_AVG, HISTORY_MIN, HISTORY_MAX, HISTORY_SUM, HISTORY_COUNT, HISTORY_LAST:
+	default:
+		return nil, errors.New("unsupported aggregation:" + q.Aggregation)
+	}
+	interval := int64(q.Interval / time.Millisecond)
+	if q.Interval > 0 && interval == 0 {
+		return nil, errors.New("interval must be at least 1ms")
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := []HistorySeries{}
+	for _, key := range s.sortedKeys() {
+		series := s.series[key]
+		if series.metric != q.Metric || !matchTags(series.tags, q.Tags) {
+			continue
+		}
+		points := series.query(q.Start, q.End, interval, q.Aggregation)
+		if len(points) == 0 {
+			continue
+		}
+		result = append(result, HistorySeries{
+			Metric: series.metric,
+			Tags:   copyTags(series.tags),
+			Points: points,
+		})
+	}
+	return result, nil
+}
+
+//
+// 降采样的数据在前面, 原始数据在后面, 按时间顺序聚合
+//
+func (series *historySeries) query(start, end, interval int64, aggregation string) []HistoryValue {
+	points := []HistoryValue{}
+	if interval <= 0 {
+		for _, r := range series.rollups {
+			if r.T >= start && r.T <= end {
+				points = append(points, HistoryValue{Timestamp: r.T, Value: r.value(aggregation)})
+			}
+		}
+		for _, sample := range series.raw {
+			if sample.t >= start && sample.t <= end {
+				points = append(points, HistoryValue{Timestamp: sample.t, Value: sample.v})
+			}
+		}
+		return points
+	}
+	buckets := []*historyRollup{}
+	bucket := func(t int64) *historyRollup {
+		b := t - t%interval
+		if n := len(buckets); n > 0 && buckets[n-1].T == b {
+			return buckets[n-1]
+		}
+		r := &historyRollup{T: b, I: interval}
+		buckets = append(buckets, r)
+		return r
+	}
+	for _, r := range series.rollups {
+		if r.T >= start && r.T <= end {
+			bucket(r.T).merge(r)
+		}
+	}
+	for _, sample := range series.raw {
+		if sample.t >= start && sample.t <= end {
+			bucket(sample.t).add(sample.v)
+		}
+	}
+	for _, r := range buckets {
+		points = append(points, HistoryValue{Timestamp: r.T, Value: r.value(aggregation)})
+	}
+	return points
+}
+
+/*
+*
+* 所有时间序列, metric 为空表示全部
+*
+ */
+func (s *HistoryStore) Series(metric string) []HistorySeriesInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	infos := []HistorySeriesInfo{}
+	for _, key := range s.sortedKeys() {
+		series := s.series[key]
+		if metric != "" && series.metric != metric {
+			continue
+		}
+		info := HistorySeriesInfo{
+			Metric:  series.metric,
+			Tags:    copyTags(series.tags),
+			Raw:     len(series.raw),
+			Rollups: len(series.rollups),
+		}
+		if n := len(series.raw); n > 0 {
+			info.LastValue, info.LastTime = series.raw[n-1].v, series.raw[n-1].t
+		} else if n := len(series.rollups); n > 0 {
+			info.LastValue, info.LastTime = series.rollups[n-1].Last, series.rollups[n-1].T
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (s *HistoryStore) sortedKeys() []string {
+	keys := make([]string, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func matchTags(tags map[string]string, filter map[string]string) bool {
+	for k, v := range filter {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+/*
+*
+* 执行保留策略: 过期的原始数据降采样, 过期的降采样数据和文件删掉
+*
+ */
+func (s *HistoryStore) Maintain(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	rawCutoff := nowMs - int64(s.config.RawRetention/time.Millisecond)
+	rollupCutoff := nowMs - int64(s.config.DownsampleRetention/time.Millisecond)
+	interval := int64(s.config.DownsampleInterval / time.Millisecond)
+	lines := []historyRollupLine{}
+	for key, series := range s.series {
+		if interval > 0 {
+			// 只处理完整的桶
+			lines = append(lines, series.fold(rawCutoff-rawCutoff%interval, interval)...)
+		} else {
+			n := sort.Search(len(series.raw), func(i int) bool { return series.raw[i].t >= rawCutoff })
+			series.raw = series.raw[n:]
+		}
+		n := sort.Search(len(series.rollups), func(i int) bool {
+			return series.rollups[i].T+series.rollups[i].I > rollupCutoff
+		})
+		series.rollups = series.rollups[n:]
+		if len(series.raw) == 0 && len(series.rollups) == 0 {
+			delete(s.series, key)
+		}
+	}
+	if len(lines) > 0 {
+		if err := s.appendRollups(now, lines); err != nil {
+			glogger.GLogger.Error("History write rollup error:", err)
+		}
+	}
+	s.removeExpiredFiles(now)
+}
+
+func (s *HistoryStore) appendRollups(now time.Time, lines []historyRollupLine) error {
+	name := filepath.Join(s.config.Path, "rollup-"+now.Format("20060102")+".log")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		b, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+//
+// 原始数据文件里面的点都降采样以后才能删, 所以多留一个小时和一个降采样间隔
+//
+func (s *HistoryStore) removeExpiredFiles(now time.Time) {
+	entries, err := os.ReadDir(s.config.Path)
+	if err != nil {
+		glogger.GLogger.Error("History read dir error:", err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		var expired bool
+		if t, ok := historyFileTime(name, "raw-", "2006010215"); ok {
+			expired = t.Add(time.Hour).Add(s.config.DownsampleInterval).Add(s.config.RawRetention).Before(now)
+		} else if t, ok := historyFileTime(name, "rollup-", "20060102"); ok {
+			expired = t.Add(24 * time.Hour).Add(s.config.DownsampleRetention).Before(now)
+		}
+		if expired && name != s.fileName {
+			if err := os.Remove(filepath.Join(s.config.Path, name)); err != nil {
+				glogger.GLogger.Error("History remove file error:", err)
+			}
+		}
+	}
+}
+
+func historyFileTime(name, prefix, layout string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".log") {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".log"), time.Local)
+	return t, err == nil
+}
+
+/*
+*
+* 启动的时候恢复数据: 先加载降采样数据, 已经降采样过的原始数据跳过
+*
+ */
+func (s *HistoryStore) load() error {
+	entries, err := os.ReadDir(s.config.Path)
+	if err != nil {
+		return err
+	}
+	// 文件名里面的时间就是写入顺序
+	for _, entry := range entries {
+		if _, ok := historyFileTime(entry.Name(), "rollup-", "20060102"); !ok {
+			continue
+		}
+		if err := eachHistoryLine(filepath.Join(s.config.Path, entry.Name()), func(b []byte) error {
+			line := historyRollupLine{}
+			if err := json.Unmarshal(b, &line); err != nil {
+				return err
+			}
+			if line.Tags == nil {
+				line.Tags = map[string]string{}
+			}
+			key := historyKey(line.Metric, line.Tags)
+			series, ok := s.series[key]
+			if !ok {
+				if len(s.series) >= s.config.MaxSeries {
+					return nil
+				}
+				series = &historySeries{metric: line.Metric, tags: line.Tags}
+				s.series[key] = series
+			}
+			n := len(series.rollups)
+			if n > 0 && series.rollups[n-1].T == line.Rollup.T {
+				series.rollups[n-1] = line.Rollup
+			} else {
+				series.rollups = append(series.rollups, line.Rollup)
+			}
+			end := line.Rollup.T + line.Rollup.I
+			if line.Watermark > 0 {
+				end = line.Watermark
+			}
+			if end > series.watermark {
+				series.watermark = end
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if _, ok := historyFileTime(entry.Name(), "raw-", "2006010215"); !ok {
+			continue
+		}
+		if err := eachHistoryLine(filepath.Join(s.config.Path, entry.Name()), func(b []byte) error {
+			point := HistoryPoint{}
+			if err := json.Unmarshal(b, &point); err != nil {
+				return err
+			}
+			if point.Tags == nil {
+				point.Tags = map[string]string{}
+			}
+			// 已经降采样过的会返回错误, 直接跳过
+			s.insert(point)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// 按行读文件, 坏掉的行(比如断电的时候没写完)跳过
+//
+func eachHistoryLine(path string, handle func([]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := handle(scanner.Bytes()); err != nil {
+			glogger.GLogger.Warn("History skip broken line:", err)
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+*
+* 停止: 把缓冲区写到磁盘
+*
+ */
+func (s *HistoryStore) Close() error {
+	s.cancel()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closeFile()
+}
@@ -1,29 +1,51 @@
 package typex
 
-import "github.com/i4de/rulex/utils"
+import (
+	"sync/atomic"
+
+	"github.com/i4de/rulex/utils"
+)
 
 //
 type InEnd struct {
 	//
-	UUID        string          `json:"uuid"`
-	State       SourceState     `json:"state"`
-	Type        InEndType       `json:"type"`
-	Name        string          `json:"name"`
-	Description string          `json:"description"`
-	BindRules   map[string]Rule `json:"-"`
+	UUID        string           `json:"uuid"`
+	State       SourceState      `json:"state"`
+	Type        InEndType        `json:"type"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	BindRules   map[string]*Rule `json:"-"`
 	//
 	Config        map[string]interface{} `json:"config"`
 	DataModelsMap map[string]XDataModel  `json:"-"`
 	Source        XSource                `json:"-"`
+	Supervisor    *Supervisor            `json:"supervisor"` // 重启状态
 }
 
+//
+// 状态在接口, worker 和守护协程里面同时读写, 所以用原子操作
+//
 func (in *InEnd) GetState() SourceState {
-	return in.State
+	return SourceState(atomic.LoadInt32((*int32)(&in.State)))
 }
 
 //
 func (in *InEnd) SetState(s SourceState) {
-	in.State = s
+	atomic.StoreInt32((*int32)(&in.State), int32(s))
+}
+
+//
+// 守护协程同步状态用, 暂停的资源保持暂停
+//
+func (in *InEnd) UpdateState(s SourceState) bool {
+	return updateState((*int32)(&in.State), int32(s), int32(SOURCE_PAUSE))
+}
+
+//
+// 是否暂停接收数据
+//
+func (in *InEnd) Paused() bool {
+	return in.GetState() == SOURCE_PAUSE
 }
 
 //
@@ -37,10 +59,25 @@ func NewInEnd(Type InEndType,
 		Type:        Type,
 		Name:        n,
 		Description: d,
-		BindRules:   map[string]Rule{},
+		BindRules:   map[string]*Rule{},
 		Config:      c,
 	}
 }
 func (in *InEnd) GetConfig(k string) interface{} {
 	return (in.Config)[k]
 }
+
+//
+// InEnd 配置里的保留字段, 单独配置队列满了以后的处理策略
+//
+const INEND_OVERFLOW_POLICY_KEY string = "overflowPolicy"
+
+//
+// 队列溢出策略, 空串表示使用全局配置
+//
+func (in *InEnd) OverflowPolicy() string {
+	if v, ok := in.Config[INEND_OVERFLOW_POLICY_KEY].(string); ok {
+		return v
+	}
+	return ""
+}
@@ -1,6 +1,9 @@
 package typex
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/i4de/rulex/utils"
 )
 
@@ -14,17 +17,40 @@ type OutEnd struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
 	//
-	Config map[string]interface{} `json:"config"`
-	Target XTarget                `json:"-"`
+	Config     map[string]interface{} `json:"config"`
+	Target     XTarget                `json:"-"`
+	Supervisor *Supervisor            `json:"supervisor"` // 重启状态
+	// 断网缓存, 没启用的时候是 nil; 重启的时候会被替换, 所以要加锁
+	buffer     *OutEndBuffer
+	bufferLock sync.RWMutex
+	// 等待重试的消息
+	retry outEndRetry
 }
 
+//
+// 状态在接口, worker 和补发协程里面同时读写, 所以用原子操作
+//
 func (o *OutEnd) GetState() SourceState {
-	return o.State
+	return SourceState(atomic.LoadInt32((*int32)(&o.State)))
 }
 
 //
 func (o *OutEnd) SetState(s SourceState) {
-	o.State = s
+	atomic.StoreInt32((*int32)(&o.State), int32(s))
+}
+
+//
+// 守护协程同步状态用, 暂停的资源保持暂停
+//
+func (o *OutEnd) UpdateState(s SourceState) bool {
+	return updateState((*int32)(&o.State), int32(s), int32(SOURCE_PAUSE))
+}
+
+//
+// 是否暂停投递
+//
+func (o *OutEnd) Paused() bool {
+	return o.GetState() == SOURCE_PAUSE
 }
 
 //
@@ -0,0 +1,287 @@
+package typex
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/i4de/rulex/diskqueue"
+	"github.com/i4de/rulex/glogger"
+	"github.com/i4de/rulex/statistics"
+	"github.com/i4de/rulex/utils"
+)
+
+//
+// OutEnd 配置里的保留字段, 用来配置断网缓存
+//
+const OUTEND_BUFFER_CONFIG_KEY string = "buffer"
+
+const _DEFAULT_BUFFER_MAX_SIZE int64 = 20 * 1024 * 1024 // 20MB
+const _DEFAULT_BUFFER_MAX_AGE int64 = 24 * 60 * 60      // 1天
+const _BUFFER_REPLAY_INTERVAL time.Duration = 1 * time.Second
+const _BUFFER_SYNC_INTERVAL time.Duration = 100 * time.Millisecond // 批量 fsync 的间隔
+
+/*
+*
+* 断网缓存配置
+*
+ */
+type OutEndBufferConfig struct {
+	Enable  bool  `json:"enable"`
+	MaxSize int64 `json:"maxSize"` // 最大占用磁盘, 单位字节
+	MaxAge  int64 `json:"maxAge"`  // 最旧消息保存时间, 单位秒
+}
+
+/*
+*
+* 断网缓存状态
+*
+ */
+type OutEndBufferStatus struct {
+	UUID      string  `json:"uuid"`
+	Enable    bool    `json:"enable"`
+	Messages  int     `json:"messages"`  // 缓存的消息数
+	Bytes     int64   `json:"bytes"`     // 占用磁盘
+	MaxSize   int64   `json:"maxSize"`   // 最大占用磁盘
+	OldestAge float64 `json:"oldestAge"` // 最旧消息的时间, 单位秒
+	Dropped   uint64  `json:"dropped"`   // 超出限制被丢弃的消息数
+	Replayed  uint64  `json:"replayed"`  // 已经补发的消息数
+	Replaying bool    `json:"replaying"` // 是否正在补发
+	LastError string  `json:"lastError"` // 最后一次补发失败的原因
+}
+
+/*
+*
+* OutEndBuffer: 目标不可用时把消息存到磁盘, 恢复以后按顺序补发
+*
+ */
+type OutEndBuffer struct {
+	queue     *diskqueue.DiskQueue
+	replayed  uint64
+	replaying int32
+	lock      sync.Mutex
+	lastError string
+	cancel    context.CancelFunc
+}
+
+/*
+*
+* 从 OutEnd 配置里读取断网缓存配置, 没有配置就是不启用
+*
+ */
+func (o *OutEnd) BufferConfig() OutEndBufferConfig {
+	config := OutEndBufferConfig{
+		MaxSize: _DEFAULT_BUFFER_MAX_SIZE,
+		MaxAge:  _DEFAULT_BUFFER_MAX_AGE,
+	}
+	if v, ok := o.Config[OUTEND_BUFFER_CONFIG_KEY]; ok {
+		if m, ok := v.(map[string]interface{}); ok {
+			if err := utils.BindConfig(m, &config); err != nil {
+				glogger.GLogger.Error("OutEnd buffer config error:", err)
+				return OutEndBufferConfig{}
+			}
+		}
+	}
+	return config
+}
+
+/*
+*
+* 打开断网缓存并且启动补发协程, 如果没有启用什么都不做
+*
+ */
+func StartOutEndBuffer(o *OutEnd, basePath string) error {
+	StopOutEndBuffer(o)
+	config := o.BufferConfig()
+	if !config.Enable {
+		return nil
+	}
+	queue, err := diskqueue.Open(filepath.Join(basePath, "outend", o.UUID),
+		config.MaxSize, time.Duration(config.MaxAge)*time.Second)
+	if err != nil {
+		return err
+	}
+	queue.SetSyncInterval(_BUFFER_SYNC_INTERVAL)
+	ctx, cancel := context.WithCancel(GCTX)
+	buffer := &OutEndBuffer{queue: queue, cancel: cancel}
+	if old := o.swapBuffer(buffer); old != nil {
+		old.stop()
+	}
+	go buffer.replay(ctx, o)
+	return nil
+}
+
+/*
+*
+* 停止补发协程, 磁盘上的消息保留, 下次启动继续补发
+*
+ */
+func StopOutEndBuffer(o *OutEnd) {
+	if old := o.swapBuffer(nil); old != nil {
+		old.stop()
+	}
+}
+
+//
+// 当前的断网缓存, 没启用的时候是 nil
+//
+func (o *OutEnd) GetBuffer() *OutEndBuffer {
+	o.bufferLock.RLock()
+	defer o.bufferLock.RUnlock()
+	return o.buffer
+}
+
+func (o *OutEnd) swapBuffer(b *OutEndBuffer) *OutEndBuffer {
+	o.bufferLock.Lock()
+	defer o.bufferLock.Unlock()
+	old := o.buffer
+	o.buffer = b
+	return old
+}
+
+//
+// 停止补发, 还没同步的消息写到磁盘, 下次打开的时候不会丢
+//
+func (b *OutEndBuffer) stop() {
+	b.cancel()
+	if err := b.queue.Sync(); err != nil {
+		glogger.GLogger.Error("OutEnd buffer sync error:", err)
+	}
+}
+
+/*
+*
+* 投递数据到 OutEnd: 有积压的时候直接进缓存, 保证顺序;
+* 失败以后启用了缓存就进缓存由补发协程重试, 否则进重试队列按顺序重试, 最后进死信
+*
+ */
+func DeliverToOutEnd(o *OutEnd, data string) {
+	buffer := o.GetBuffer()
+	// 暂停的时候进缓存, 没有缓存就在重试队列里排队, 恢复以后按顺序补发
+	if o.Paused() {
+		if buffer != nil {
+			buffer.push(data)
+			return
+		}
+		o.queueRetry(DeadLetter{OutEnd: o.UUID, Data: data}, true)
+		return
+	}
+	if buffer != nil && buffer.queue.Len() > 0 {
+		buffer.push(data)
+		return
+	}
+	dl := DeadLetter{OutEnd: o.UUID, Data: data}
+	// 前面还有消息在重试, 排在后面
+	if buffer == nil && o.queueRetry(dl, false) {
+		return
+	}
+	err := deliverOnce(o, &dl)
+	if err == nil {
+		return
+	}
+	if buffer != nil {
+		glogger.GLogger.Error(err)
+		statistics.IncOutFailed()
+		buffer.push(data)
+		return
+	}
+	if dl.Attempts >= o.RetryConfig().MaxAttempts {
+		saveDeadLetter(dl)
+		return
+	}
+	glogger.GLogger.Warnf("OutEnd [%v] deliver failed, retry later: %v", o.UUID, dl.Error)
+	o.queueRetry(dl, true)
+}
+
+func (b *OutEndBuffer) push(data string) {
+	if err := b.queue.Push([]byte(data)); err != nil {
+		glogger.GLogger.Error("OutEnd buffer push error:", err)
+	}
+}
+
+//
+// 定时检查目标状态, 恢复以后按顺序补发
+//
+func (b *OutEndBuffer) replay(ctx context.Context, o *OutEnd) {
+	ticker := time.NewTicker(_BUFFER_REPLAY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if o.Target == nil || b.queue.Len() == 0 || o.Paused() {
+			continue
+		}
+		if o.Target.Status() != SOURCE_UP {
+			continue
+		}
+		atomic.StoreInt32(&b.replaying, 1)
+		for {
+			if ctx.Err() != nil {
+				break
+			}
+			msg, err := b.queue.Peek()
+			if err != nil {
+				break
+			}
+			start := time.Now()
+			_, err = o.Target.To(string(msg.Data))
+			statistics.Observe(statistics.OUTEND, o.UUID, len(msg.Data), time.Since(start), err)
+			if err != nil {
+				b.lock.Lock()
+				b.lastError = err.Error()
+				b.lock.Unlock()
+				statistics.IncOutFailed()
+				break
+			}
+			statistics.IncOut()
+			atomic.AddUint64(&b.replayed, 1)
+			if err := b.queue.Remove(msg.Seq); err != nil {
+				glogger.GLogger.Error("OutEnd buffer remove error:", err)
+				break
+			}
+		}
+		atomic.StoreInt32(&b.replaying, 0)
+	}
+}
+
+/*
+*
+* 清空缓存, 一般是删除 OutEnd 的时候调用
+*
+ */
+func (b *OutEndBuffer) Purge() error {
+	b.cancel()
+	return b.queue.Purge()
+}
+
+/*
+*
+* 缓存状态
+*
+ */
+func (o *OutEnd) BufferStatus() OutEndBufferStatus {
+	status := OutEndBufferStatus{UUID: o.UUID}
+	b := o.GetBuffer()
+	if b == nil {
+		return status
+	}
+	status.Enable = true
+	status.Messages = b.queue.Len()
+	status.Bytes = b.queue.Bytes()
+	status.MaxSize = b.queue.MaxBytes()
+	status.Dropped = b.queue.Dropped()
+	status.Replayed = atomic.LoadUint64(&b.replayed)
+	status.Replaying = atomic.LoadInt32(&b.replaying) == 1
+	if oldest := b.queue.OldestTime(); !oldest.IsZero() {
+		status.OldestAge = time.Since(oldest).Seconds()
+	}
+	b.lock.Lock()
+	status.LastError = b.lastError
+	b.lock.Unlock()
+	return status
+}
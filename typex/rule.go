@@ -1,10 +1,12 @@
 package typex
 
 import (
+	"sync/atomic"
+
 	lua "github.com/yuin/gopher-lua"
 )
 
-type RuleStatus int
+type RuleStatus int32
 
 const _VM_Registry_Size int = 1024 * 1024    // 默认堆栈大小
 const _VM_Registry_MaxSize int = 1024 * 1024 // 默认最大堆栈
@@ -21,17 +23,35 @@ const RULE_RUNNING RuleStatus = 1
 // 规则描述
 //
 type Rule struct {
-	Id          string      `json:"id"`
-	UUID        string      `json:"uuid"`
-	Status      RuleStatus  `json:"status"`
-	Name        string      `json:"name"`
-	FromSource  []string    `json:"fromSource"` // 来自数据源
-	FromDevice  []string    `json:"fromDevice"` // 来自设备
-	Actions     string      `json:"actions"`
-	Success     string      `json:"success"`
-	Failed      string      `json:"failed"`
-	Description string      `json:"description"`
-	VM          *lua.LState `json:"-"`
+	Id          string          `json:"id"`
+	UUID        string          `json:"uuid"`
+	Status      RuleStatus      `json:"status"`
+	Name        string          `json:"name"`
+	FromSource  []string        `json:"fromSource"` // 来自数据源
+	FromDevice  []string        `json:"fromDevice"` // 来自设备
+	FromTopic   []string        `json:"fromTopic"`  // 来自内部主题
+	Actions     string          `json:"actions"`
+	Success     string          `json:"success"`
+	Failed      string          `json:"failed"`
+	Description string          `json:"description"`
+	VM          *lua.LState     `json:"-"`
+	Limits      RuleLimits      `json:"limits"`     // 资源限制
+	Violations  *RuleViolations `json:"violations"` // 超过限制的次数
+	Schedule    *RuleSchedule   `json:"schedule"`   // 定时执行, 为空表示只由数据触发
+	Sql         string          `json:"sql"`        // SQL 规则, Actions 由它编译生成
+	OutEnds     []string        `json:"outEnds"`    // SQL 规则的输出目标
+	// 多个 worker 并行执行同一个规则时, 每个 worker 用自己的虚拟机
+	vmPool *luaVMPool
+	libs   map[string]func(*lua.LState) int
+}
+
+//
+// lua.LState 不是并发安全的, 所以每个规则维护一个虚拟机池
+//
+type luaVMPool struct {
+	vms     chan *lua.LState
+	created int32
+	max     int32
 }
 
 //
@@ -46,22 +66,104 @@ func NewRule(e RuleX,
 	success string,
 	actions string,
 	failed string) *Rule {
-	return &Rule{
+	r := &Rule{
 		UUID:        uuid,
 		Name:        name,
 		Description: description,
 		FromSource:  fromSource,
 		FromDevice:  fromDevice,
+		FromTopic:   []string{},
 		Status:      RULE_RUNNING, // 默认为启用
 		Actions:     actions,
 		Success:     success,
 		Failed:      failed,
-		VM: lua.NewState(lua.Options{
-			RegistrySize:     _VM_Registry_Size,
-			RegistryMaxSize:  _VM_Registry_MaxSize,
-			RegistryGrowStep: _VM_Registry_GrowStep,
-		}),
+		VM:          lua.NewState(DefaultRuleLimits().options()),
+		Limits:      DefaultRuleLimits(),
+		Violations:  &RuleViolations{},
+		libs:        map[string]func(*lua.LState) int{},
+	}
+	r.SetVMPoolSize(1)
+	return r
+}
+
+//
+// 规则状态在 HTTP 接口里面修改, worker 同时在读, 所以用原子操作
+//
+func (r *Rule) SetStatus(status RuleStatus) {
+	atomic.StoreInt32((*int32)(&r.Status), int32(status))
+}
+
+func (r *Rule) GetStatus() RuleStatus {
+	return RuleStatus(atomic.LoadInt32((*int32)(&r.Status)))
+}
+
+/*
+*
+* 设置虚拟机池大小, 原始的 VM 作为池子里的第一个虚拟机
+*
+ */
+func (r *Rule) SetVMPoolSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	pool := &luaVMPool{
+		vms:     make(chan *lua.LState, size),
+		created: 1,
+		max:     int32(size),
+	}
+	pool.vms <- r.VM
+	r.vmPool = pool
+}
+
+/*
+*
+* 从池子里取一个虚拟机, 用完以后必须 ReleaseVM
+*
+ */
+func (r *Rule) AcquireVM() (*lua.LState, error) {
+	pool := r.vmPool
+	select {
+	case vm := <-pool.vms:
+		return vm, nil
+	default:
+	}
+	if atomic.AddInt32(&pool.created, 1) <= pool.max {
+		vm, err := r.newVM()
+		if err != nil {
+			atomic.AddInt32(&pool.created, -1)
+			return nil, err
+		}
+		return vm, nil
+	}
+	atomic.AddInt32(&pool.created, -1)
+	return <-pool.vms, nil
+}
+
+/*
+*
+* 归还虚拟机
+*
+ */
+func (r *Rule) ReleaseVM(vm *lua.LState) {
+	r.vmPool.vms <- vm
+}
+
+//
+// 新建一个和 r.VM 一样的虚拟机: 同样的标准库和脚本
+//
+func (r *Rule) newVM() (*lua.LState, error) {
+	vm := lua.NewState(r.VM.Options)
+	for funcName, f := range r.libs {
+		vm.SetGlobal("rulexlib", vm.G.Global)
+		loadLib(vm.G.Global, vm, funcName, traceLib(funcName, f))
+	}
+	for _, script := range []string{r.Success, r.Failed, r.Actions} {
+		if err := vm.DoString(script); err != nil {
+			vm.Close()
+			return nil, err
+		}
 	}
+	return vm, nil
 }
 
 /*
@@ -84,7 +186,8 @@ func (r *Rule) AddLib(rx RuleX, funcName string, f func(*lua.LState) int) {
 	//
 	rulexTb := r.VM.G.Global
 	r.VM.SetGlobal("rulexlib", rulexTb)
-	loadLib(rulexTb, r.VM, funcName, f)
+	loadLib(rulexTb, r.VM, funcName, traceLib(funcName, f))
+	r.libs[funcName] = f
 }
 
 func loadLib(
@@ -0,0 +1,63 @@
+package typex
+
+import "sync"
+
+//
+// InEnd 和设备的 BindRules 在接口里面修改, worker 同时在遍历, 统一用这把锁
+//
+var bindLock sync.RWMutex
+
+func bindRule(rules map[string]*Rule, r *Rule) {
+	bindLock.Lock()
+	rules[r.UUID] = r
+	bindLock.Unlock()
+}
+
+func unbindRule(rules map[string]*Rule, ruleId string) {
+	bindLock.Lock()
+	delete(rules, ruleId)
+	bindLock.Unlock()
+}
+
+//
+// 拷贝一份再执行, 执行规则的时候不持有锁
+//
+func boundRules(rules map[string]*Rule) []*Rule {
+	bindLock.RLock()
+	defer bindLock.RUnlock()
+	list := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		list = append(list, r)
+	}
+	return list
+}
+
+// 绑定规则
+func (in *InEnd) BindRule(r *Rule) {
+	bindRule(in.BindRules, r)
+}
+
+// 解除绑定
+func (in *InEnd) UnbindRule(ruleId string) {
+	unbindRule(in.BindRules, ruleId)
+}
+
+// 当前绑定的规则
+func (in *InEnd) BoundRules() []*Rule {
+	return boundRules(in.BindRules)
+}
+
+// 绑定规则
+func (d *Device) BindRule(r *Rule) {
+	bindRule(d.BindRules, r)
+}
+
+// 解除绑定
+func (d *Device) UnbindRule(ruleId string) {
+	unbindRule(d.BindRules, ruleId)
+}
+
+// 当前绑定的规则
+func (d *Device) BoundRules() []*Rule {
+	return boundRules(d.BindRules)
+}
@@ -0,0 +1,119 @@
+package typex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+*
+* 规则的资源限制, 0 表示使用默认值
+*
+ */
+type RuleLimits struct {
+	Timeout         int `json:"timeout"`         // 单次回调最长执行时间, 单位毫秒, 0 表示不限制
+	CallStackSize   int `json:"callStackSize"`   // 最大函数调用深度
+	RegistrySize    int `json:"registrySize"`    // 初始寄存器大小
+	RegistryMaxSize int `json:"registryMaxSize"` // 最大寄存器(Lua 栈)槽数, 不限制表和字符串占用的内存
+}
+
+/*
+*
+* 规则超过限制的次数
+*
+ */
+type RuleViolations struct {
+	Timeout   uint64 `json:"timeout"`   // 执行超时
+	CallStack uint64 `json:"callStack"` // 调用太深
+	Registry  uint64 `json:"registry"`  // 寄存器溢出
+}
+
+//
+// 默认限制, 和以前写死的虚拟机参数一致
+//
+func DefaultRuleLimits() RuleLimits {
+	return RuleLimits{
+		Timeout:         0,
+		CallStackSize:   lua.CallStackSize,
+		RegistrySize:    _VM_Registry_Size,
+		RegistryMaxSize: _VM_Registry_MaxSize,
+	}
+}
+
+//
+// 没有配置的项用默认值
+//
+func (l RuleLimits) withDefaults() RuleLimits {
+	d := DefaultRuleLimits()
+	if l.Timeout < 0 {
+		l.Timeout = 0
+	}
+	if l.CallStackSize <= 0 {
+		l.CallStackSize = d.CallStackSize
+	}
+	if l.RegistrySize <= 0 {
+		l.RegistrySize = d.RegistrySize
+	}
+	if l.RegistryMaxSize <= 0 {
+		l.RegistryMaxSize = d.RegistryMaxSize
+	}
+	if l.RegistryMaxSize < l.RegistrySize {
+		l.RegistrySize = l.RegistryMaxSize
+	}
+	return l
+}
+
+func (l RuleLimits) options() lua.Options {
+	return lua.Options{
+		CallStackSize:    l.CallStackSize,
+		RegistrySize:     l.RegistrySize,
+		RegistryMaxSize:  l.RegistryMaxSize,
+		RegistryGrowStep: _VM_Registry_GrowStep,
+	}
+}
+
+/*
+*
+* 设置规则的限制: 虚拟机参数变了, 所以会重建虚拟机, 必须在 VerifyCallback 之前调用
+*
+ */
+func (r *Rule) SetLimits(limits RuleLimits) {
+	r.Limits = limits.withDefaults()
+	r.VM.Close()
+	r.VM = lua.NewState(r.Limits.options())
+	for funcName, f := range r.libs {
+		r.VM.SetGlobal("rulexlib", r.VM.G.Global)
+		loadLib(r.VM.G.Global, r.VM, funcName, traceLib(funcName, f))
+	}
+	r.SetVMPoolSize(int(r.vmPool.max))
+}
+
+/*
+*
+* 检查回调的错误是不是因为超过了限制, 是的话计数并且返回原因明确的错误;
+* gopher-lua 的栈溢出和寄存器溢出没有错误类型, 只能按错误信息判断
+*
+ */
+func (r *Rule) CheckViolation(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		atomic.AddUint64(&r.Violations.Timeout, 1)
+		return fmt.Errorf("rule limit exceeded: execution timeout(%vms)", r.Limits.Timeout)
+	}
+	if strings.Contains(err.Error(), "stack overflow") {
+		atomic.AddUint64(&r.Violations.CallStack, 1)
+		return fmt.Errorf("rule limit exceeded: call stack depth(%v)", r.Limits.CallStackSize)
+	}
+	if strings.Contains(err.Error(), "registry overflow") {
+		atomic.AddUint64(&r.Violations.Registry, 1)
+		return fmt.Errorf("rule limit exceeded: registry size(%v)", r.Limits.RegistryMaxSize)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package typex
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/utils"
+)
+
+//
+// 定时规则的消息来源类型
+//
+const ORIGIN_SCHEDULE string = "SCHEDULE"
+
+/*
+*
+* 规则的定时配置: cron 表达式和固定间隔二选一
+*
+ */
+type RuleSchedule struct {
+	Cron     string          `json:"cron"`     // 分 时 日 月 周, 比如 */5 * * * *
+	Interval int             `json:"interval"` // 固定间隔, 单位秒
+	Payload  string          `json:"payload"`  // 传给 Actions 的数据
+	Status   *ScheduleStatus `json:"status,omitempty"`
+	cron     *utils.CronExpr
+}
+
+/*
+*
+* 检查配置, 顺便解析 cron 表达式
+*
+ */
+func (s *RuleSchedule) Validate() error {
+	if s.Cron != "" && s.Interval > 0 {
+		return errors.New("schedule can not have both cron and interval")
+	}
+	if s.Cron == "" && s.Interval <= 0 {
+		return errors.New("schedule must have cron or interval")
+	}
+	if s.Cron != "" {
+		cron, err := utils.ParseCron(s.Cron)
+		if err != nil {
+			return err
+		}
+		s.cron = cron
+	}
+	return nil
+}
+
+//
+// 下一次执行时间
+//
+func (s *RuleSchedule) Next(t time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.Next(t)
+	}
+	return t.Add(time.Duration(s.Interval) * time.Second)
+}
+
+/*
+*
+* 定时执行的状态
+*
+ */
+type ScheduleStatus struct {
+	lock      sync.Mutex
+	lastRun   time.Time
+	nextRun   time.Time
+	runs      uint64
+	failures  uint64
+	lastError string
+}
+
+//
+// 记录下一次执行时间
+//
+func (s *ScheduleStatus) Planned(next time.Time) {
+	s.lock.Lock()
+	s.nextRun = next
+	s.lock.Unlock()
+}
+
+//
+// 记录一次执行结果
+//
+func (s *ScheduleStatus) Ran(at time.Time, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastRun = at
+	s.runs++
+	if err != nil {
+		s.failures++
+		s.lastError = err.Error()
+	}
+}
+
+func (s *ScheduleStatus) MarshalJSON() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return json.Marshal(map[string]interface{}{
+		"lastRun":   s.lastRun,
+		"nextRun":   s.nextRun,
+		"runs":      s.runs,
+		"failures":  s.failures,
+		"lastError": s.lastError,
+	})
+}
@@ -0,0 +1,303 @@
+package typex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+//
+// 规则追踪: 线上规则出问题的时候, 临时打开追踪记录每条消息的执行过程
+//
+var DefaultRuleTracer *RuleTracer = NewRuleTracer()
+
+//
+// 每个规则最多保留多少条追踪记录
+//
+const MAX_TRACE_RECORDS int = 200
+
+//
+// 每个监听者最多缓冲多少条, 满了直接丢弃, 慢的监听者不会拖慢队列
+//
+const TRACE_LISTENER_BUFFER int = 256
+
+/*
+*
+* 追踪会话: 追踪 N 条消息或者 M 秒, 先到为准, 0 表示不限制
+*
+ */
+type TraceSession struct {
+	RuleUUID    string    `json:"ruleUUID"`
+	MaxMessages int       `json:"maxMessages"`
+	Traced      int       `json:"traced"`
+	StartedAt   time.Time `json:"startedAt"`
+	ExpiredAt   time.Time `json:"expiredAt"`
+}
+
+func (s *TraceSession) finished(now time.Time) bool {
+	if s.MaxMessages > 0 && s.Traced >= s.MaxMessages {
+		return true
+	}
+	return !s.ExpiredAt.IsZero() && now.After(s.ExpiredAt)
+}
+
+/*
+*
+* Actions 管道里面一个函数的返回值
+*
+ */
+type TraceStep struct {
+	Step   int    `json:"step"`
+	Next   string `json:"next"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+/*
+*
+* 一次 rulexlib 调用
+*
+ */
+type TraceCall struct {
+	Lib  string   `json:"lib"`
+	Args []string `json:"args"`
+}
+
+/*
+*
+* 一条消息的追踪记录
+*
+ */
+type TraceRecord struct {
+	RuleUUID  string      `json:"ruleUUID"`
+	TraceId   string      `json:"traceId"` // 消息的跟踪ID
+	Input     string      `json:"input"`
+	Steps     []TraceStep `json:"steps"`
+	Callback  string      `json:"callback"` // Success 或者 Failed
+	Error     string      `json:"error,omitempty"`
+	Calls     []TraceCall `json:"calls"`
+	StartedAt time.Time   `json:"startedAt"`
+	Duration  int64       `json:"duration"` // 执行时间, 单位微秒
+}
+
+//
+// 记录一次 rulexlib 调用, 规则在一个 worker 里面同步执行, 不需要加锁
+//
+func (t *TraceRecord) AddCall(lib string, args []string) {
+	t.Calls = append(t.Calls, TraceCall{Lib: lib, Args: args})
+}
+
+//
+// 记录管道的一步
+//
+func (t *TraceRecord) AddStep(step TraceStep) {
+	t.Steps = append(t.Steps, step)
+}
+
+/*
+*
+* 规则追踪器
+*
+ */
+type RuleTracer struct {
+	lock      sync.Mutex
+	sessions  map[string]*TraceSession
+	records   map[string][]TraceRecord
+	listeners []chan TraceRecord
+	dropped   uint64
+}
+
+func NewRuleTracer() *RuleTracer {
+	return &RuleTracer{
+		sessions: map[string]*TraceSession{},
+		records:  map[string][]TraceRecord{},
+	}
+}
+
+/*
+*
+* 打开追踪, 会覆盖正在进行的追踪, 之前的记录保留
+*
+ */
+func (t *RuleTracer) Start(ruleUUID string, maxMessages int, duration time.Duration) (TraceSession, error) {
+	if maxMessages <= 0 && duration <= 0 {
+		return TraceSession{}, errors.New("maxMessages or duration must be set")
+	}
+	session := &TraceSession{
+		RuleUUID:    ruleUUID,
+		MaxMessages: maxMessages,
+		StartedAt:   time.Now(),
+	}
+	if duration > 0 {
+		session.ExpiredAt = session.StartedAt.Add(duration)
+	}
+	t.lock.Lock()
+	t.sessions[ruleUUID] = session
+	t.lock.Unlock()
+	return *session, nil
+}
+
+//
+// 关闭追踪
+//
+func (t *RuleTracer) Stop(ruleUUID string) {
+	t.lock.Lock()
+	delete(t.sessions, ruleUUID)
+	t.lock.Unlock()
+}
+
+//
+// 正在进行的追踪
+//
+func (t *RuleTracer) Sessions() []TraceSession {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	sessions := []TraceSession{}
+	for uuid, s := range t.sessions {
+		if s.finished(now) {
+			delete(t.sessions, uuid)
+			continue
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions
+}
+
+/*
+*
+* 开始执行一条消息, 规则没有在追踪就返回 nil
+*
+ */
+func (t *RuleTracer) Begin(ruleUUID string, envelope *Envelope) *TraceRecord {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	session, ok := t.sessions[ruleUUID]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if session.finished(now) {
+		delete(t.sessions, ruleUUID)
+		return nil
+	}
+	session.Traced++
+	return &TraceRecord{
+		RuleUUID:  ruleUUID,
+		TraceId:   envelope.TraceId,
+		Input:     envelope.String(),
+		Steps:     []TraceStep{},
+		Calls:     []TraceCall{},
+		StartedAt: now,
+	}
+}
+
+/*
+*
+* 消息执行完成: 保存记录并且通知监听者
+*
+ */
+func (t *RuleTracer) Finish(record *TraceRecord) {
+	record.Duration = time.Since(record.StartedAt).Microseconds()
+	r := *record
+	t.lock.Lock()
+	records := append(t.records[r.RuleUUID], r)
+	if len(records) > MAX_TRACE_RECORDS {
+		records = records[len(records)-MAX_TRACE_RECORDS:]
+	}
+	t.records[r.RuleUUID] = records
+	listeners := t.listeners
+	t.lock.Unlock()
+	for _, ch := range listeners {
+		select {
+		case ch <- r:
+		default:
+			atomic.AddUint64(&t.dropped, 1)
+		}
+	}
+}
+
+//
+// 监听者缓冲满了被丢弃的记录数
+//
+func (t *RuleTracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+//
+// 规则的追踪记录, 按时间顺序
+//
+func (t *RuleTracer) Records(ruleUUID string) []TraceRecord {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]TraceRecord{}, t.records[ruleUUID]...)
+}
+
+//
+// 清空规则的追踪记录
+//
+func (t *RuleTracer) Clear(ruleUUID string) {
+	t.lock.Lock()
+	delete(t.records, ruleUUID)
+	t.lock.Unlock()
+}
+
+//
+// 监听新的追踪记录, 比如推送到 websocket; 监听函数在单独的协程里面执行
+//
+func (t *RuleTracer) OnRecord(f func(TraceRecord)) {
+	ch := make(chan TraceRecord, TRACE_LISTENER_BUFFER)
+	go func() {
+		for r := range ch {
+			f(r)
+		}
+	}()
+	t.lock.Lock()
+	t.listeners = append(t.listeners, ch)
+	t.lock.Unlock()
+}
+
+type traceCtxKey struct{}
+
+//
+// 把追踪记录放到 context 里面, 规则执行的时候 rulexlib 调用会被记录下来
+//
+func WithTraceRecord(ctx context.Context, record *TraceRecord) context.Context {
+	if record == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceCtxKey{}, record)
+}
+
+//
+// 虚拟机当前的追踪记录, 没有在追踪返回 nil
+//
+func CurrentTraceRecord(vm *lua.LState) *TraceRecord {
+	ctx := vm.Context()
+	if ctx == nil {
+		return nil
+	}
+	r, _ := ctx.Value(traceCtxKey{}).(*TraceRecord)
+	return r
+}
+
+//
+// 包装库函数: 追踪的时候记录调用参数
+//
+func traceLib(funcName string, f func(*lua.LState) int) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		if record := CurrentTraceRecord(l); record != nil {
+			args := []string{}
+			// 第一个参数是 rulexlib 自己
+			for i := 2; i <= l.GetTop(); i++ {
+				args = append(args, l.Get(i).String())
+			}
+			record.AddCall(funcName, args)
+		}
+		return f(l)
+	}
+}
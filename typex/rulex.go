@@ -9,16 +9,41 @@ import (
 //
 // Global config
 //
+
 type RulexConfig struct {
-	MaxQueueSize          int    `ini:"max_queue_size" json:"maxQueueSize"`
-	SourceRestartInterval int    `ini:"resource_restart_interval" json:"sourceRestartInterval"`
-	GomaxProcs            int    `ini:"gomax_procs" json:"gomaxProcs"`
-	EnablePProf           bool   `ini:"enable_pprof" json:"enablePProf"`
-	EnableConsole         bool   `ini:"enable_console" json:"enableConsole"`
-	LogLevel              string `ini:"log_level" json:"logLevel"`
-	LogPath               string `ini:"log_path" json:"logPath"`
-	LuaLogPath            string `ini:"lua_log_path" json:"luaLogPath"`
-	MaxStoreSize          int    `ini:"max_store_size" json:"maxStoreSize"`
+	MaxQueueSize             int     `ini:"max_queue_size" json:"maxQueueSize"`
+	WorkerPoolSize           int     `ini:"worker_pool_size" json:"workerPoolSize"`
+	QueueOverflowPolicy      string  `ini:"queue_overflow_policy" json:"queueOverflowPolicy"`
+	QueueBlockTimeout        int     `ini:"queue_block_timeout" json:"queueBlockTimeout"`
+	QueueSpillMaxSize        int64   `ini:"queue_spill_max_size" json:"queueSpillMaxSize"`
+	SourceRestartInterval    int     `ini:"resource_restart_interval" json:"sourceRestartInterval"`
+	SourceRestartMaxInterval int     `ini:"resource_restart_max_interval" json:"sourceRestartMaxInterval"`
+	SourceRestartJitter      float64 `ini:"resource_restart_jitter" json:"sourceRestartJitter"`
+	SourceRestartMaxAttempts int     `ini:"resource_restart_max_attempts" json:"sourceRestartMaxAttempts"`
+	SourceRestartCooldown    int     `ini:"resource_restart_cooldown" json:"sourceRestartCooldown"`
+	GomaxProcs               int     `ini:"gomax_procs" json:"gomaxProcs"`
+	EnablePProf              bool    `ini:"enable_pprof" json:"enablePProf"`
+	EnableConsole            bool    `ini:"enable_console" json:"enableConsole"`
+	LogLevel                 string  `ini:"log_level" json:"logLevel"`
+	LogPath                  string  `ini:"log_path" json:"logPath"`
+	LuaLogPath               string  `ini:"lua_log_path" json:"luaLogPath"`
+	LogMaxSize               int64   `ini:"log_max_size" json:"logMaxSize"`
+	LogRotateInterval        int     `ini:"log_rotate_interval" json:"logRotateInterval"`
+	LogMaxBackups            int     `ini:"log_max_backups" json:"logMaxBackups"`
+	LogCompress              bool    `ini:"log_compress" json:"logCompress"`
+	MaxStoreSize             int     `ini:"max_store_size" json:"maxStoreSize"`
+	StoreType                string  `ini:"store_type" json:"storeType"`
+	StorePath                string  `ini:"store_path" json:"storePath"`
+	StoreSyncMode            string  `ini:"store_sync_mode" json:"storeSyncMode"`
+	StoreSnapshotInterval    int     `ini:"store_snapshot_interval" json:"storeSnapshotInterval"`
+	StoreMaxDiskSize         int64   `ini:"store_max_disk_size" json:"storeMaxDiskSize"`
+	HistoryRawRetention      int     `ini:"history_raw_retention" json:"historyRawRetention"`
+	HistoryDownsample        int     `ini:"history_downsample_interval" json:"historyDownsampleInterval"`
+	HistoryRetention         int     `ini:"history_downsample_retention" json:"historyDownsampleRetention"`
+	HistoryMaxSeries         int     `ini:"history_max_series" json:"historyMaxSeries"`
+	HistoryMaxPoints         int     `ini:"history_max_points" json:"historyMaxPoints"`
+	BufferPath               string  `ini:"buffer_path" json:"bufferPath"`
+	DeadLetterMaxSize        int64   `ini:"dead_letter_max_size" json:"deadLetterMaxSize"`
 }
 
 //
@@ -36,12 +61,28 @@ type RuleX interface {
 	PushInQueue(in *InEnd, data string) error
 	PushOutQueue(out *OutEnd, data string) error
 	PushDeviceQueue(device *Device, data string) error
+	PushTopicQueue(topic string, envelope *Envelope) error
 	//
 	// 执行任务
 	//
 	WorkInEnd(*InEnd, string) (bool, error)
 	WorkDevice(*Device, string) (bool, error)
 	//
+	// 执行任务: 带元数据的消息
+	//
+	WorkInEndEnvelope(*InEnd, *Envelope) (bool, error)
+	WorkDeviceEnvelope(*Device, *Envelope) (bool, error)
+	//
+	// 暂停和恢复: 保留配置和规则绑定; InEnd 和 OutEnd 保留连接, 只是不再接收或者投递数据,
+	// 设备会停下来释放硬件, 恢复的时候重新启动
+	//
+	PauseInEnd(uuid string) error
+	ResumeInEnd(uuid string) error
+	PauseOutEnd(uuid string) error
+	ResumeOutEnd(uuid string) error
+	PauseDevice(uuid string) error
+	ResumeDevice(uuid string) error
+	//
 	// 获取配置
 	//
 	GetConfig() *RulexConfig
@@ -114,10 +155,24 @@ type RuleX interface {
 	//
 	RemoveRule(uuid string)
 	//
+	// 卸载规则: 和删除一样解除绑定, 但是保留统计数据, 更新规则的时候用
+	//
+	UnloadRule(uuid string)
+	//
+	// 启用和停用规则
+	//
+	StartRule(uuid string) error
+	StopRule(uuid string) error
+	//
 	// 运行 lua 回调
 	//
-	RunSourceCallbacks(*InEnd, string)
-	RunDeviceCallbacks(*Device, string)
+	RunSourceCallbacks(*InEnd, *Envelope)
+	RunDeviceCallbacks(*Device, *Envelope)
+	RunTopicCallbacks(string, *Envelope)
+	//
+	// 试运行规则, 不会产生副作用
+	//
+	DryRunRule(success string, actions string, failed string, limits RuleLimits, payloads []string) ([]DryRunResult, error)
 	//
 	// 运行 hook
 	//
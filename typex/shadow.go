@@ -0,0 +1,330 @@
+package typex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/i4de/rulex/glogger"
+)
+
+//
+// 资源影子: 每个 InEnd 和设备的上报值和期望值
+//
+var DefaultShadows *ShadowStore
+
+//
+// 期望值和上报值的差异通过这个 Topic 投递给规则
+//
+const SHADOW_DELTA_TOPIC string = "$shadow/delta"
+
+//
+// 一个字段的值和更新时间
+//
+type ShadowValue struct {
+	Value     interface{} `json:"value"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+/*
+*
+* 影子: reported 是设备上报的值, desired 是客户端期望的值,
+* 两者不一样的字段就是 delta, 需要下发给设备
+*
+ */
+type Shadow struct {
+	UUID     string                 `json:"uuid"`
+	Reported map[string]ShadowValue `json:"reported"`
+	Desired  map[string]ShadowValue `json:"desired"`
+	Version  uint64                 `json:"version"` // 每次修改加一
+}
+
+func newShadow(uuid string) *Shadow {
+	return &Shadow{
+		UUID:     uuid,
+		Reported: map[string]ShadowValue{},
+		Desired:  map[string]ShadowValue{},
+	}
+}
+
+//
+// 期望值和上报值不一样的字段, 数字和字符串按字面值比较
+//
+func (s *Shadow) Delta() map[string]interface{} {
+	delta := map[string]interface{}{}
+	for k, desired := range s.Desired {
+		reported, ok := s.Reported[k]
+		if !ok || fmt.Sprint(reported.Value) != fmt.Sprint(desired.Value) {
+			delta[k] = desired.Value
+		}
+	}
+	return delta
+}
+
+func (s *Shadow) copy() Shadow {
+	c := *newShadow(s.UUID)
+	c.Version = s.Version
+	for k, v := range s.Reported {
+		c.Reported[k] = v
+	}
+	for k, v := range s.Desired {
+		c.Desired[k] = v
+	}
+	return c
+}
+
+/*
+*
+* 影子存储, 内存里面保存, 定时刷到磁盘
+*
+ */
+type ShadowStore struct {
+	lock    sync.RWMutex
+	path    string
+	dirty   bool
+	shadows map[string]*Shadow
+	pending map[string]bool // 下发失败, 等设备上线或者下次上报的时候重发
+	cancel  context.CancelFunc
+}
+
+/*
+*
+* 启动影子存储, 加载上次保存的影子
+*
+ */
+func StartShadowStore(basePath string) error {
+	store := &ShadowStore{
+		path:    filepath.Join(basePath, "shadow.json"),
+		shadows: map[string]*Shadow{},
+		pending: map[string]bool{},
+	}
+	b, err := os.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &store.shadows); err != nil {
+			return err
+		}
+	}
+	if DefaultShadows != nil {
+		if err := DefaultShadows.Close(); err != nil {
+			glogger.GLogger.Error("Shadow flush error:", err)
+		}
+	}
+	DefaultShadows = store
+	ctx, cancel := context.WithCancel(GCTX)
+	store.cancel = cancel
+	go func(ctx context.Context) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := store.Flush(); err != nil {
+				glogger.GLogger.Error("Shadow flush error:", err)
+			}
+		}
+	}(ctx)
+	return nil
+}
+
+/*
+*
+* 停止: 停掉定时刷盘, 最后刷一次
+*
+ */
+func (s *ShadowStore) Close() error {
+	s.cancel()
+	return s.Flush()
+}
+
+//
+// 更新上报值
+//
+func (s *ShadowStore) Report(uuid string, values map[string]interface{}) Shadow {
+	return s.update(uuid, values, func(shadow *Shadow) map[string]ShadowValue {
+		return shadow.Reported
+	})
+}
+
+//
+// 更新期望值, 值为 nil 表示删除这个字段
+//
+func (s *ShadowStore) Desire(uuid string, values map[string]interface{}) Shadow {
+	return s.update(uuid, values, func(shadow *Shadow) map[string]ShadowValue {
+		return shadow.Desired
+	})
+}
+
+func (s *ShadowStore) update(uuid string, values map[string]interface{},
+	fields func(*Shadow) map[string]ShadowValue) Shadow {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	shadow, ok := s.shadows[uuid]
+	if !ok {
+		shadow = newShadow(uuid)
+		s.shadows[uuid] = shadow
+	}
+	now := time.Now()
+	m := fields(shadow)
+	for k, v := range values {
+		if v == nil {
+			delete(m, k)
+			continue
+		}
+		m[k] = ShadowValue{Value: v, Timestamp: now}
+	}
+	shadow.Version++
+	s.dirty = true
+	return shadow.copy()
+}
+
+//
+// 获取影子
+//
+func (s *ShadowStore) Get(uuid string) (Shadow, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if shadow, ok := s.shadows[uuid]; ok {
+		return shadow.copy(), true
+	}
+	return Shadow{}, false
+}
+
+//
+// 所有影子
+//
+func (s *ShadowStore) All() []Shadow {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	shadows := make([]Shadow, 0, len(s.shadows))
+	for _, shadow := range s.shadows {
+		shadows = append(shadows, shadow.copy())
+	}
+	return shadows
+}
+
+//
+// 删除影子, 资源被删除的时候调用
+//
+func (s *ShadowStore) Remove(uuid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.shadows[uuid]; ok {
+		delete(s.shadows, uuid)
+		s.dirty = true
+	}
+	delete(s.pending, uuid)
+}
+
+/*
+*
+* 有修改就写到磁盘, 先写临时文件再改名, 防止写一半断电
+*
+ */
+func (s *ShadowStore) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	b, err := json.Marshal(s.shadows)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+/*
+*
+* 下发差异: 设备绑定了规则就交给规则处理, 否则直接写设备;
+* InEnd 交给绑定的规则处理, 信封的 Topic 是 SHADOW_DELTA_TOPIC;
+* 失败的时候记下来, 之后通过 Redeliver 重发
+*
+ */
+func (s *ShadowStore) DeliverDelta(e RuleX, uuid string) error {
+	err := s.deliverDelta(e, uuid)
+	s.lock.Lock()
+	if err != nil {
+		s.pending[uuid] = true
+	} else {
+		delete(s.pending, uuid)
+	}
+	s.lock.Unlock()
+	return err
+}
+
+//
+// 重发上次失败的差异, 设备重新上线或者有新的上报的时候调用
+//
+func (s *ShadowStore) Redeliver(e RuleX, uuid string) {
+	s.lock.RLock()
+	pending := s.pending[uuid]
+	s.lock.RUnlock()
+	if !pending {
+		return
+	}
+	if err := s.DeliverDelta(e, uuid); err != nil {
+		glogger.GLogger.Debugf("Shadow [%v] redeliver delta failed: %v", uuid, err)
+	}
+}
+
+func (s *ShadowStore) deliverDelta(e RuleX, uuid string) error {
+	shadow, ok := s.Get(uuid)
+	if !ok {
+		return nil
+	}
+	delta := shadow.Delta()
+	if len(delta) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	envelope := NewEnvelope(payload)
+	envelope.Topic = SHADOW_DELTA_TOPIC
+	envelope.ContentType = "application/json"
+	if device := e.GetDevice(uuid); device != nil {
+		if device.Paused() {
+			return errors.New("device paused:" + uuid)
+		}
+		if device.Device == nil || device.GetState() != DEV_RUNNING {
+			return errors.New("device offline:" + uuid)
+		}
+		if len(device.BoundRules()) > 0 {
+			_, err := e.WorkDeviceEnvelope(device, envelope)
+			return err
+		}
+		_, err := device.Device.OnWrite(payload)
+		return err
+	}
+	if in := e.GetInEnd(uuid); in != nil {
+		if in.Paused() {
+			return errors.New("inend paused:" + uuid)
+		}
+		_, err := e.WorkInEndEnvelope(in, envelope)
+		return err
+	}
+	return errors.New("inend or device not exists:" + uuid)
+}
@@ -1,5 +1,7 @@
 package typex
 
+import "time"
+
 /*
 *
 * 缓存器接口
@@ -8,13 +10,17 @@ package typex
 type XStore interface {
 	// 设置值
 	Set(k string, v string)
+	// 设置值并且指定过期时间
+	SetWithTTL(k string, v string, ttl time.Duration)
 	// 获取值
 	Get(k string) string
 	// 删除值
 	Delete(k string) error
 	// 统计数量
 	Count() int
-	// 模糊查询匹配
+	// 原子加, 返回加以后的值
+	Incr(k string, delta int64) (int64, error)
+	// 模糊查询匹配, 返回匹配的 Key
 	// 支持: *AAA AAA* A*B
-	FuzzyGet(k string) string
+	FuzzyGet(k string) []string
 }
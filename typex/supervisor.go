@@ -0,0 +1,261 @@
+package typex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+*
+* 资源重启策略: 指数退避 + 随机抖动, 连续失败太多次以后熔断,
+* 熔断期间不再重启, 冷却时间过了以后再试一次
+*
+ */
+type RestartPolicy struct {
+	Interval    time.Duration // 健康检查间隔, 也是第一次重试的等待时间
+	MaxInterval time.Duration // 退避的最长等待时间
+	Multiplier  float64       // 每次失败以后等待时间乘以这个数
+	Jitter      float64       // 随机抖动比例, 0.2 表示上下浮动 20%
+	MaxAttempts int           // 连续失败多少次以后熔断, 0 表示不熔断
+	Cooldown    time.Duration // 熔断以后多久再试
+}
+
+//
+// 从全局配置生成重启策略, 没配置的项用默认值
+//
+func NewRestartPolicy(config RulexConfig) RestartPolicy {
+	p := RestartPolicy{
+		Interval:    5 * time.Second,
+		MaxInterval: 60 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+		MaxAttempts: 10,
+		Cooldown:    5 * time.Minute,
+	}
+	if config.SourceRestartInterval > 0 {
+		p.Interval = time.Duration(config.SourceRestartInterval) * time.Millisecond
+	}
+	if config.SourceRestartMaxInterval > 0 {
+		p.MaxInterval = time.Duration(config.SourceRestartMaxInterval) * time.Millisecond
+	}
+	if p.MaxInterval < p.Interval {
+		p.MaxInterval = p.Interval
+	}
+	// 抖动和熔断次数配置成 0 表示关闭
+	if config.SourceRestartJitter >= 0 && config.SourceRestartJitter < 1 {
+		p.Jitter = config.SourceRestartJitter
+	}
+	if config.SourceRestartMaxAttempts >= 0 {
+		p.MaxAttempts = config.SourceRestartMaxAttempts
+	}
+	if config.SourceRestartCooldown > 0 {
+		p.Cooldown = time.Duration(config.SourceRestartCooldown) * time.Millisecond
+	}
+	return p
+}
+
+//
+// 第 n 次连续失败以后的等待时间
+//
+func (p RestartPolicy) Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return p.Interval
+	}
+	d := float64(p.Interval) * math.Pow(p.Multiplier, float64(failures-1))
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		d = d * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(d)
+}
+
+/*
+*
+* 资源守护: 定时检查资源状态, 挂了以后按照策略重启
+*
+ */
+type Supervisor struct {
+	policy      RestartPolicy
+	lock        sync.Mutex
+	restarts    uint64    // 总共重启了多少次
+	failures    int       // 连续失败次数, 恢复以后清零
+	lastError   string    // 最后一次失败原因
+	lastRestart time.Time // 最后一次重启时间
+	open        bool      // 是否熔断
+	nextAttempt time.Time // 熔断以后下一次尝试的时间
+	cancel      context.CancelFunc
+}
+
+//
+// 守护协程检查完以后同步状态, 这期间资源可能被暂停了, 暂停状态不能被覆盖
+//
+func updateState(state *int32, s int32, paused int32) bool {
+	for {
+		old := atomic.LoadInt32(state)
+		if old == paused {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(state, old, s) {
+			return true
+		}
+	}
+}
+
+func NewSupervisor(policy RestartPolicy) *Supervisor {
+	return &Supervisor{policy: policy}
+}
+
+/*
+*
+* 资源需要实现的几个回调:
+* Alive: 资源还在不在, 被删除了就退出;
+* Healthy: 资源是否正常;
+* Restart: 重启资源;
+* Failed: 熔断的时候调用, 用来把资源状态改成 FAILED
+*
+ */
+type SupervisorHooks struct {
+	Alive   func() bool
+	Healthy func() bool
+	Restart func() error
+	Failed  func()
+}
+
+//
+// 启动守护协程
+//
+func (s *Supervisor) Start(hooks SupervisorHooks) {
+	ctx, cancel := context.WithCancel(GCTX)
+	s.lock.Lock()
+	s.cancel = cancel
+	s.lock.Unlock()
+	go func(ctx context.Context) {
+		timer := time.NewTimer(s.wait())
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			if !hooks.Alive() {
+				return
+			}
+			s.Check(hooks)
+			timer.Reset(s.wait())
+		}
+	}(ctx)
+}
+
+//
+// 停止守护协程, 删除资源的时候调用
+//
+func (s *Supervisor) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// 距离下一次检查的时间
+func (s *Supervisor) wait() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.open {
+		if d := time.Until(s.nextAttempt); d > 0 {
+			return d
+		}
+		return s.policy.Interval
+	}
+	return s.policy.Backoff(s.failures)
+}
+
+/*
+*
+* 检查一次: 正常就清零失败次数, 不正常就重启, 连续失败到上限就熔断
+*
+ */
+func (s *Supervisor) Check(hooks SupervisorHooks) {
+	if hooks.Healthy() {
+		s.lock.Lock()
+		s.failures = 0
+		s.open = false
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Lock()
+	if s.open && time.Now().Before(s.nextAttempt) {
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+	err := hooks.Restart()
+	if err == nil && !hooks.Healthy() {
+		err = errors.New("resource is still down after restart")
+	}
+	s.lock.Lock()
+	s.restarts++
+	s.lastRestart = time.Now()
+	if err == nil {
+		s.failures = 0
+		s.open = false
+		s.lock.Unlock()
+		return
+	}
+	s.failures++
+	s.lastError = err.Error()
+	failed := false
+	if s.policy.MaxAttempts > 0 && s.failures >= s.policy.MaxAttempts {
+		s.open = true
+		s.nextAttempt = time.Now().Add(s.policy.Cooldown)
+		failed = true
+	}
+	s.lock.Unlock()
+	if failed && hooks.Failed != nil {
+		hooks.Failed()
+	}
+}
+
+//
+// 是否熔断
+//
+func (s *Supervisor) Failed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.open
+}
+
+//
+// 总重启次数
+//
+func (s *Supervisor) Restarts() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.restarts
+}
+
+func (s *Supervisor) MarshalJSON() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := map[string]interface{}{
+		"restarts":    s.restarts,
+		"failures":    s.failures,
+		"lastError":   s.lastError,
+		"lastRestart": s.lastRestart,
+		"failed":      s.open,
+	}
+	if s.open {
+		status["nextAttempt"] = s.nextAttempt
+	}
+	return json.Marshal(status)
+}
@@ -0,0 +1,210 @@
+package typex
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+//
+// 内部主题总线: 规则可以把处理结果发布到内部主题, 订阅了主题的规则继续处理
+//
+var DefaultTopicBus *TopicBus = NewTopicBus()
+
+//
+// 一条消息最多经过多少个内部主题, 防止规则之间无限转发
+//
+const MAX_TOPIC_HOPS int = 16
+
+//
+// 没有订阅者的主题最多保留多少个统计, 主题名是规则随便写的, 不限制的话会一直涨
+//
+const _MAX_IDLE_TOPICS int = 256
+
+/*
+*
+* 内部主题统计
+*
+ */
+type TopicStatistics struct {
+	Topic        string `json:"topic"`
+	Subscribers  int    `json:"subscribers"`  // 订阅的规则数量
+	Published    uint64 `json:"published"`    // 发布次数
+	Delivered    uint64 `json:"delivered"`    // 投递给规则的次数
+	NoSubscriber uint64 `json:"noSubscriber"` // 没有订阅者被丢弃
+	Loops        uint64 `json:"loops"`        // 检测到环路被丢弃
+	Failed       uint64 `json:"failed"`       // 进队列失败
+}
+
+type topicCounter struct {
+	published    uint64
+	delivered    uint64
+	noSubscriber uint64
+	loops        uint64
+	failed       uint64
+}
+
+/*
+*
+* 主题总线, 订阅关系: 主题 -> 规则UUID -> 规则
+*
+ */
+type TopicBus struct {
+	lock     sync.RWMutex
+	rules    map[string]map[string]*Rule
+	counters map[string]*topicCounter
+}
+
+func NewTopicBus() *TopicBus {
+	return &TopicBus{
+		rules:    map[string]map[string]*Rule{},
+		counters: map[string]*topicCounter{},
+	}
+}
+
+//
+// 规则订阅主题
+//
+func (b *TopicBus) Subscribe(topic string, r *Rule) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.rules[topic]; !ok {
+		b.rules[topic] = map[string]*Rule{}
+	}
+	b.rules[topic][r.UUID] = r
+	b.counter(topic)
+}
+
+//
+// 取消规则的所有订阅
+//
+func (b *TopicBus) Unsubscribe(ruleUUID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for topic, rules := range b.rules {
+		delete(rules, ruleUUID)
+		if len(rules) == 0 {
+			delete(b.rules, topic)
+		}
+	}
+}
+
+//
+// 订阅了主题的规则
+//
+func (b *TopicBus) Subscribers(topic string) []*Rule {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	rules := []*Rule{}
+	for _, r := range b.rules[topic] {
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+/*
+*
+* 发布消息: parent 是正在处理的消息, 新消息继承它的跟踪ID和经过的主题,
+* 主题已经出现过或者经过的主题太多就认为是环路, 直接丢弃
+*
+ */
+func (b *TopicBus) Publish(e RuleX, parent *Envelope, topic string, data string) error {
+	if topic == "" {
+		return errors.New("topic can not be empty")
+	}
+	b.lock.Lock()
+	c := b.counter(topic)
+	subscribers := len(b.rules[topic])
+	b.lock.Unlock()
+	atomic.AddUint64(&c.published, 1)
+
+	envelope := NewEnvelope([]byte(data))
+	envelope.Origin = topic
+	envelope.OriginType = ORIGIN_TOPIC
+	envelope.Topic = topic
+	if parent != nil {
+		for _, hop := range parent.Hops {
+			if hop == topic {
+				atomic.AddUint64(&c.loops, 1)
+				return errors.New("topic loop detected:" + topic)
+			}
+		}
+		if len(parent.Hops) >= MAX_TOPIC_HOPS {
+			atomic.AddUint64(&c.loops, 1)
+			return errors.New("too many topic hops:" + topic)
+		}
+		envelope.TraceId = parent.TraceId
+		envelope.Hops = append(envelope.Hops, parent.Hops...)
+	}
+	envelope.Hops = append(envelope.Hops, topic)
+	if subscribers == 0 {
+		atomic.AddUint64(&c.noSubscriber, 1)
+		return nil
+	}
+	if err := e.PushTopicQueue(topic, envelope); err != nil {
+		atomic.AddUint64(&c.failed, 1)
+		return err
+	}
+	return nil
+}
+
+//
+// 规则处理了一条主题消息
+//
+func (b *TopicBus) Delivered(topic string) {
+	b.lock.Lock()
+	c := b.counter(topic)
+	b.lock.Unlock()
+	atomic.AddUint64(&c.delivered, 1)
+}
+
+//
+// 所有主题的统计, 按主题排序
+//
+func (b *TopicBus) Statistics() []TopicStatistics {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	stats := []TopicStatistics{}
+	for topic, c := range b.counters {
+		stats = append(stats, TopicStatistics{
+			Topic:        topic,
+			Subscribers:  len(b.rules[topic]),
+			Published:    atomic.LoadUint64(&c.published),
+			Delivered:    atomic.LoadUint64(&c.delivered),
+			NoSubscriber: atomic.LoadUint64(&c.noSubscriber),
+			Loops:        atomic.LoadUint64(&c.loops),
+			Failed:       atomic.LoadUint64(&c.failed),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Topic < stats[j].Topic
+	})
+	return stats
+}
+
+// 调用的时候必须持有写锁
+func (b *TopicBus) counter(topic string) *topicCounter {
+	c, ok := b.counters[topic]
+	if !ok {
+		if len(b.rules[topic]) == 0 {
+			b.pruneIdle(_MAX_IDLE_TOPICS - 1)
+		}
+		c = &topicCounter{}
+		b.counters[topic] = c
+	}
+	return c
+}
+
+// 没有订阅者的主题统计超过 max 个的时候删掉多出来的, 调用的时候必须持有写锁
+func (b *TopicBus) pruneIdle(max int) {
+	idle := []string{}
+	for topic := range b.counters {
+		if len(b.rules[topic]) == 0 {
+			idle = append(idle, topic)
+		}
+	}
+	for i := 0; i < len(idle)-max; i++ {
+		delete(b.counters, idle[i])
+	}
+}
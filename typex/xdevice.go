@@ -7,14 +7,20 @@
 package typex
 
 import (
+	"sync/atomic"
+
 	"github.com/i4de/rulex/utils"
 )
 
-type DeviceState int
+type DeviceState int32
 
 const (
 	DEV_STOP    DeviceState = 0
 	DEV_RUNNING DeviceState = 1
+	// 连续重启失败, 已经熔断
+	DEV_FAILED DeviceState = 2
+	// 暂停: 不再接收设备数据, 也不往设备写数据
+	DEV_PAUSE DeviceState = 3
 )
 
 type DeviceType string
@@ -35,10 +41,11 @@ type Device struct {
 	Type         DeviceType             `json:"type"`         // 类型,一般是设备-型号，比如 ARDUINO-R3
 	ActionScript string                 `json:"actionScript"` // 当收到指令的时候响应脚本
 	Description  string                 `json:"description"`  // 设备描述信息
-	BindRules    map[string]Rule        `json:"-"`
+	BindRules    map[string]*Rule       `json:"-"`
 	State        DeviceState            `json:"state"`  // 状态
 	Config       map[string]interface{} `json:"config"` // 配置
 	Device       XDevice                `json:"-"`
+	Supervisor   *Supervisor            `json:"supervisor"` // 重启状态
 }
 
 func NewDevice(t DeviceType,
@@ -53,10 +60,35 @@ func NewDevice(t DeviceType,
 		State:       DEV_STOP,
 		Description: description,
 		Config:      config,
-		BindRules:   map[string]Rule{},
+		BindRules:   map[string]*Rule{},
 	}
 }
 
+//
+// 是否暂停
+//
+func (d *Device) Paused() bool {
+	return d.GetState() == DEV_PAUSE
+}
+
+//
+// 状态在接口, worker 和守护协程里面同时读写, 所以用原子操作
+//
+func (d *Device) GetState() DeviceState {
+	return DeviceState(atomic.LoadInt32((*int32)(&d.State)))
+}
+
+func (d *Device) SetState(s DeviceState) {
+	atomic.StoreInt32((*int32)(&d.State), int32(s))
+}
+
+//
+// 守护协程同步状态用, 暂停的设备保持暂停
+//
+func (d *Device) UpdateState(s DeviceState) bool {
+	return updateState((*int32)(&d.State), int32(s), int32(DEV_PAUSE))
+}
+
 // 设备的属性，是个描述结构
 type DeviceProperty struct {
 	Name  string
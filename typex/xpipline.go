@@ -8,18 +8,37 @@ import (
 )
 
 // RunPipline
-//  Run lua as pipline
+//  Run lua as pipline, extra 参数(比如消息元数据)原样传给每一个函数
 //
-func RunPipline(vm *lua.LState, funcs map[string]*lua.LFunction, arg lua.LValue) (lua.LValue, error) {
+func RunPipline(vm *lua.LState, funcs map[string]*lua.LFunction, arg lua.LValue, extra ...lua.LValue) (lua.LValue, error) {
+	return RunPiplineTrace(vm, funcs, arg, nil, extra...)
+}
+
+//
+// 管道每一步执行完以后的回调, 调试规则用
+//
+type PiplineTrace func(step int, values []lua.LValue, err error)
+
+//
+// RunPiplineTrace
+//  和 RunPipline 一样, 但是每一步的结果都会交给 trace
+//
+func RunPiplineTrace(vm *lua.LState, funcs map[string]*lua.LFunction, arg lua.LValue,
+	trace PiplineTrace, extra ...lua.LValue) (lua.LValue, error) {
 	// start 1
 	acc := 1
-	return pipLine(vm, acc, funcs, arg)
+	return pipLine(vm, acc, funcs, arg, trace, extra...)
 }
 
 //
-func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.LValue) (lua.LValue, error) {
+func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.LValue,
+	trace PiplineTrace, extra ...lua.LValue) (lua.LValue, error) {
+	args := append([]lua.LValue{arg}, extra...)
 	if acc == len(funcs) {
-		values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], arg)
+		values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], args...)
+		if trace != nil {
+			trace(acc, values, err0)
+		}
 		if err0 != nil {
 			return nil, err0
 		}
@@ -29,7 +48,10 @@ func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.L
 		})
 
 	}
-	values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], arg)
+	values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], args...)
+	if trace != nil {
+		trace(acc, values, err0)
+	}
 	if err0 != nil {
 		return nil, err0
 	}
@@ -38,7 +60,7 @@ func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.L
 		result := values[1]
 		if next.Type() == lua.LTBool {
 			if next.(lua.LBool) {
-				return pipLine(vm, acc+1, funcs, result)
+				return pipLine(vm, acc+1, funcs, result, trace, extra...)
 			}
 			return result, nil
 		}
@@ -83,7 +105,7 @@ func callLuaFunc(vm *lua.LState, callable *lua.LFunction, args ...lua.LValue) ([
 		Protect: true,
 	}, args...)
 	if err != nil {
-		return nil, errors.New("call function error")
+		return nil, errors.New("call function error:" + err.Error())
 	}
 	vm.Pop(-1)
 	vm.Pop(-2)
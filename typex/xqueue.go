@@ -2,11 +2,19 @@ package typex
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/i4de/rulex/diskqueue"
 	"github.com/i4de/rulex/glogger"
-	"github.com/i4de/rulex/statistics"
 )
 
 //
@@ -14,118 +22,577 @@ import (
 //
 var DefaultDataCacheQueue XQueue
 
+const _SPILL_DRAIN_INTERVAL time.Duration = 100 * time.Millisecond
+
 /*
 *
 * XQueue
 *
  */
 type XQueue interface {
-	GetQueue() chan QueueData
+	// 队列总容量
 	GetSize() int
+	// 当前积压的数据
+	GetDepth() int
 	Push(QueueData) error
+	// 队列和工作协程的运行状态
+	Statistics() QueueStatistics
 }
 
 //
 type QueueData struct {
-	I    *InEnd
-	O    *OutEnd
-	D    *Device
-	E    RuleX
-	Data string
+	I        *InEnd
+	O        *OutEnd
+	D        *Device
+	T        string // 内部主题
+	E        RuleX
+	Data     string
+	Envelope *Envelope // 数据的元数据, 输入数据才有
 }
 
 func (qd QueueData) String() string {
 	return "QueueData@In:" + qd.I.UUID + ", Data:" + qd.Data
 }
 
+//
+// 获取信封, 老的调用方式没有信封的时候用数据新建一个
+//
+func (qd QueueData) GetEnvelope() *Envelope {
+	if qd.Envelope != nil {
+		return qd.Envelope
+	}
+	return NewEnvelope([]byte(qd.Data))
+}
+
+//
+// 分发键: 同一个资源的数据永远进同一个通道, 以此保证单个资源内的顺序
+//
+func (qd QueueData) Key() string {
+	if qd.I != nil {
+		return qd.I.UUID
+	}
+	if qd.D != nil {
+		return qd.D.UUID
+	}
+	if qd.O != nil {
+		return qd.O.UUID
+	}
+	if qd.T != "" {
+		return "topic:" + qd.T
+	}
+	return ""
+}
+
 /*
 *
-* NewXQueue
+* 队列满了以后的处理策略
 *
  */
+const (
+	QUEUE_OVERFLOW_REJECT      string = "reject"      // 拒绝新数据(默认)
+	QUEUE_OVERFLOW_DROP_OLDEST string = "drop_oldest" // 丢弃同一个资源最旧的数据
+	QUEUE_OVERFLOW_BLOCK       string = "block"       // 阻塞等待, 超时以后拒绝
+	QUEUE_OVERFLOW_SPILL       string = "spill"       // 写到磁盘, 空闲的时候再处理
+)
+
+//
+// 检查溢出策略, 空串表示使用默认策略
+//
+func ValidateOverflowPolicy(policy string) error {
+	switch policy {
+	case "", QUEUE_OVERFLOW_REJECT, QUEUE_OVERFLOW_DROP_OLDEST,
+		QUEUE_OVERFLOW_BLOCK, QUEUE_OVERFLOW_SPILL:
+		return nil
+	}
+	return fmt.Errorf("unknown queue overflow policy: %v", policy)
+}
+
+/*
+*
+* 队列配置
+*
+ */
+type QueueConfig struct {
+	MaxQueueSize   int
+	Workers        int
+	OverflowPolicy string
+	BlockTimeout   time.Duration
+	SpillPath      string
+	SpillMaxSize   int64
+}
 
 /*
 *
-* DataCacheQueue
+* 队列统计信息
+*
+ */
+type QueueStatistics struct {
+	Size          int    `json:"size"`          // 总容量
+	Depth         int    `json:"depth"`         // 当前积压
+	Workers       int    `json:"workers"`       // 工作协程数
+	BusyWorkers   int    `json:"busyWorkers"`   // 正在处理数据的协程数
+	Processed     uint64 `json:"processed"`     // 累计处理量
+	LaneDepth     []int  `json:"laneDepth"`     // 每个通道的积压
+	Policy        string `json:"policy"`        // 默认溢出策略
+	Rejected      uint64 `json:"rejected"`      // 被拒绝的数据
+	DroppedOldest uint64 `json:"droppedOldest"` // 为新数据让位被丢弃的旧数据
+	BlockTimeouts uint64 `json:"blockTimeouts"` // 阻塞等待超时被拒绝的数据
+	Spilled       uint64 `json:"spilled"`       // 写到磁盘的数据
+	SpillDepth    int    `json:"spillDepth"`    // 磁盘上积压的数据
+	SpillDropped  uint64 `json:"spillDropped"`  // 磁盘也满了被丢弃的数据
+	DropDepth     int    `json:"dropDepth"`     // drop_oldest 资源溢出等待的数据
+}
+
+/*
+*
+* DataCacheQueue: 按资源分片的队列, 每个通道一个工作协程
 *
  */
 type DataCacheQueue struct {
-	Queue chan QueueData
+	e             RuleX
+	config        QueueConfig
+	lanes         []chan QueueData
+	spills        []*diskqueue.DiskQueue
+	spillLock     sync.Mutex
+	spillBacklog  []int64 // 每个通道磁盘上积压多少, Push 的时候不用去锁磁盘队列
+	dropLock      sync.Mutex
+	drops         []map[string][]QueueData // drop_oldest 的溢出数据, 每个资源单独排队
+	dropBacklog   []int64
+	cancel        context.CancelFunc
+	workers       sync.WaitGroup
+	stopped       int32
+	busy          int32
+	processed     uint64
+	rejected      uint64
+	droppedOldest uint64
+	blockTimeouts uint64
+	spilled       uint64
 }
 
 func (q *DataCacheQueue) GetSize() int {
-	return cap(q.Queue)
+	size := 0
+	for _, lane := range q.lanes {
+		size += cap(lane)
+	}
+	return size
+}
+
+func (q *DataCacheQueue) GetDepth() int {
+	depth := 0
+	for _, lane := range q.lanes {
+		depth += len(lane)
+	}
+	return depth
 }
 
 /*
 *
-* Push
+* Push: 通道满了以后按照溢出策略处理, InEnd 可以单独配置策略
 *
  */
 func (q *DataCacheQueue) Push(d QueueData) error {
-	// 比较数据和容积
-	if len(q.Queue)+1 > q.GetSize() {
-		msg := fmt.Sprintf("attached max queue size, max size is:%v, current size is: %v", q.GetSize(), len(q.Queue)+1)
-		glogger.GLogger.Error(msg)
-		return errors.New(msg)
-	} else {
-		q.Queue <- d
+	if atomic.LoadInt32(&q.stopped) == 1 {
+		return errors.New("queue stopped")
+	}
+	index := q.laneIndex(d.Key())
+	lane := q.lanes[index]
+	policy := q.config.OverflowPolicy
+	if d.I != nil {
+		if p := d.I.OverflowPolicy(); p != "" {
+			policy = p
+		}
+	}
+	// 输出数据不能丢, 只能拒绝
+	if policy == QUEUE_OVERFLOW_DROP_OLDEST && d.O != nil {
+		policy = QUEUE_OVERFLOW_REJECT
+	}
+	// 磁盘上还有积压, 落盘策略的新数据也要排在后面; 通道是按哈希共享的,
+	// 其他策略的数据不会落盘, 不受同一个通道里面别的资源影响
+	if policy == QUEUE_OVERFLOW_SPILL && atomic.LoadInt64(&q.spillBacklog[index]) > 0 {
+		return q.pushSpill(index, d)
+	}
+	// 同理, 自己还有溢出的数据没处理完, 新数据也要排在后面
+	if policy == QUEUE_OVERFLOW_DROP_OLDEST && atomic.LoadInt64(&q.dropBacklog[index]) > 0 {
+		if q.pushDrop(index, d, false) {
+			return nil
+		}
+	}
+	select {
+	case lane <- d:
 		return nil
+	default:
 	}
+	switch policy {
+	case QUEUE_OVERFLOW_DROP_OLDEST:
+		q.pushDrop(index, d, true)
+		return nil
+	case QUEUE_OVERFLOW_BLOCK:
+		timer := time.NewTimer(q.config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case lane <- d:
+			return nil
+		case <-timer.C:
+			atomic.AddUint64(&q.blockTimeouts, 1)
+			return q.overflowError()
+		}
+	case QUEUE_OVERFLOW_SPILL:
+		return q.pushSpill(index, d)
+	}
+	atomic.AddUint64(&q.rejected, 1)
+	return q.overflowError()
+}
+
+func (q *DataCacheQueue) overflowError() error {
+	msg := fmt.Sprintf("attached max queue size, max size is:%v, current size is: %v", q.GetSize(), q.GetDepth()+1)
+	glogger.GLogger.Error(msg)
+	return errors.New(msg)
 }
 
 /*
 *
-* GetQueue
+* Statistics
 *
  */
-func (q *DataCacheQueue) GetQueue() chan QueueData {
-	return q.Queue
+func (q *DataCacheQueue) Statistics() QueueStatistics {
+	laneDepth := make([]int, len(q.lanes))
+	depth := 0
+	for i, lane := range q.lanes {
+		laneDepth[i] = len(lane)
+		depth += laneDepth[i]
+	}
+	dropDepth := 0
+	for i := range q.lanes {
+		dropDepth += int(atomic.LoadInt64(&q.dropBacklog[i]))
+	}
+	spillDepth := 0
+	var spillDropped uint64 = 0
+	for i := range q.lanes {
+		if spill := q.spill(i, false); spill != nil {
+			spillDepth += spill.Len()
+			spillDropped += spill.Dropped()
+		}
+	}
+	return QueueStatistics{
+		Size:          q.GetSize(),
+		Depth:         depth,
+		Workers:       len(q.lanes),
+		BusyWorkers:   int(atomic.LoadInt32(&q.busy)),
+		Processed:     atomic.LoadUint64(&q.processed),
+		LaneDepth:     laneDepth,
+		Policy:        q.config.OverflowPolicy,
+		Rejected:      atomic.LoadUint64(&q.rejected),
+		DroppedOldest: atomic.LoadUint64(&q.droppedOldest),
+		BlockTimeouts: atomic.LoadUint64(&q.blockTimeouts),
+		Spilled:       atomic.LoadUint64(&q.spilled),
+		SpillDepth:    spillDepth,
+		SpillDropped:  spillDropped,
+		DropDepth:     dropDepth,
+	}
+}
+
+func (q *DataCacheQueue) laneIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(q.lanes)))
+}
+
+//
+// 落盘的数据格式: 资源只保存 UUID, 取出来的时候再去引擎里面找
+//
+type spillData struct {
+	Type     string    `json:"t"` // I, D, O
+	UUID     string    `json:"u"`
+	Data     string    `json:"d"`
+	Envelope *Envelope `json:"m,omitempty"`
+}
+
+//
+// 获取通道对应的磁盘队列, create 为 true 的时候没有就新建
+//
+func (q *DataCacheQueue) spill(index int, create bool) *diskqueue.DiskQueue {
+	q.spillLock.Lock()
+	defer q.spillLock.Unlock()
+	if q.spills[index] != nil || !create {
+		return q.spills[index]
+	}
+	spill, err := diskqueue.Open(q.spillDir(index), q.config.SpillMaxSize, 0)
+	if err != nil {
+		glogger.GLogger.Error("Queue spill open error:", err)
+		return nil
+	}
+	q.spills[index] = spill
+	atomic.StoreInt64(&q.spillBacklog[index], int64(spill.Len()))
+	return spill
+}
+
+func (q *DataCacheQueue) spillDir(index int) string {
+	return filepath.Join(q.config.SpillPath, "queue", "lane-"+strconv.Itoa(index))
+}
+
+func (q *DataCacheQueue) pushSpill(index int, d QueueData) error {
+	spill := q.spill(index, true)
+	if spill == nil {
+		atomic.AddUint64(&q.rejected, 1)
+		return q.overflowError()
+	}
+	sd := spillData{UUID: d.Key(), Data: d.Data, Envelope: d.Envelope}
+	switch {
+	case d.I != nil:
+		sd.Type = "I"
+	case d.D != nil:
+		sd.Type = "D"
+	case d.O != nil:
+		sd.Type = "O"
+	case d.T != "":
+		sd.Type = "T"
+		sd.UUID = d.T
+	}
+	b, _ := json.Marshal(sd)
+	if err := spill.Push(b); err != nil {
+		atomic.AddUint64(&q.rejected, 1)
+		return err
+	}
+	atomic.StoreInt64(&q.spillBacklog[index], int64(spill.Len()))
+	atomic.AddUint64(&q.spilled, 1)
+	return nil
 }
 
 //
+// 通道空闲的时候把磁盘上的数据按顺序处理掉
+//
+func (q *DataCacheQueue) drainSpill(index int) {
+	spill := q.spill(index, false)
+	if spill == nil {
+		return
+	}
+	for len(q.lanes[index]) == 0 {
+		msg, err := spill.Peek()
+		if err != nil {
+			return
+		}
+		sd := spillData{}
+		if err := json.Unmarshal(msg.Data, &sd); err == nil {
+			if qd, ok := q.restore(sd); ok {
+				q.process(qd)
+			}
+		}
+		spill.Remove(msg.Seq)
+		atomic.StoreInt64(&q.spillBacklog[index], int64(spill.Len()))
+	}
+}
+
 //
+// drop_oldest 的溢出数据按资源单独排队, 每个资源最多排一个通道那么多,
+// 满了只丢这个资源自己最旧的数据, 不会挤掉同一个通道里别的资源的数据.
+// force 为 false 的时候只有这个资源已经在排队才放进来
 //
-func StartQueue(maxQueueSize int) {
-	DefaultDataCacheQueue = &DataCacheQueue{
-		Queue: make(chan QueueData, maxQueueSize),
+func (q *DataCacheQueue) pushDrop(index int, d QueueData, force bool) bool {
+	key := d.Key()
+	q.dropLock.Lock()
+	defer q.dropLock.Unlock()
+	pending := q.drops[index][key]
+	if len(pending) == 0 && !force {
+		return false
 	}
-	go func(ctx context.Context, xQueue XQueue) {
-		for {
+	if len(pending) >= cap(q.lanes[index]) {
+		pending = pending[1:]
+		atomic.AddUint64(&q.droppedOldest, 1)
+	} else {
+		atomic.AddInt64(&q.dropBacklog[index], 1)
+	}
+	q.drops[index][key] = append(pending, d)
+	return true
+}
+
+//
+// 通道有空位的时候把溢出的数据按顺序放回通道
+//
+func (q *DataCacheQueue) refillDrop(index int) {
+	if atomic.LoadInt64(&q.dropBacklog[index]) == 0 {
+		return
+	}
+	q.dropLock.Lock()
+	defer q.dropLock.Unlock()
+	for key, pending := range q.drops[index] {
+		for len(pending) > 0 {
 			select {
-			case <-ctx.Done():
-				return
-			case qd := <-xQueue.GetQueue():
-				{
-					//
-					// Rulex内置消息队列用法:
-					// 1 进来的数据缓存
-					// 2 出去的消息缓存
-					// 3 设备数据缓存
-					// 只需要判断 in 或者 out 是不是 nil即可
-					//
-					if qd.I != nil {
-						qd.E.RunSourceCallbacks(qd.I, qd.Data)
-						qd.E.RunHooks(qd.Data)
-					}
-					if qd.D != nil {
-						qd.E.RunDeviceCallbacks(qd.D, qd.Data)
-						qd.E.RunHooks(qd.Data)
-					}
-					if qd.O != nil {
-						v, ok := qd.E.AllOutEnd().Load(qd.O.UUID)
-						if ok {
-							if _, err := v.(*OutEnd).Target.To(qd.Data); err != nil {
-								glogger.GLogger.Error(err)
-								statistics.IncOutFailed()
-							} else {
-								statistics.IncOut()
-
-							}
-						}
-					}
+			case q.lanes[index] <- pending[0]:
+				pending = pending[1:]
+				atomic.AddInt64(&q.dropBacklog[index], -1)
+				continue
+			default:
+			}
+			break
+		}
+		if len(pending) == 0 {
+			delete(q.drops[index], key)
+			continue
+		}
+		q.drops[index][key] = pending
+		return
+	}
+}
+
+//
+// 根据落盘的 UUID 找回资源, 资源已经被删掉的数据直接丢弃
+//
+func (q *DataCacheQueue) restore(sd spillData) (QueueData, bool) {
+	qd := QueueData{E: q.e, Data: sd.Data, Envelope: sd.Envelope}
+	switch sd.Type {
+	case "I":
+		qd.I = q.e.GetInEnd(sd.UUID)
+		return qd, qd.I != nil
+	case "D":
+		qd.D = q.e.GetDevice(sd.UUID)
+		return qd, qd.D != nil
+	case "O":
+		qd.O = q.e.GetOutEnd(sd.UUID)
+		return qd, qd.O != nil
+	case "T":
+		qd.T = sd.UUID
+		return qd, true
+	}
+	return qd, false
+}
+
+func (q *DataCacheQueue) process(qd QueueData) {
+	atomic.AddInt32(&q.busy, 1)
+	processQueueData(qd)
+	atomic.AddInt32(&q.busy, -1)
+	atomic.AddUint64(&q.processed, 1)
+}
+
+//
+// 停止工作协程: 先把通道里剩下的数据处理完再退出, 磁盘上的数据下次启动再处理
+//
+func (q *DataCacheQueue) Stop() {
+	if !atomic.CompareAndSwapInt32(&q.stopped, 0, 1) {
+		return
+	}
+	q.cancel()
+	q.workers.Wait()
+	q.spillLock.Lock()
+	defer q.spillLock.Unlock()
+	for _, spill := range q.spills {
+		if spill != nil {
+			if err := spill.Sync(); err != nil {
+				glogger.GLogger.Error("Queue spill sync error:", err)
+			}
+		}
+	}
+}
+
+//
+// 处理掉通道里剩下的数据, 处理过程中规则推进来的输出数据也一起处理
+//
+func (q *DataCacheQueue) drain(index int) {
+	for {
+		q.refillDrop(index)
+		select {
+		case qd := <-q.lanes[index]:
+			q.process(qd)
+		default:
+			return
+		}
+	}
+}
+
+//
+// 停止默认队列
+//
+func StopQueue() {
+	if queue, ok := DefaultDataCacheQueue.(*DataCacheQueue); ok {
+		queue.Stop()
+	}
+}
+
+//
+// 启动队列: workers 个工作协程并行处理, 同一个资源的数据顺序执行,
+// 已经有队列在跑的话先停掉
+//
+func StartQueue(e RuleX, config QueueConfig) error {
+	if err := ValidateOverflowPolicy(config.OverflowPolicy); err != nil {
+		return err
+	}
+	StopQueue()
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.OverflowPolicy == "" {
+		config.OverflowPolicy = QUEUE_OVERFLOW_REJECT
+	}
+	laneSize := config.MaxQueueSize / config.Workers
+	if laneSize < 1 {
+		laneSize = 1
+	}
+	queue := &DataCacheQueue{
+		e:            e,
+		config:       config,
+		lanes:        make([]chan QueueData, config.Workers),
+		spills:       make([]*diskqueue.DiskQueue, config.Workers),
+		spillBacklog: make([]int64, config.Workers),
+		drops:        make([]map[string][]QueueData, config.Workers),
+		dropBacklog:  make([]int64, config.Workers),
+	}
+	for i := range queue.lanes {
+		queue.lanes[i] = make(chan QueueData, laneSize)
+		queue.drops[i] = map[string][]QueueData{}
+		// 上次没处理完的磁盘数据
+		if _, err := os.Stat(queue.spillDir(i)); err == nil {
+			queue.spill(i, true)
+		}
+	}
+	DefaultDataCacheQueue = queue
+	ctx, cancel := context.WithCancel(GCTX)
+	queue.cancel = cancel
+	for i := range queue.lanes {
+		queue.workers.Add(1)
+		go func(ctx context.Context, q *DataCacheQueue, index int) {
+			defer q.workers.Done()
+			ticker := time.NewTicker(_SPILL_DRAIN_INTERVAL)
+			defer ticker.Stop()
+			lane := q.lanes[index]
+			for {
+				select {
+				case <-ctx.Done():
+					q.drain(index)
+					return
+				case qd := <-lane:
+					q.process(qd)
+				case <-ticker.C:
 				}
+				q.refillDrop(index)
+				q.drainSpill(index)
 			}
+		}(ctx, queue, i)
+	}
+	return nil
+}
+
+//
+// Rulex内置消息队列用法:
+// 1 进来的数据缓存
+// 2 出去的消息缓存
+// 3 设备数据缓存
+// 只需要判断 in 或者 out 是不是 nil即可
+//
+func processQueueData(qd QueueData) {
+	if qd.I != nil {
+		qd.E.RunSourceCallbacks(qd.I, qd.GetEnvelope())
+		qd.E.RunHooks(qd.Data)
+	}
+	if qd.D != nil {
+		qd.E.RunDeviceCallbacks(qd.D, qd.GetEnvelope())
+		qd.E.RunHooks(qd.Data)
+	}
+	if qd.T != "" {
+		qd.E.RunTopicCallbacks(qd.T, qd.GetEnvelope())
+	}
+	if qd.O != nil {
+		v, ok := qd.E.AllOutEnd().Load(qd.O.UUID)
+		if ok {
+			DeliverToOutEnd(v.(*OutEnd), qd.Data)
 		}
-	}(GCTX, DefaultDataCacheQueue)
+	}
 }
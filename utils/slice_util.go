@@ -0,0 +1,13 @@
+package utils
+
+//
+// 字符串切片里面是否包含某个值
+//
+func SContains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}